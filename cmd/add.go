@@ -5,9 +5,11 @@ package cmd
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/josephlewis42/paraphrase/paraphrase/provider"
@@ -22,56 +24,84 @@ var (
 	addCmdNamespace = time.Now().UTC().Format(time.RFC3339)
 	addCmdDryRun    bool
 	addCmdMatch     string
+	addCmdMeta      []string
+	addCmdTags      []string
+	addCmdManifest  string
 )
 
 func init() {
 	addCmd.Flags().StringVar(&addCmdNamespace, "namespace", addCmdNamespace, "sets the namespace of the loaded files, by default this will be a timestamp")
 	addCmd.Flags().BoolVar(&addCmdDryRun, "dry", false, "list files to add rather than adding them")
 	addCmd.Flags().StringVarP(&addCmdMatch, "match", "m", WILDCARD, "only add items matching the given glob")
+	addCmd.Flags().StringArrayVar(&addCmdMeta, "meta", nil, "attach KEY=VALUE metadata to the added documents, may be repeated")
+	addCmd.Flags().StringArrayVar(&addCmdTags, "tag", nil, "tag the added documents, may be repeated")
+	addCmd.Flags().StringVar(&addCmdManifest, "manifest", "", "add the files listed in a CSV or JSON Lines manifest")
 }
 
 var addCmd = &cobra.Command{
-	Use:   "add (-|[PATH]...)",
+	Use:   "add (-|--manifest FILE|[PATH]...)",
 	Short: "Add a document to the database or reads from stdin (use -)",
 	Long: `Adds a document with the given path to the database.
-Use add - to read from stdin.`,
+Use add - to read from stdin.
+
+Attach metadata and tags to every added document:
+
+	paraphrase add --namespace hw3 --meta student=jdoe --meta section=2 --tag late submissions/jdoe
+
+Add the files listed in a manifest. Manifests are CSV files with a header
+row or JSON Lines files with one object per line. Each row needs a path and
+may have a namespace, an author (or owner) and tags, other columns become
+metadata. Relative paths are read from the manifest's directory. Files with
+the same author are grouped together in reports.
+
+	paraphrase add --namespace hw3 --manifest submissions.csv
+
+	path,author,section,tags
+	jdoe/Main.java,jdoe,2,late;resubmitted
+	jdoe/Util.java,jdoe,2,
+	asmith/Main.java,asmith,1,
+
+	{"path": "jdoe/Main.java", "author": "jdoe", "tags": ["late"], "metadata": {"section": 2}}
+`,
 	PreRunE: openDb,
 	RunE: func(cmd *cobra.Command, args []string) (err error) {
 
-		if len(args) == 0 {
-			return errors.New("You must specify at least one file/directory or - to read from stdin")
+		if len(args) == 0 && addCmdManifest == "" {
+			return errors.New("You must specify at least one file/directory, - to read from stdin or a manifest")
+		}
+
+		// the default is from when the process started, which may be a
+		// daemon that has been running for days
+		if !cmd.Flags().Changed("namespace") {
+			addCmdNamespace = currentTime()
 		}
 
 		log.Printf("Using namespace %s\n", addCmdNamespace)
 
 		var mainProducer provider.DocumentProducer
 
+		if addCmdManifest != "" {
+			mainProducer, err = newManifestProducer(addCmdManifest, addCmdNamespace)
+			if err != nil {
+				return err
+			}
+		}
+
 		if len(args) == 1 && args[0] == "-" {
-			mainProducer, err = provider.NewFileListProducer(addCmdNamespace, os.Stdin)
+			tmp, err := provider.NewFileListProducer(addCmdNamespace, os.Stdin)
 			if err != nil {
 				return err
 			}
 
+			mainProducer = provider.NewJoinerProducer(mainProducer, tmp)
+
 		} else {
 			for _, path := range args {
-				absPath, err := filepath.Abs(path)
+				tmp, err := newTreeProducer(path, addCmdNamespace)
 				if err != nil {
 					return err
 				}
 
-				prefixLen := len(absPath)
-				if isdir, err := isDirectory(path); err == nil && !isdir {
-					// The trailing separator gets removed so we subtract
-					// off the length of the file from the whole path instead
-					// just in case there's an OS with a funky file separator
-					// pattern.
-					prefixLen = len(absPath) - len(filepath.Base(absPath))
-				}
-
-				log.Printf("Searching recursively in %s\n", absPath)
-
-				tmp := provider.NewTreeWalkerProducer(absPath, addCmdNamespace, true, prefixLen)
-
 				mainProducer = provider.NewJoinerProducer(mainProducer, tmp)
 			}
 		}
@@ -84,6 +114,15 @@ Use add - to read from stdin.`,
 			}
 		}
 
+		if len(addCmdMeta) > 0 || len(addCmdTags) > 0 {
+			metadata, err := parseMetadata(addCmdMeta)
+			if err != nil {
+				return err
+			}
+
+			mainProducer = provider.NewMetadataWrapper(metadata, addCmdTags, mainProducer)
+		}
+
 		if addCmdDryRun {
 			mainProducer = provider.NewDummyProducer(mainProducer, os.Stdout)
 		}
@@ -94,6 +133,61 @@ Use add - to read from stdin.`,
 	},
 }
 
+// newTreeProducer walks the file or directory at path, documents get paths
+// relative to it.
+func newTreeProducer(path, namespace string) (provider.DocumentProducer, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	prefixLen := len(absPath)
+	if isdir, err := isDirectory(path); err == nil && !isdir {
+		// The trailing separator gets removed so we subtract
+		// off the length of the file from the whole path instead
+		// just in case there's an OS with a funky file separator
+		// pattern.
+		prefixLen = len(absPath) - len(filepath.Base(absPath))
+	}
+
+	log.Printf("Searching recursively in %s\n", absPath)
+
+	return provider.NewTreeWalkerProducer(absPath, namespace, true, prefixLen), nil
+}
+
+// newManifestProducer reads the manifest at path, relative paths in it are
+// resolved against its directory.
+func newManifestProducer(path, namespace string) (provider.DocumentProducer, error) {
+	format, err := provider.ManifestFormat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return provider.NewManifestProducer(namespace, format, filepath.Dir(path), file)
+}
+
+// parseMetadata converts KEY=VALUE pairs to a map.
+func parseMetadata(pairs []string) (map[string]string, error) {
+	metadata := make(map[string]string)
+
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("Metadata must look like KEY=VALUE, got %q", pair)
+		}
+
+		metadata[kv[0]] = kv[1]
+	}
+
+	return metadata, nil
+}
+
 func currentTime() string {
 	return time.Now().UTC().Format(time.RFC3339)
 }
@@ -0,0 +1,72 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	backupCompress bool
+	backupChecksum bool
+)
+
+func init() {
+	backupCmd.Flags().BoolVarP(&backupCompress, "gzip", "z", false, "gzip the backup")
+	backupCmd.Flags().BoolVar(&backupChecksum, "checksum", false, "write a sha256 checksum of the backup to <dest>.sha256")
+}
+
+var backupCmd = &cobra.Command{
+	Use:   "backup [dest]",
+	Short: "Writes a consistent copy of the database to a file",
+	Long: `Writes a consistent copy of the database to a file.
+
+The copy is taken from a snapshot of a read-only open so other commands
+reading the database can keep running while the backup is written. Once it's
+written the database is briefly opened for writing to record the backup and
+its sha256 in the changelog, waiting up to --lock-timeout for other commands
+to finish. If the database stays locked the backup is kept but the command
+fails so the missing record doesn't go unnoticed.
+
+Make a compressed backup with a checksum:
+
+	paraphrase backup -z --checksum nightly.ppdb.gz
+`,
+	PreRunE: openDbReadOnly,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("You must specify one file to write the backup to")
+		}
+
+		dest, err := filepath.Abs(args[0])
+		if err != nil {
+			return err
+		}
+
+		sum, err := db.Backup(dest, backupCompress, backupChecksum)
+		if err != nil {
+			return err
+		}
+
+		// the daemon's database is already writable
+		if db.ReadOnly() {
+			db.Close()
+			db, err = openLocalDb(cmd, false)
+		}
+
+		if err == nil {
+			err = db.LogBackup(dest, sum, backupCompress)
+		}
+
+		if err != nil {
+			return fmt.Errorf("Backed up to %v but couldn't record it in the changelog: %v", dest, err)
+		}
+
+		return nil
+	},
+}
@@ -19,7 +19,7 @@ var catCmd = &cobra.Command{
 	Long: `Gets the bodies of documents based on their properties.
 This is a special case of the "find" command with the format always set
 to ` + catCmdFormat,
-	PreRunE: openDb,
+	PreRunE: openDbReadOnly,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		findOutputFormat = catCmdFormat
 		return findCmd.RunE(cmd, args)
@@ -4,17 +4,161 @@
 package cmd
 
 import (
+	"fmt"
 	"os"
 
+	"github.com/josephlewis42/paraphrase/paraphrase"
 	"github.com/spf13/cobra"
 )
 
+var (
+	changelogSince      string
+	changelogUntil      string
+	changelogUsers      []string
+	changelogOperations []string
+	changelogDocument   int64
+	changelogNamespace  string
+	changelogJson       bool
+	changelogHead       string
+)
+
+func init() {
+	changelogCmd.Flags().StringVar(&changelogSince, "since", "", "only show changes on or after this date e.g. 2017-06-01")
+	changelogCmd.Flags().StringVar(&changelogUntil, "until", "", "only show changes on or before this date e.g. 2017-06-30")
+	changelogCmd.Flags().StringSliceVarP(&changelogUsers, "user", "u", nil, "only show changes made by these users")
+	changelogCmd.Flags().StringSliceVarP(&changelogOperations, "operation", "o", nil, "only show these operations e.g. add-documents,create-document")
+	changelogCmd.Flags().Int64VarP(&changelogDocument, "id", "i", 0, "only show changes affecting the document with this id")
+	changelogCmd.Flags().StringVarP(&changelogNamespace, "namespace", "n", "", "only show changes affecting documents in this namespace")
+	changelogCmd.Flags().BoolVar(&changelogJson, "json", false, "write the changes with all their details as JSON")
+
+	changelogVerifyCmd.Flags().StringVar(&changelogHead, "head", "", "a head hash from an earlier verification that must still be in the changelog")
+
+	changelogCmd.AddCommand(changelogVerifyCmd)
+}
+
 var changelogCmd = &cobra.Command{
-	Use:     "changelog",
-	Short:   "Writes information about changes to the database.",
-	Long:    `Writes information about changes to the database.`,
-	PreRunE: openDb,
-	Run: func(cmd *cobra.Command, args []string) {
-		db.WriteChanges(os.Stdout)
+	Use:   "changelog",
+	Short: "Writes information about changes to the database.",
+	Long: `Writes information about changes to the database. Each change records who
+made it, when, the operation, the documents and namespaces it affected, how
+long it took, whether it failed, the version of paraphrase and the database
+settings at the time. Changes made before this was recorded only have a
+description.
+
+OPERATIONS:
+
+	create-database, save-settings, migrate, add-documents, create-document,
+	update-metadata, delete-document, import, report, backup, restore, compact,
+	fsck, verdict, sync, hook
+
+EXAMPLES:
+
+Find out when a document entered the database:
+
+	paraphrase changelog -i 1234 -o create-document
+
+Show everything added to hw3 during June:
+
+	paraphrase changelog -n hw3 --since 2017-06-01 --until 2017-06-30
+
+Write every detail of the changes as JSON:
+
+	paraphrase changelog --json
+
+Check nobody has tampered with the changelog, see "changelog verify --help":
+
+	paraphrase changelog verify
+`,
+	PreRunE: openDbReadOnly,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		filter := paraphrase.ChangeFilter{
+			Users:     changelogUsers,
+			Document:  changelogDocument,
+			Namespace: changelogNamespace,
+		}
+
+		var err error
+		if changelogSince != "" {
+			filter.Since, _, err = paraphrase.ParseDate(changelogSince)
+			if err != nil {
+				return err
+			}
+		}
+
+		if changelogUntil != "" {
+			_, filter.Until, err = paraphrase.ParseDate(changelogUntil)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, name := range changelogOperations {
+			op, err := paraphrase.ParseOperation(name)
+			if err != nil {
+				return err
+			}
+
+			filter.Operations = append(filter.Operations, op)
+		}
+
+		changes, err := db.Changes(filter)
+		if err != nil {
+			return fmt.Errorf("Could not read the changelog: %s", err)
+		}
+
+		if changelogJson {
+			return paraphrase.WriteChangesJson(os.Stdout, changes)
+		}
+
+		paraphrase.WriteChanges(os.Stdout, changes)
+		return nil
+	},
+}
+
+var changelogVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "(read only) Checks the changelog hasn't been tampered with",
+	Long: `Checks the changelog hasn't been tampered with. Each entry holds a hash of
+the entry before it and of its own content, so editing, deleting or
+reordering entries breaks the chain. Each document is also checked against
+the entry recording its creation and its body against its SHA1.
+
+Entries removed from the end leave a valid chain behind. To catch that, keep
+the head hash printed at the end somewhere the database's users can't change,
+e.g. in an email, and pass it to --head next time.
+
+EXAMPLES:
+
+	paraphrase changelog verify --head 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+`,
+	PreRunE: openDbReadOnly,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		result, err := db.VerifyChanges(changelogHead)
+		if err != nil {
+			return err
+		}
+
+		for _, problem := range result.Problems {
+			switch {
+			case problem.Change != 0:
+				fmt.Printf("Entry %d: %s\n", problem.Change, problem.Detail)
+			case problem.Document != 0:
+				fmt.Printf("Document %d: %s\n", problem.Document, problem.Detail)
+			default:
+				fmt.Println(problem.Detail)
+			}
+		}
+
+		fmt.Printf("Checked %d entries and %d documents", result.Entries, result.Documents)
+		if result.Unrecorded > 0 {
+			fmt.Printf(", %d documents were added before their creation was recorded", result.Unrecorded)
+		}
+		fmt.Printf("\nHead: %s\n", result.Head)
+
+		if len(result.Problems) > 0 {
+			return fmt.Errorf("Found %d signs of tampering", len(result.Problems))
+		}
+
+		return nil
 	},
 }
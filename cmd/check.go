@@ -0,0 +1,135 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/josephlewis42/paraphrase/paraphrase"
+	"github.com/spf13/cobra"
+)
+
+var (
+	checkNamespaces []string
+	checkThreshold  float64
+	checkFormat     string
+	checkGitRange   string
+)
+
+func init() {
+	checkCmd.Flags().StringSliceVarP(&checkNamespaces, "namespace", "n", nil, "only compare against namespaces matching these globs, defaults to all but licenses")
+	checkCmd.Flags().Float64Var(&checkThreshold, "threshold", 0.5, "fail if this fraction of a file is found in a single document")
+	checkCmd.Flags().StringVar(&checkFormat, "format", paraphrase.FindingsText, "output format: text, json or sarif")
+	checkCmd.Flags().StringVar(&checkGitRange, "git-range", "", "check the files added or modified in this git range e.g. origin/master...HEAD")
+}
+
+var checkCmd = &cobra.Command{
+	Use:   "check [FILE]...",
+	Short: "Fails if files match protected documents",
+	Long: `Checks changed files against the documents in the database and exits with
+an error if any file contains at least --threshold of a single document's
+fingerprints. Files are winnowed with the database's settings and nothing is
+added to the database.
+
+Files can be given as arguments, as a list on stdin using "-", or found with
+a git diff range.
+
+EXAMPLES:
+
+Fail a pull request that copies from the "vendor-private" namespace:
+
+	paraphrase check -n "vendor-private*" --git-range origin/master...HEAD
+
+Write a SARIF report for code scanning:
+
+	git diff --name-only HEAD~1 | paraphrase check --format sarif - > check.sarif
+`,
+	PreRunE: openDbReadOnly,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		paths, err := checkPaths(args)
+		if err != nil {
+			return err
+		}
+
+		if len(paths) == 0 {
+			return errors.New("You must specify files to check, - to read them from stdin, or --git-range")
+		}
+
+		var findings []paraphrase.Finding
+		for _, path := range paths {
+			body, err := ioutil.ReadFile(path)
+			if os.IsNotExist(err) {
+				log.Printf("Skipping %v, it no longer exists\n", path)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			found, err := db.CheckDocument(path, body, checkNamespaces, checkThreshold)
+			if err != nil {
+				return err
+			}
+
+			findings = append(findings, found...)
+		}
+
+		err = paraphrase.WriteFindings(os.Stdout, findings, checkFormat, Version)
+		if err != nil {
+			return err
+		}
+
+		if len(findings) > 0 {
+			return fmt.Errorf("%d matches have a containment of at least %v", len(findings), checkThreshold)
+		}
+
+		return nil
+	},
+}
+
+// checkPaths gets the files named in args, on stdin if an arg is "-" and in
+// the git range if one was given.
+func checkPaths(args []string) ([]string, error) {
+	var paths []string
+
+	for _, arg := range args {
+		if arg != "-" {
+			paths = append(paths, arg)
+			continue
+		}
+
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				paths = append(paths, line)
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if checkGitRange != "" {
+		out, err := exec.Command("git", "diff", "--name-only", "--diff-filter=AM", checkGitRange).Output()
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't list files in %v: %s", checkGitRange, err)
+		}
+
+		for _, line := range strings.Split(string(out), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				paths = append(paths, line)
+			}
+		}
+	}
+
+	return paths, nil
+}
@@ -4,73 +4,36 @@
 package cmd
 
 import (
-	"io/ioutil"
-	"log"
+	"fmt"
 	"os"
-	"path"
+	"text/tabwriter"
 
-	"github.com/boltdb/bolt"
 	"github.com/josephlewis42/paraphrase/paraphrase"
 	"github.com/spf13/cobra"
 )
 
 var compactCmd = &cobra.Command{
 	Use:   "compact",
-	Short: "Compacts the database keeping the original and the newly compacted one",
-	Long:  `Compacts the database keeping the original and the newly compacted one`,
-	RunE: func(cmd *cobra.Command, args []string) error {
-		source := paraphrase.FindDbPath(projectBase)
-
-		directory, err := ioutil.TempDir("", "compaction")
-		if err != nil {
-			return err
-		}
-
-		//defer os.Remove(directory)
-
-		log.Println("Opening database")
-		db, err := bolt.Open(source, 0600, nil)
-		if err != nil {
-			return err
-		}
-		defer db.Close()
-
-		tmpFilePath := path.Join(directory, "temp.bolt")
-		log.Printf("Creating temporary file %v\n", tmpFilePath)
-		tmpFile, err := os.Create(tmpFilePath)
-		if err != nil {
-			log.Printf("Error %v\n", err)
-			return err
-		}
+	Short: "Compacts the database, reclaiming unused space",
+	Long: `Compacts the database by copying the live data into a fresh file,
+verifying the copy and atomically replacing the original with it.
 
-		defer tmpFile.Close()
-		log.Println("Starting transaction")
-
-		tx, err := db.Begin(false)
-		if err != nil {
-			return err
-		}
-
-		log.Println("Dumping file")
-		_, err = tx.WriteTo(tmpFile)
-		if err != nil {
-			return err
-		}
-
-		tmpFile.Close()
-		err = db.Close()
-		if err != nil {
-			return err
-		}
-
-		log.Printf("Moving %v to %v.orig\n", source, source)
-		err = os.Rename(source, source+".orig")
+The database can't be in use by any other paraphrase command while it's
+being compacted.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		stats, err := paraphrase.Compact(projectBase)
 		if err != nil {
 			return err
 		}
-		log.Printf("Moving %v to %v\n", tmpFilePath, source)
 
-		return os.Rename(tmpFilePath, source)
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, '\t', 0)
+		fmt.Fprintf(w, "Documents\t%v\n", stats.Documents)
+		fmt.Fprintf(w, "Distinct Hashes\t%v\n", stats.Hashes)
+		fmt.Fprintf(w, "Size Before\t%v bytes\n", stats.OriginalSize)
+		fmt.Fprintf(w, "Size After\t%v bytes\n", stats.CompactedSize)
+		fmt.Fprintf(w, "Saved\t%v bytes\n", stats.Saved())
+		w.Flush()
 
+		return nil
 	},
 }
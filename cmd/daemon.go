@@ -0,0 +1,378 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/josephlewis42/paraphrase/paraphrase"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+const (
+	daemonSocketExt = ".sock"
+
+	// runLocallyAnnotation marks commands that are never sent to the
+	// daemon, the value says why.
+	runLocallyAnnotation = "run-locally"
+
+	// frames sent back to clients
+	frameStdout byte = 'o'
+	frameStderr byte = 'e'
+	frameExit   byte = 'x'
+)
+
+var (
+	// daemonDb is the database the daemon keeps open, commands it runs use it
+	// rather than opening their own.
+	daemonDb *paraphrase.ParaphraseDb
+	// daemonLock makes commands run one at a time, they share the process's
+	// working directory and standard streams.
+	daemonLock sync.Mutex
+)
+
+// daemonRequest is a command sent to the daemon.
+type daemonRequest struct {
+	Args []string `json:"args"`
+	Dir  string   `json:"dir"`
+	// Stdin is only sent for commands reading it, see forwardToDaemon.
+	Stdin []byte `json:"stdin,omitempty"`
+}
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Keeps the database open and runs other paraphrase commands sent to it",
+	Long: `Opens the database for writing and keeps it open, running the commands of
+other paraphrase invocations until interrupted. While a daemon is running
+commands using the database are sent to it over a socket next to the
+database and run there one at a time, their output and exit status are
+passed back as if they had run locally. Searches and finds no longer wait on
+the database being opened, migrated or locked by an add, they just queue
+behind it.
+
+Only the user running the daemon can connect to its socket, commands from
+anyone else are refused.
+
+Commands reading stdin (given "-" as an argument) get what was piped to them.
+The interactive triage command and the never ending watch command aren't
+sent to the daemon. Triage finds the database locked and watch holds on to
+the files it finds until the daemon is stopped. Use --no-daemon to open the
+database directly.
+
+EXAMPLES:
+
+Serve the database in the background:
+
+	paraphrase daemon &
+	paraphrase add --namespace hw3 submissions/
+	paraphrase search -f Main.java
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		socket := daemonSocketPath()
+
+		if conn, err := net.Dial("unix", socket); err == nil {
+			conn.Close()
+			return fmt.Errorf("A daemon is already serving the database on %s", socket)
+		}
+
+		var err error
+		daemonDb, err = paraphrase.OpenWithOptions(projectBase, paraphrase.OpenOptions{
+			Timeout: lockTimeout,
+			Command: commandName(cmd),
+		})
+		if err != nil {
+			return err
+		}
+		defer daemonDb.Close()
+
+		// the database lock is held so any socket left behind is stale
+		os.Remove(socket)
+
+		listener, err := listenPrivate(socket)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(socket)
+
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			log.Println("Stopping daemon")
+			listener.Close()
+		}()
+
+		log.Printf("Serving the database on %s\n", socket)
+
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				// closed by the signal handler
+				return nil
+			}
+
+			go serveDaemonConn(conn)
+		}
+	},
+}
+
+func daemonSocketPath() string {
+	return paraphrase.FindDbPath(projectBase) + daemonSocketExt
+}
+
+// forwardToDaemon runs the command on the daemon serving the database and
+// exits with its status. It returns if no daemon is running.
+func forwardToDaemon(cmd *cobra.Command) {
+	if _, ok := cmd.Annotations[runLocallyAnnotation]; ok {
+		return
+	}
+
+	conn, err := net.Dial("unix", daemonSocketPath())
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	dir, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	request := daemonRequest{Args: os.Args[1:], Dir: dir}
+
+	// reading stdin otherwise could wait forever on a terminal
+	for _, arg := range request.Args {
+		if arg == "-" {
+			request.Stdin, err = ioutil.ReadAll(os.Stdin)
+			if err != nil {
+				log.Fatal(err)
+			}
+			break
+		}
+	}
+
+	err = json.NewEncoder(conn).Encode(request)
+	if err != nil {
+		log.Fatalf("Could not send the command to the daemon: %s", err)
+	}
+
+	for {
+		kind, data, err := readFrame(conn)
+		if err != nil {
+			log.Fatalf("Lost the connection to the daemon: %s", err)
+		}
+
+		switch kind {
+		case frameStdout:
+			os.Stdout.Write(data)
+		case frameStderr:
+			os.Stderr.Write(data)
+		case frameExit:
+			os.Exit(int(int32(binary.BigEndian.Uint32(data))))
+		}
+	}
+}
+
+// serveDaemonConn runs one command for a client.
+func serveDaemonConn(conn net.Conn) {
+	defer conn.Close()
+
+	err := checkPeer(conn)
+	if err != nil {
+		log.Printf("Refusing connection: %s\n", err)
+		return
+	}
+
+	var request daemonRequest
+	err = json.NewDecoder(conn).Decode(&request)
+	if err == io.EOF {
+		// another daemon checking if this one is running
+		return
+	}
+	if err != nil {
+		log.Printf("Bad request from client: %s\n", err)
+		return
+	}
+
+	daemonLock.Lock()
+	defer daemonLock.Unlock()
+
+	log.Printf("Running %s\n", strings.Join(request.Args, " "))
+
+	var writeLock sync.Mutex
+	frames := func(kind byte) io.Writer {
+		return frameWriter(func(data []byte) error {
+			writeLock.Lock()
+			defer writeLock.Unlock()
+			return writeFrame(conn, kind, data)
+		})
+	}
+
+	code := runForClient(request, frames(frameStdout), frames(frameStderr))
+
+	status := make([]byte, 4)
+	binary.BigEndian.PutUint32(status, uint32(int32(code)))
+	writeFrame(conn, frameExit, status)
+}
+
+// runForClient runs the request's command with the process's working
+// directory and standard streams swapped for the client's, returning the exit
+// status main would have.
+func runForClient(request daemonRequest, stdout, stderr io.Writer) int {
+	restore, err := redirectProcess(request, stdout, stderr)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return -1
+	}
+	defer restore()
+
+	resetFlags(RootCmd)
+	RootCmd.SetArgs(request.Args)
+
+	if err := RootCmd.Execute(); err != nil {
+		fmt.Println(err)
+		return -1
+	}
+
+	return 0
+}
+
+// redirectProcess points the working directory, standard streams and log
+// output at the client's until restore is called.
+func redirectProcess(request daemonRequest, stdout, stderr io.Writer) (restore func(), err error) {
+	oldDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Chdir(request.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	oldStdin, oldStdout, oldStderr := os.Stdin, os.Stdout, os.Stderr
+
+	var copiers sync.WaitGroup
+	pipe := func(w io.Writer) *os.File {
+		r, pw, err := os.Pipe()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		copiers.Add(1)
+		go func() {
+			defer copiers.Done()
+			io.Copy(w, r)
+			r.Close()
+		}()
+
+		return pw
+	}
+
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		io.Copy(inW, bytes.NewReader(request.Stdin))
+		inW.Close()
+	}()
+
+	os.Stdin = inR
+	os.Stdout = pipe(stdout)
+	os.Stderr = pipe(stderr)
+	log.SetOutput(os.Stderr)
+
+	return func() {
+		os.Stdout.Close()
+		os.Stderr.Close()
+		copiers.Wait()
+		inR.Close()
+
+		os.Stdin, os.Stdout, os.Stderr = oldStdin, oldStdout, oldStderr
+		log.SetOutput(os.Stderr)
+		os.Chdir(oldDir)
+	}, nil
+}
+
+// resetFlags puts every flag back to its default, cobra only sets the flags
+// given so values would otherwise carry over between commands.
+func resetFlags(cmd *cobra.Command) {
+	reset := func(flag *pflag.Flag) {
+		if slice, ok := flag.Value.(pflag.SliceValue); ok {
+			var values []string
+			if defaults := strings.Trim(flag.DefValue, "[]"); defaults != "" {
+				values = strings.Split(defaults, ",")
+			}
+			slice.Replace(values)
+		} else {
+			flag.Value.Set(flag.DefValue)
+		}
+
+		flag.Changed = false
+	}
+
+	cmd.Flags().VisitAll(reset)
+	cmd.PersistentFlags().VisitAll(reset)
+
+	for _, child := range cmd.Commands() {
+		resetFlags(child)
+	}
+}
+
+// frameWriter sends everything written to it as a frame.
+type frameWriter func(data []byte) error
+
+func (w frameWriter) Write(data []byte) (int, error) {
+	err := w(data)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+// writeFrame writes the kind, the length of the data and the data.
+func writeFrame(w io.Writer, kind byte, data []byte) error {
+	header := make([]byte, 5)
+	header[0] = kind
+	binary.BigEndian.PutUint32(header[1:], uint32(len(data)))
+
+	_, err := w.Write(append(header, data...))
+	return err
+}
+
+func readFrame(r io.Reader) (kind byte, data []byte, err error) {
+	header := make([]byte, 5)
+	_, err = io.ReadFull(r, header)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	data = make([]byte, binary.BigEndian.Uint32(header[1:]))
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if header[0] != frameStdout && header[0] != frameStderr && header[0] != frameExit {
+		return 0, nil, errors.New("unknown frame")
+	}
+
+	return header[0], data, nil
+}
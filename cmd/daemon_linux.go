@@ -0,0 +1,53 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// listenPrivate listens on a socket only the current user can connect to, the
+// umask is tightened while it's created so there's no window where others
+// could.
+func listenPrivate(socket string) (net.Listener, error) {
+	old := syscall.Umask(0077)
+	defer syscall.Umask(old)
+
+	return net.Listen("unix", socket)
+}
+
+// checkPeer makes sure the client is run by the same user as the daemon,
+// anyone else could run commands such as hooks add as the daemon's user.
+func checkPeer(conn net.Conn) error {
+	unix, ok := conn.(*net.UnixConn)
+	if !ok {
+		return fmt.Errorf("unexpected connection type %T", conn)
+	}
+
+	raw, err := unix.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return err
+	}
+	if credErr != nil {
+		return credErr
+	}
+
+	if int(cred.Uid) != os.Getuid() {
+		return fmt.Errorf("rejected client PID %d run by uid %d", cred.Pid, cred.Uid)
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/josephlewis42/paraphrase/paraphrase"
+	"github.com/josephlewis42/paraphrase/paraphrase/provider"
+	"github.com/spf13/cobra"
+)
+
+var (
+	diffDirsThreshold float64
+	diffDirsTop       int
+	diffDirsNoMatrix  bool
+	diffDirsMatch     string
+)
+
+func init() {
+	diffDirsCmd.Flags().Float64Var(&diffDirsThreshold, "threshold", 0, "exit with an error if any pair's score is at least this, 0 disables the check")
+	diffDirsCmd.Flags().IntVar(&diffDirsTop, "top", 10, "the number of best matching pairs to show")
+	diffDirsCmd.Flags().BoolVar(&diffDirsNoMatrix, "no-matrix", false, "don't print the similarity matrix")
+	diffDirsCmd.Flags().StringVarP(&diffDirsMatch, "match", "m", WILDCARD, "only compare items matching the given glob")
+}
+
+var diffDirsCmd = &cobra.Command{
+	Use:   "diff-dirs A B",
+	Short: "Compares two directories without a database",
+	Long: `Compares every file in directory A with every file in directory B.
+
+The files are indexed in memory with the default settings so no database is
+needed and nothing is written to disk. A file-by-file similarity matrix is
+printed followed by the best matching pairs.
+
+Fail a CI build if any pair is more than 80% similar:
+
+	paraphrase diff-dirs --threshold 0.8 --match "*.go" vendor/foo ./foo
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return errors.New("You must specify exactly two directories to compare")
+		}
+
+		memDb, err := paraphrase.NewMemoryDb(paraphrase.NewDefaultSettings())
+		if err != nil {
+			return err
+		}
+		defer memDb.Close()
+
+		var producer provider.DocumentProducer
+		for _, dir := range args {
+			tmp, err := newTreeProducer(dir, dir)
+			if err != nil {
+				return err
+			}
+
+			producer = provider.NewJoinerProducer(producer, tmp)
+		}
+
+		if diffDirsMatch != WILDCARD {
+			producer, err = provider.NewFilterWrapper(diffDirsMatch, producer)
+			if err != nil {
+				return err
+			}
+		}
+
+		if _, ok := memDb.AddDocuments(producer); !ok {
+			return errors.New("Some files could not be read")
+		}
+
+		matrix, err := memDb.CompareNamespaces(args[0], args[1])
+		if err != nil {
+			return err
+		}
+
+		if !diffDirsNoMatrix {
+			matrix.WriteMatrix(os.Stdout)
+			fmt.Println()
+		}
+
+		best := matrix.BestPairs()
+		if diffDirsTop >= 0 && len(best) > diffDirsTop {
+			best = best[:diffDirsTop]
+		}
+
+		fmt.Println("Best Matches:")
+		paraphrase.WritePairs(os.Stdout, best)
+
+		if diffDirsThreshold > 0 {
+			if above := matrix.PairsAbove(diffDirsThreshold); len(above) > 0 {
+				return fmt.Errorf("%d pairs have a score of at least %v", len(above), diffDirsThreshold)
+			}
+		}
+
+		return nil
+	},
+}
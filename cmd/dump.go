@@ -23,16 +23,19 @@ var dumpCmd = &cobra.Command{
 	Use:     "dump [criteria] directory",
 	Short:   "Writes the matching docs to a directory",
 	Long:    `Writes the matching documents to a directory.`,
-	PreRunE: openDb,
+	PreRunE: openDbReadOnly,
 	RunE: func(cmd *cobra.Command, args []string) error {
 
 		if len(args) != 1 {
 			return errors.New("You must specify one directory to write to")
 		}
 
-		doc := getQuery()
+		query, err := getQuery()
+		if err != nil {
+			return err
+		}
 
-		docs, err := db.FindDocumentsLike(doc)
+		docs, err := db.FindDocumentsMatching(query)
 
 		if err != nil {
 			return err
@@ -45,7 +48,7 @@ var dumpCmd = &cobra.Command{
 			filename := filepath.Base(outpath)
 			filedir := filepath.Dir(outpath)
 
-			log.Printf("Writing %s (%s) to %s\n", doc.Id, filename, filedir)
+			log.Printf("Writing %v (%s) to %s\n", doc.Id, filename, filedir)
 
 			if dumpDryRun {
 				continue
@@ -53,7 +56,7 @@ var dumpCmd = &cobra.Command{
 
 			body, err := db.FindDocumentDataById(doc.Id)
 			if err != nil {
-				log.Printf("Error getting %s: %s\n", doc.Id, err)
+				log.Printf("Error getting %v: %s\n", doc.Id, err)
 				continue
 			}
 
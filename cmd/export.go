@@ -28,8 +28,12 @@ Share documents in a namespace:
 Share documents matching a path:
 
 	paraphrase export -p "github.com/josephlewis42/*" myexport.ppdb
+
+Share Go files that aren't tests:
+
+	paraphrase export -q 'path:*.go not path:*_test.go' myexport.ppdb
 `,
-	PreRunE: openDb,
+	PreRunE: openDbReadOnly,
 	RunE: func(cmd *cobra.Command, args []string) error {
 
 		if len(args) != 1 {
@@ -38,6 +42,11 @@ Share documents matching a path:
 
 		dbPath := args[0]
 
+		query, err := getQuery()
+		if err != nil {
+			return err
+		}
+
 		settings := db.GetSettings()
 
 		exportDb, err := paraphrase.Create(dbPath, settings)
@@ -46,8 +55,6 @@ Share documents matching a path:
 			return err
 		}
 
-		query := getQuery()
-
 		return exportDb.ImportDocumentsMatching(db, query)
 	},
 }
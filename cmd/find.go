@@ -4,6 +4,7 @@
 package cmd
 
 import (
+	"io/ioutil"
 	"os"
 
 	"github.com/josephlewis42/paraphrase/paraphrase"
@@ -21,10 +22,22 @@ Variables:
 	{{id}} The id of the document
 	{{sha1}} SHA1 of the body
 	{{date}} The date and time the document was indexed
+	{{size}} The size of the body in bytes
+	{{meta "KEY"}} The metadata value for KEY
+	{{tags}} The document's tags
+	{{author}} The owner of the document
+	{{hashes}} The fingerprints of the document
 
 Search Only Variables:
 
 	{{similarity}} The similarity score between the document and the query
+	{{cursor}} Pass to --after to get the results after this one
+	{{snippet}} The parts of the body matching the query with context lines,
+	            marked up as chosen by --markup or {{snippet "html"}}
+	{{verdict}} The verdict on the result and the document searched for with
+	            -i, blank if the pair hasn't been reviewed
+	{{reviewer}} Who gave the verdict
+	{{notes}} The notes left with the verdict
 
 Formatting Functions:
 
@@ -34,12 +47,34 @@ Formatting Functions:
 	{{repeat 10 "="}} Prints the given x times e.g. "=========="
 	{{crlf}} Prints a carriage return line feed CRLF i.e. "\r\n"
 	{{tab}} Prints a tab character i.e. "\t"
+	{{tags | join ","}} Joins a list with the given separator
+	{{VARIABLE | tail 5}} Only allow the last five lines
+	{{VARIABLE | words 50}} Only allow the first 50 words
+	{{VARIABLE | truncate 80}} Cut to 80 characters ending with "…"
+	{{VARIABLE | trim}} Removes leading and trailing whitespace
+	{{VARIABLE | upper}} {{VARIABLE | lower}} Changes the case
+	{{VARIABLE | numberLines}} Prefixes each line with its line number
+	{{VARIABLE | highlight "TODO|FIXME" "**" "**"}} Surrounds regex matches
+	{{date | formatDate "2006-01-02"}} Formats a date using Go's layout
 
 Conversion Functions:
 
 	{{VARIABLE | html}} Escape HTML characters
 	{{VARIABLE | js}} Escape JavaScript characters
 	{{VARIABLE | urlquery}} Escape for embedding in URLs
+	{{VARIABLE | json}} Encode as JSON e.g. a quoted string
+
+Sections:
+
+	Templates may define "header", "item" and "footer" templates. The header
+	and footer are written once before and after the results with {{.Count}}
+	and {{.Generated}} available, the item is written for every result.
+
+	{{define "header"}}[{{end}}
+	{{define "item"}}{{json path}},{{end}}
+	{{define "footer"}}null]{{end}}
+
+	Long templates can be kept in a file and read with --fmt-file.
 
 FORMATTING EXAMPLES:
 
@@ -58,6 +93,7 @@ CLI Style:
 
 var (
 	findOutputFormat string
+	findFormatFile   string
 	findFullSha      bool
 	dumpDryRun       bool
 )
@@ -65,6 +101,7 @@ var (
 func init() {
 	findCmd.Flags().BoolVar(&findFullSha, "full-sha", false, "Show the full sha1 hash")
 	findCmd.Flags().StringVar(&findOutputFormat, "fmt", "", "Format the results of the find in a particular way")
+	findCmd.Flags().StringVar(&findFormatFile, "fmt-file", "", "Read the --fmt template from a file")
 
 	initQueryableCommand(findCmd)
 }
@@ -73,7 +110,7 @@ var findCmd = &cobra.Command{
 	Use:     "find [criteria]",
 	Short:   "Find documents based on properties",
 	Aliases: []string{"ls"},
-	Long: `Find documents based on namespace, path, ID and SHA1.
+	Long: `Find documents based on namespace, path, ID, SHA1, date and size.
 
 EXAMPLES:
 
@@ -82,15 +119,28 @@ Find a document with an id (or list of them through stdin):
 	paraphrase find -i b4e41da
 	cat ids.txt | paraphrase find -i
 
-Find a document with a path. * matches any character in a path including /:
+Find a document with a path. * matches any characters except /, ** also
+matches / so it spans directories and ? matches a single character. Globs
+without a / match the file name in any directory:
 
-	paraphrase find --path "*org/apache/commons/*.java
-	find -name *.java | sed -e 's/^../*/' | paraphrase find -p
+	paraphrase find --path "**/org/apache/commons/**/*.java"
+	paraphrase find --path "*.java"
+	find . -name "*.java" | sed -e 's|^\./|**/|' | paraphrase find -p
 
-Find a document with a namespace. * matches any character in a namespace including /:
+Find a document with a namespace. Namespaces are matched the same way but
+as a whole, so * doesn't match the / in "hw1/part2" and ** does:
 
 	paraphrase find --namespace assignment1
-	paraphrase find --namespace assignment1 --path student1*.java
+	paraphrase find --namespace "hw1/**" --path "student1*.java"
+
+Find all Java files from namespace hw3 indexed after the deadline, excluding
+tests:
+
+	paraphrase find -q 'ns:hw3 path:**/*.java date>2017-03-01T17:00 not path:*Test*.java'
+
+Find small or large documents:
+
+	paraphrase find -q 'size<100 or size>1m'
 
 Find a document that matches a SHA1, it's prefix or a list in stdin:
 
@@ -102,20 +152,40 @@ Change the format of the output.
 	cat myids.txt | paraphrase cat --fmt="
 		{{id}}\t{{path}}\n{{body | prefix "> "}}\r\n"
 
-` + FormattingOptions,
-	PreRunE: openDb,
+` + paraphrase.QuerySyntax + FormattingOptions,
+	PreRunE: openDbReadOnly,
 	RunE: func(cmd *cobra.Command, args []string) error {
 
-		doc := getQuery()
+		query, err := getQuery()
+		if err != nil {
+			return err
+		}
 
-		docs, err := db.FindDocumentsLike(doc)
+		docs, err := db.FindDocumentsMatching(query)
 
 		if err != nil {
 			return err
 		}
 
-		paraphrase.FormatDocuments(os.Stdout, docs, findOutputFormat, !findFullSha, db)
+		format, err := readFormat(findOutputFormat, findFormatFile)
+		if err != nil {
+			return err
+		}
 
-		return nil
+		return paraphrase.FormatDocuments(os.Stdout, docs, format, !findFullSha, db)
 	},
 }
+
+// readFormat gets the template in file if one was given, otherwise format.
+func readFormat(format, file string) (string, error) {
+	if file == "" {
+		return format, nil
+	}
+
+	text, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+
+	return string(text), nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/josephlewis42/paraphrase/paraphrase"
+	"github.com/spf13/cobra"
+)
+
+var (
+	fsckRepair bool
+)
+
+func init() {
+	fsckCmd.Flags().BoolVar(&fsckRepair, "repair", false, "fix problems by re-winnowing bodies and dropping orphaned records")
+}
+
+var fsckCmd = &cobra.Command{
+	Use:   "fsck",
+	Short: "Checks the database for inconsistencies",
+	Long: `Walks every bucket in the database and reports inconsistencies such as
+documents missing their bodies, bodies without documents, index entries
+pointing to deleted documents and documents whose SHA1 no longer matches
+their body.
+
+With --repair, documents are rebuilt by re-winnowing their bodies and
+orphaned records are dropped. Documents missing their bodies can't be
+recovered and are removed.
+
+Bodies that no longer match the SHA1 recorded in the changelog when their
+document was added aren't rebuilt, they were changed after the fact and
+"changelog verify" reports them. Any other change to a document's SHA1 is
+recorded in the changelog.`,
+	PreRunE: openDb,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		problems, err := db.Check(fsckRepair)
+		if err != nil {
+			return err
+		}
+
+		if len(problems) == 0 {
+			fmt.Println("No problems found")
+			return nil
+		}
+
+		paraphrase.WriteProblems(os.Stdout, problems)
+
+		if !fsckRepair {
+			return fmt.Errorf("Found %d problems, run with --repair to fix them", len(problems))
+		}
+
+		return nil
+	},
+}
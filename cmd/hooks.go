@@ -0,0 +1,150 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/josephlewis42/paraphrase/paraphrase"
+	"github.com/spf13/cobra"
+)
+
+var (
+	hooksCommand    string
+	hooksUrl        string
+	hooksJsonl      string
+	hooksMinScore   float64
+	hooksNamespaces []string
+)
+
+func init() {
+	hooksAddCmd.Flags().StringVar(&hooksCommand, "command", "", "run this shell command with the event on stdin")
+	hooksAddCmd.Flags().StringVar(&hooksUrl, "url", "", "POST the event as JSON to this URL")
+	hooksAddCmd.Flags().StringVar(&hooksJsonl, "jsonl", "", "append the event as a line to this file")
+	hooksAddCmd.Flags().Float64Var(&hooksMinScore, "min-score", 0.8, "the lowest similarity that runs the hook")
+	hooksAddCmd.Flags().StringSliceVarP(&hooksNamespaces, "namespace", "n", nil, "only run for new documents in namespaces matching these globs")
+
+	hooksCmd.AddCommand(hooksAddCmd)
+	hooksCmd.AddCommand(hooksRemoveCmd)
+}
+
+var hooksCmd = &cobra.Command{
+	Use:   "hooks",
+	Short: "(read only) Lists the hooks run when new documents match existing ones",
+	Long: `Lists the hooks run when a document is added that matches an existing one at
+or above the hook's minimum score. Hooks run for every way documents are
+added including add, git, sync, watch and import.
+
+Each hook gets one event per match as JSON:
+
+	{
+	  "event": "similar-document",
+	  "hook": 1,
+	  "date": "2017-10-19T14:03:11Z",
+	  "score": 0.91,
+	  "document": {"id": 42, "namespace": "hw3", "path": "/jdoe/Main.java", "sha1": "...", "size": 2048},
+	  "match": {"id": 17, "namespace": "hw3", "path": "/asmith/Main.java", "sha1": "...", "size": 2011}
+	}
+
+Commands are run with sh and get the event on stdin along with the
+PARAPHRASE_EVENT, PARAPHRASE_SCORE, PARAPHRASE_DOCUMENT and PARAPHRASE_MATCH
+environment variables. URLs are sent a POST and must reply with a 2xx status.
+Commands and requests taking over 10 seconds are given up on. A failing hook
+is logged but never stops documents being added.
+
+Hooks only run when the command adding documents is given --run-hooks, a
+database from someone else can't run commands, send requests or write files
+as you unless you ask it to.
+
+EXAMPLES:
+
+File a ticket for close matches in this term's submissions:
+
+	paraphrase hooks add --min-score 0.9 -n "hw*-2017" --url http://localhost:8080/tickets
+
+Keep a log of every match over 70%:
+
+	paraphrase hooks add --min-score 0.7 --jsonl matches.jsonl
+
+Mail the instructor:
+
+	paraphrase hooks add --command 'mail -s "Match $PARAPHRASE_SCORE" prof@example.edu'
+
+Run them for new submissions:
+
+	paraphrase add --run-hooks --namespace hw3-2017 submissions/
+`,
+	PreRunE: openDbReadOnly,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		hooks, err := db.Hooks()
+		if err != nil {
+			return err
+		}
+
+		paraphrase.WriteHooks(os.Stdout, hooks)
+		return nil
+	},
+}
+
+var hooksAddCmd = &cobra.Command{
+	Use:   "add (--command CMD|--url URL|--jsonl FILE)",
+	Short: "Adds a hook",
+	Long: `Adds a hook run when a new document matches an existing one at or above
+--min-score, see "paraphrase hooks" for the events hooks get.`,
+	PreRunE: openDb,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		hook := paraphrase.Hook{MinScore: hooksMinScore, Namespaces: hooksNamespaces}
+
+		targets := 0
+		for kind, target := range map[paraphrase.HookKind]string{
+			paraphrase.HookCommand: hooksCommand,
+			paraphrase.HookHttp:    hooksUrl,
+			paraphrase.HookJsonl:   hooksJsonl,
+		} {
+			if target != "" {
+				hook.Kind = kind
+				hook.Target = target
+				targets++
+			}
+		}
+
+		if targets != 1 {
+			return errors.New("You must specify exactly one of --command, --url or --jsonl")
+		}
+
+		err := db.AddHook(&hook)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Added hook %d\n", hook.Id)
+		return nil
+	},
+}
+
+var hooksRemoveCmd = &cobra.Command{
+	Use:     "remove ID",
+	Short:   "Removes a hook",
+	PreRunE: openDb,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("You must specify the id of the hook to remove")
+		}
+
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("Invalid hook id %q", args[0])
+		}
+
+		err = db.DeleteHook(id)
+		if err == paraphrase.NotFoundErr {
+			return fmt.Errorf("There's no hook %d", id)
+		}
+
+		return err
+	},
+}
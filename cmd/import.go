@@ -38,13 +38,17 @@ Share documents matching a path:
 
 		dbPath := args[0]
 
-		importDb, err := paraphrase.Open(dbPath)
+		importDb, err := paraphrase.OpenWithOptions(dbPath, paraphrase.OpenOptions{ReadOnly: true, Timeout: lockTimeout})
 
 		if err != nil {
 			return err
 		}
+		defer importDb.Close()
 
-		query := getQuery()
+		query, err := getQuery()
+		if err != nil {
+			return err
+		}
 
 		return db.ImportDocumentsMatching(importDb, query)
 	},
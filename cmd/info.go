@@ -13,7 +13,7 @@ var infoCmd = &cobra.Command{
 	Use:     "info",
 	Short:   "Writes general information about Paraphrase's settings and Database",
 	Long:    `Writes general information about Paraphrase's settings and Database`,
-	PreRunE: openDb,
+	PreRunE: openDbReadOnly,
 	Run: func(cmd *cobra.Command, args []string) {
 		db.WriteStats(os.Stdout)
 	},
@@ -0,0 +1,97 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+
+package cmd
+
+import (
+	"errors"
+	"os"
+
+	"github.com/josephlewis42/paraphrase/paraphrase"
+	"github.com/josephlewis42/paraphrase/paraphrase/provider"
+	"github.com/spf13/cobra"
+)
+
+var (
+	licensesNamespace string
+	licensesThreshold float64
+	licensesMatch     string
+)
+
+func init() {
+	licensesCmd.Flags().StringVarP(&licensesNamespace, "namespace", "n", "", "only report documents in namespaces matching the glob")
+	licensesCmd.Flags().Float64Var(&licensesThreshold, "threshold", 0.5, "report licenses with at least this fraction of their text in a document")
+
+	licensesLoadCmd.Flags().StringVarP(&licensesMatch, "match", "m", WILDCARD, "only load items matching the given glob")
+
+	licensesCmd.AddCommand(licensesLoadCmd)
+}
+
+var licensesCmd = &cobra.Command{
+	Use:   "licenses",
+	Short: "(read only) Reports which known licenses appear in documents",
+	Long: `Reports which known license texts appear in each indexed document.
+
+Licenses live in a reserved namespace and are loaded with "licenses load".
+Scores are the fraction of the license's fingerprints found in the document
+so a full license text copied into a file scores close to 1. Load license
+headers as their own files to find them too.
+
+EXAMPLES:
+
+Load the SPDX license texts:
+
+	git clone https://github.com/spdx/license-list-data
+	paraphrase licenses load -m "*.txt" license-list-data/text
+
+Find GPL code in the "proprietary" namespace:
+
+	paraphrase licenses -n proprietary | grep GPL
+`,
+	PreRunE: openDbReadOnly,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		matches, err := db.FindLicenses(licensesNamespace, licensesThreshold)
+		if err != nil {
+			return err
+		}
+
+		paraphrase.WriteLicenseMatches(os.Stdout, matches)
+		return nil
+	},
+}
+
+var licensesLoadCmd = &cobra.Command{
+	Use:   "load [PATH]...",
+	Short: "Loads license texts into the license namespace",
+	Long: `Loads license texts into the reserved license namespace. Each license is
+named after its file without the extension e.g. MIT.txt becomes MIT.`,
+	PreRunE: openDb,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		if len(args) == 0 {
+			return errors.New("You must specify at least one file/directory of licenses")
+		}
+
+		var producer provider.DocumentProducer
+		for _, path := range args {
+			tmp, err := newTreeProducer(path, paraphrase.LicenseNamespace)
+			if err != nil {
+				return err
+			}
+
+			producer = provider.NewJoinerProducer(producer, tmp)
+		}
+
+		if licensesMatch != WILDCARD {
+			producer, err = provider.NewFilterWrapper(licensesMatch, producer)
+			if err != nil {
+				return err
+			}
+		}
+
+		if _, ok := db.AddDocuments(producer); !ok {
+			return errors.New("Some licenses could not be loaded")
+		}
+
+		return nil
+	},
+}
@@ -0,0 +1,137 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/josephlewis42/paraphrase/paraphrase"
+	"github.com/spf13/cobra"
+)
+
+var (
+	reportGroupBy   string
+	reportFormat    string
+	reportTopFiles  int
+	reportMinShared int
+	reportHtmlDir   string
+	reportMinScore  float64
+	reportMaxPairs  int
+	reportHide      bool
+	reportMaxCommon float64
+)
+
+func init() {
+	reportCmd.Flags().StringVarP(&reportGroupBy, "group-by", "g", "author", "group documents by namespace, author, meta:KEY or path:REGEX")
+	reportCmd.Flags().StringVar(&reportFormat, "format", "text", "output format: text, csv or html")
+	reportCmd.Flags().IntVar(&reportTopFiles, "top-files", 3, "the number of contributing file pairs to show for each pair of groups")
+	reportCmd.Flags().IntVar(&reportMinShared, "min-shared", 1, "hide pairs of groups sharing fewer fingerprints")
+	reportCmd.Flags().StringVar(&reportHtmlDir, "html", "", "write a standalone HTML report of the most similar documents to this directory")
+	reportCmd.Flags().Float64Var(&reportMinScore, "min-score", 0.1, "the lowest similarity to include in the HTML report")
+	reportCmd.Flags().IntVar(&reportMaxPairs, "max-pairs", 100, "the most pairs of documents to include in the HTML report, -1 for all")
+	reportCmd.Flags().BoolVar(&reportHide, "hide-dismissed", false, "leave out pairs of documents with a dismissed verdict")
+	reportCmd.Flags().Float64Var(&reportMaxCommon, "max-common", paraphrase.DefaultMaxCommon, "skip fingerprints found in more than this fraction of documents, 1 keeps all of them")
+
+	initQueryableCommand(reportCmd)
+}
+
+var reportCmd = &cobra.Command{
+	Use:   "report [criteria]",
+	Short: "(read only) Reports similarity between authors or other groups",
+	Long: `Groups documents and reports the fingerprints shared between every pair of
+groups. Fingerprints are counted once per group so copying split across
+several files still adds up. The score is the fraction of the smaller group's
+fingerprints found in the other.
+
+Groups can be taken from the namespace, the author, any metadata key or the
+first capture group of a regular expression matched against the path.
+
+Fingerprints found in over half of the documents, like a shared template, are
+skipped. They say little about copying and comparing every pair of documents
+holding them would make large reports slow. Change the fraction with
+--max-common.
+
+EXAMPLES:
+
+Compare students in hw3 by the author from their manifest:
+
+	paraphrase report -q ns:hw3
+
+Compare the top level directories of each document's path:
+
+	paraphrase report --group-by 'path:^/?([^/]+)/'
+
+Write an HTML heatmap:
+
+	paraphrase report --group-by meta:student --format html > heatmap.html
+
+Leave out pairs of files that have been reviewed and dismissed, verdicts on
+the rest are shown next to them:
+
+	paraphrase report --hide-dismissed
+
+Write a website with the most similar pairs of documents, a page comparing
+each pair with the matches highlighted, a page per document and the group
+heatmap. Everything it needs is in the directory so it can be zipped and sent
+to someone without paraphrase:
+
+	paraphrase report -q ns:hw3 --html hw3-report
+	zip -r hw3-report.zip hw3-report
+`,
+	PreRunE: openDbReadOnly,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		grouper, err := paraphrase.ParseGrouper(reportGroupBy)
+		if err != nil {
+			return err
+		}
+
+		query, err := getQuery()
+		if err != nil {
+			return err
+		}
+
+		if reportHtmlDir != "" {
+			options := paraphrase.HtmlReportOptions{
+				MinScore:      reportMinScore,
+				MaxPairs:      reportMaxPairs,
+				Group:         grouper,
+				HideDismissed: reportHide,
+			}
+
+			err := db.WriteHtmlReport(reportHtmlDir, query, options)
+			if err != nil {
+				return err
+			}
+
+			log.Printf("Open %s to view the report\n", filepath.Join(reportHtmlDir, "index.html"))
+			return nil
+		}
+
+		matrix, err := db.CompareGroups(query, grouper, paraphrase.GroupOptions{
+			TopFiles:      reportTopFiles,
+			HideDismissed: reportHide,
+			MaxCommon:     reportMaxCommon,
+		})
+		if err != nil {
+			return err
+		}
+
+		pairs := matrix.SortedPairs(reportMinShared)
+
+		switch reportFormat {
+		case "text":
+			paraphrase.WriteGroupPairs(os.Stdout, pairs)
+			return nil
+		case "csv":
+			return paraphrase.WriteGroupPairsCsv(os.Stdout, pairs)
+		case "html":
+			return matrix.WriteHeatmap(os.Stdout)
+		default:
+			return fmt.Errorf("Unknown format %q, expected text, csv or html", reportFormat)
+		}
+	},
+}
@@ -0,0 +1,29 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+
+package cmd
+
+import (
+	"errors"
+
+	"github.com/josephlewis42/paraphrase/paraphrase"
+	"github.com/spf13/cobra"
+)
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore [src]",
+	Short: "Replaces the database with a backup",
+	Long: `Replaces the database with one made by "paraphrase backup".
+
+Compressed backups are detected automatically. If a checksum file
+(<src>.sha256) is next to the backup it is verified first. The backup's
+settings and schema version are validated before the database is replaced.
+The database being replaced is kept as <db>.pre-restore-<time>.bak.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("You must specify one backup to restore from")
+		}
+
+		return paraphrase.Restore(projectBase, args[0])
+	},
+}
@@ -7,6 +7,8 @@ import (
 	"log"
 	"os"
 	"runtime/pprof"
+	"strings"
+	"time"
 
 	"github.com/josephlewis42/paraphrase/paraphrase"
 	"github.com/spf13/cobra"
@@ -16,18 +18,30 @@ var (
 	projectBase string
 	db          *paraphrase.ParaphraseDb
 
-	addMatcher string
-	cpuprofile string
+	addMatcher  string
+	cpuprofile  string
+	lockTimeout time.Duration
+	noDaemon    bool
+	runHooks    bool
 )
 
 func init() {
 	RootCmd.AddCommand(addCmd)
 	RootCmd.AddCommand(cmdGit)
+	RootCmd.AddCommand(syncCmd)
+	RootCmd.AddCommand(watchCmd)
 
 	RootCmd.AddCommand(findCmd)
 	RootCmd.AddCommand(catCmd)
+	RootCmd.AddCommand(tagCmd)
 	RootCmd.AddCommand(dumpCmd)
 	RootCmd.AddCommand(searchCmd)
+	RootCmd.AddCommand(diffDirsCmd)
+	RootCmd.AddCommand(reportCmd)
+	RootCmd.AddCommand(triageCmd)
+	RootCmd.AddCommand(verdictsCmd)
+	RootCmd.AddCommand(hooksCmd)
+	RootCmd.AddCommand(checkCmd)
 
 	RootCmd.AddCommand(exportCmd)
 	RootCmd.AddCommand(importCmd)
@@ -36,8 +50,13 @@ func init() {
 	RootCmd.AddCommand(infoCmd)
 	RootCmd.AddCommand(changelogCmd)
 	RootCmd.AddCommand(licenseCmd)
+	RootCmd.AddCommand(licensesCmd)
 	RootCmd.AddCommand(GenCmd)
 	RootCmd.AddCommand(compactCmd)
+	RootCmd.AddCommand(backupCmd)
+	RootCmd.AddCommand(restoreCmd)
+	RootCmd.AddCommand(fsckCmd)
+	RootCmd.AddCommand(daemonCmd)
 
 	GenCmd.AddCommand(genmanCmd)
 	GenCmd.AddCommand(gendocCmd)
@@ -45,6 +64,9 @@ func init() {
 
 	RootCmd.PersistentFlags().StringVarP(&projectBase, "base", "b", ".", "base project directory")
 	RootCmd.PersistentFlags().StringVar(&cpuprofile, "cpuprofile", "", "write cpu profiling info to file")
+	RootCmd.PersistentFlags().DurationVar(&lockTimeout, "lock-timeout", paraphrase.DefaultLockTimeout, "how long to wait for other processes using the database")
+	RootCmd.PersistentFlags().BoolVar(&noDaemon, "no-daemon", false, "open the database directly even if a daemon is serving it")
+	RootCmd.PersistentFlags().BoolVar(&runHooks, "run-hooks", false, "run the hooks saved in the database for documents added")
 	RootCmd.PersistentFlags().SetAnnotation("base", cobra.BashCompSubdirsInDir, []string{})
 }
 
@@ -68,7 +90,8 @@ between documents`,
 	},
 
 	PersistentPostRun: func(cmd *cobra.Command, args []string) {
-		if db != nil {
+		// the daemon's database stays open between commands
+		if db != nil && db != daemonDb {
 			db.Close()
 		}
 
@@ -87,14 +110,52 @@ var GenCmd = &cobra.Command{
 	},
 }
 
+// openDb opens the database for writing, or sends the command to the
+// daemon serving it, see daemonCmd.
 func openDb(cmd *cobra.Command, args []string) error {
+	return openDbWith(cmd, false)
+}
+
+// openDbReadOnly opens the database for commands that don't change it so
+// they can run alongside each other.
+func openDbReadOnly(cmd *cobra.Command, args []string) error {
+	return openDbWith(cmd, true)
+}
+
+func openDbWith(cmd *cobra.Command, readOnly bool) error {
+	if daemonDb != nil {
+		db = daemonDb
+		db.SetRunHooks(runHooks)
+		return nil
+	}
+
+	if !noDaemon {
+		forwardToDaemon(cmd)
+	}
+
 	var err error
-	db, err = paraphrase.Open(projectBase)
+	db, err = openLocalDb(cmd, readOnly)
+	return err
+}
+
+// openLocalDb opens the database in this process using the global flags.
+func openLocalDb(cmd *cobra.Command, readOnly bool) (*paraphrase.ParaphraseDb, error) {
+	local, err := paraphrase.OpenWithOptions(projectBase, paraphrase.OpenOptions{
+		ReadOnly: readOnly,
+		Timeout:  lockTimeout,
+		Command:  commandName(cmd),
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	local.SetRunHooks(runHooks)
+	return local, nil
+}
+
+// commandName gets the command without the program name, e.g. "verdicts set".
+func commandName(cmd *cobra.Command) string {
+	return strings.TrimPrefix(cmd.CommandPath(), RootCmd.Name()+" ")
 }
 
 var (
@@ -102,6 +163,7 @@ var (
 	queryableIdParam        int64
 	queryablePathParam      string
 	queryableNamespaceParam string
+	queryableQueryParam     string
 )
 
 func initQueryableCommand(cmd *cobra.Command) {
@@ -109,15 +171,35 @@ func initQueryableCommand(cmd *cobra.Command) {
 	cmd.Flags().Int64VarP(&queryableIdParam, "id", "i", 0, "search by a document's id")
 	cmd.Flags().StringVarP(&queryablePathParam, "path", "p", "", "search by a document's path")
 	cmd.Flags().StringVarP(&queryableNamespaceParam, "namespace", "n", "", "search by a document's namespace")
+	cmd.Flags().StringVarP(&queryableQueryParam, "query", "q", "", "search with a query, see QUERY LANGUAGE in find --help")
 }
 
-func getQuery() paraphrase.Document {
-	var query paraphrase.Document
+// hasQuery checks if any of the queryable flags were given, without them
+// getQuery matches every document.
+func hasQuery() bool {
+	return queryableShaParam != "" || queryableIdParam != 0 || queryablePathParam != "" ||
+		queryableNamespaceParam != "" || queryableQueryParam != ""
+}
+
+// getQuery combines the queryable flags into a single query, all of them must
+// match.
+func getQuery() (paraphrase.Query, error) {
+	var doc paraphrase.Document
+
+	doc.Id = queryableIdParam
+	doc.Path = queryablePathParam
+	doc.Sha1 = queryableShaParam
+	doc.Namespace = queryableNamespaceParam
 
-	query.Id = queryableIdParam
-	query.Path = queryablePathParam
-	query.Sha1 = queryableShaParam
-	query.Namespace = queryableNamespaceParam
+	flags, err := paraphrase.QueryFromDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	expr, err := paraphrase.ParseQuery(queryableQueryParam)
+	if err != nil {
+		return nil, err
+	}
 
-	return query
+	return paraphrase.And(flags, expr), nil
 }
@@ -18,14 +18,21 @@ const ()
 var (
 	searchIdParam      int64
 	searchDocParam     string
-	searchLimit        int
+	searchOptions      paraphrase.SearchOptions
+	searchOthers       bool
+	searchFormatFile   string
+	searchMarkup       string
+	searchContext      int
+	searchJson         bool
 	searchResultFormat string = `
 ID:    {{id}}
 Path:  {{path}}
 SHA1:  {{sha1}}
 Score: {{similarity}}
+{{- with verdict}}
+Verdict: {{.}} by {{reviewer}}{{with notes}} ({{.}}){{end}}{{end}}
 
-{{body | head 5 | prefix "> "}}
+{{snippet | prefix "> "}}
 
 {{repeat 80 "-"}}
 `
@@ -34,8 +41,25 @@ Score: {{similarity}}
 func init() {
 	searchCmd.Flags().Int64VarP(&searchIdParam, "id", "i", 0, "search by a document's id")
 	searchCmd.Flags().StringVarP(&searchDocParam, "file", "f", "", "search by the text in a given file")
-	searchCmd.Flags().IntVar(&searchLimit, "limit", 20, "limit to the top n documents")
+	searchCmd.Flags().IntVar(&searchOptions.Limit, "limit", 20, "limit to the top n documents, 0 for all of them")
+	searchCmd.Flags().IntVar(&searchOptions.Offset, "offset", 0, "skip the top n documents")
+	searchCmd.Flags().StringVar(&searchOptions.After, "after", "", "start after the result with this cursor")
+	searchCmd.Flags().Float64Var(&searchOptions.MinScore, "min-score", 0, "only show documents with at least this similarity")
+	searchCmd.Flags().StringSliceVarP(&searchOptions.Namespaces, "namespace", "n", nil, "only show documents in namespaces matching these globs")
+	searchCmd.Flags().StringSliceVar(&searchOptions.ExcludeNamespaces, "exclude-namespace", nil, "hide documents in namespaces matching these globs")
+	searchCmd.Flags().StringSliceVarP(&searchOptions.Paths, "path", "p", nil, "only show documents with paths matching these globs")
+	searchCmd.Flags().StringSliceVar(&searchOptions.ExcludePaths, "exclude-path", nil, "hide documents with paths matching these globs")
+	searchCmd.Flags().BoolVar(&searchOptions.ExcludeSelf, "exclude-self", false, "hide the document searched for with -i")
+	searchCmd.Flags().BoolVar(&searchOptions.ExcludeCopies, "exclude-copies", false, "hide documents identical to the one searched for")
+	searchCmd.Flags().BoolVar(&searchOptions.ExcludeSameNamespace, "exclude-same-namespace", false, "hide documents in the namespace of the one searched for with -i")
+	searchCmd.Flags().BoolVar(&searchOptions.ExcludeSameAuthor, "exclude-same-author", false, "hide documents by the author of the one searched for with -i")
+	searchCmd.Flags().BoolVar(&searchOptions.IncludeRemoved, "include-removed", false, "show documents whose files sync found gone")
+	searchCmd.Flags().BoolVar(&searchOthers, "others", false, "hide the document searched for, its copies and everything in its namespace or by its author")
 	searchCmd.Flags().StringVar(&searchResultFormat, "fmt", searchResultFormat, "The format for searching")
+	searchCmd.Flags().StringVar(&searchFormatFile, "fmt-file", "", "Read the --fmt template from a file")
+	searchCmd.Flags().StringVar(&searchMarkup, "markup", paraphrase.MarkupPlain, "how {{snippet}} marks matching text: plain, ansi or html")
+	searchCmd.Flags().IntVarP(&searchContext, "context", "C", paraphrase.DefaultSnippetContext, "lines of context around each snippet")
+	searchCmd.Flags().BoolVar(&searchJson, "json", false, "write the results and their snippets as JSON")
 
 }
 
@@ -58,14 +82,58 @@ Search for documents similar to the given file:
 
 	paraphrase search -f MyApplication.java
 
+Search for documents in a namespace, ignoring tests. Globs match like they
+do for find, see "paraphrase find --help":
+
+	paraphrase search -f MyApplication.java -n "hw3*" --exclude-path "*Test.java"
+
+Find who else has code like a document, leaving out the document, its copies
+and the rest of its author's work:
+
+	paraphrase search -i b4e41da --others
+
+Include documents whose files were gone the last time they were synced, they
+are left out otherwise:
+
+	paraphrase search -f MyApplication.java --include-removed
+
+Get the next page of results using the cursor of the last one:
+
+	paraphrase search -f MyApplication.java --limit 10 --fmt '{{cursor}}{{crlf}}'
+	paraphrase search -f MyApplication.java --limit 10 --after 3fe6b1f5a2a4c1b0:4711
+
+Show matching text in color:
+
+	paraphrase search -f MyApplication.java --markup ansi
+
+Write results with the line numbers and offsets of matching text as JSON:
+
+	paraphrase search -f MyApplication.java --json
+
 Formatting the search output:
 
 	paraphrase search --fmt="{{id}}\t{{path}}\n{{body | prefix "> "}}\r\n"
 
 ` + FormattingOptions,
 	Aliases: []string{"q"},
-	PreRunE: openDb,
+	PreRunE: openDbReadOnly,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		switch searchMarkup {
+		case paraphrase.MarkupPlain, paraphrase.MarkupAnsi, paraphrase.MarkupHtml:
+		default:
+			return fmt.Errorf("Unknown markup %q, expected plain, ansi or html", searchMarkup)
+		}
+
+		if searchOthers {
+			searchOptions.ExcludeSelf = true
+			searchOptions.ExcludeCopies = true
+			searchOptions.ExcludeSameNamespace = true
+			searchOptions.ExcludeSameAuthor = true
+		}
+
+		// the daemon runs searches one after another in the same process
+		searchOptions.Related = nil
+
 		var results []paraphrase.SearchResult
 		var err error
 
@@ -74,32 +142,53 @@ Formatting the search output:
 			return errors.New("You must specify exactly one query, document path or id")
 
 		case len(args) == 1:
-			results, err = db.QueryByString(args[0])
+			searchOptions.Related = queryDocument("", []byte(args[0]))
+			results, err = db.QueryByString(args[0], searchOptions)
 
 		case searchIdParam != 0:
-			results, err = db.QueryById(searchIdParam)
-			if err != nil {
-				return err
-			}
+			results, err = db.QueryById(searchIdParam, searchOptions)
 
 		case searchDocParam != "":
-			bytes, err := ioutil.ReadFile(searchDocParam)
+			var body []byte
+			body, err = ioutil.ReadFile(searchDocParam)
 			if err != nil {
 				return err
 			}
-			results, err = db.QueryByString(string(bytes))
+			searchOptions.Related = queryDocument(searchDocParam, body)
+			results, err = db.QueryByString(string(body), searchOptions)
 
 		default:
-			fmt.Println(args[0])
-			results, err = db.QueryByString(args[0])
+			return errors.New("You must specify a query, document path or id")
+		}
+
+		if err != nil {
+			return err
 		}
 
+		if searchJson {
+			return db.WriteSearchResultsJson(os.Stdout, results, searchContext, paraphrase.DefaultSnippetLimit)
+		}
+
+		format, err := readFormat(searchResultFormat, searchFormatFile)
+		if err != nil {
+			return err
+		}
+
+		renderer, err := paraphrase.NewRenderer(format, db)
 		if err != nil {
 			return err
 		}
 
-		paraphrase.FormatSearchResults(os.Stdout, results, searchResultFormat, db)
+		renderer.Markup = searchMarkup
+		renderer.SnippetContext = searchContext
 
-		return err
+		return renderer.RenderSearchResults(os.Stdout, results)
 	},
 }
+
+// queryDocument describes text being searched for that isn't in the database
+// so copies of it can be left out, it has no namespace or author.
+func queryDocument(path string, body []byte) *paraphrase.Document {
+	doc, _ := paraphrase.NewDocument(path, "", body)
+	return doc
+}
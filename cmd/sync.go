@@ -0,0 +1,133 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/josephlewis42/paraphrase/paraphrase"
+	"github.com/josephlewis42/paraphrase/paraphrase/provider"
+	"github.com/spf13/cobra"
+)
+
+var (
+	syncNamespace string
+	syncGit       bool
+	syncOptions   paraphrase.SyncOptions
+)
+
+func init() {
+	syncCmd.Flags().StringVar(&syncNamespace, "namespace", "", "the namespace to sync, required for directories, defaults to the URL for git")
+	syncCmd.Flags().BoolVar(&syncGit, "git", false, "sync from a git URL rather than a directory")
+	syncCmd.Flags().StringVarP(&syncOptions.Match, "match", "m", "", "only sync paths matching the given glob, others are left alone")
+	syncCmd.Flags().BoolVar(&syncOptions.Delete, "delete", false, "delete documents for removed and changed files instead of tagging them removed")
+	syncCmd.Flags().BoolVar(&syncOptions.DryRun, "dry", false, "list what would change without changing anything")
+}
+
+var syncCmd = &cobra.Command{
+	Use:   "sync (PATH|--git URL)",
+	Short: "Updates a namespace to match a directory or git repository",
+	Long: `Compares a directory or git repository with the documents in a namespace
+by path and SHA1. New files are added, changed files are indexed again and
+documents whose files are gone are tagged "removed" along with a removed_at
+date, or deleted with --delete. The old version of a changed file is treated
+the same way. Removed documents whose files come back unchanged lose the tag.
+
+Unchanged files are only read to check their SHA1 so keeping a mirror of a
+large tree up to date takes a fraction of the time adding it did.
+
+Each document added is recorded in the changelog as it's added, these
+entries are part of the sync. The sync's own entry follows them and lists
+every document it touched. A sync that stops part way leaves the documents
+it already changed in place and its entry is marked as failed, running it
+again picks up where it left off.
+
+Searches leave removed documents out unless given --include-removed, use
+"not tag:removed" in queries to leave them out elsewhere.
+
+EXAMPLES:
+
+Mirror a checkout nightly:
+
+	paraphrase sync --namespace monorepo /src/monorepo
+
+See what would change first:
+
+	paraphrase sync --namespace monorepo --dry /src/monorepo
+
+Mirror the Java files of a remote repository:
+
+	paraphrase sync --git -m "*.java" https://github.com/josephlewis42/paraphrase
+`,
+	PreRunE: openDb,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("You must specify one directory or git URL")
+		}
+
+		var producer provider.DocumentProducer
+		var err error
+
+		if syncGit {
+			if syncNamespace == "" {
+				// leave out the revision so every sync uses the same namespace
+				parsed, err := url.Parse(args[0])
+				if err != nil {
+					return err
+				}
+				syncNamespace = parsed.Host + parsed.Path
+			}
+
+			producer, err = provider.NewGitProvider(args[0], syncNamespace)
+			if err != nil {
+				return err
+			}
+		} else {
+			if syncNamespace == "" {
+				return errors.New("You must specify the --namespace to sync")
+			}
+
+			// a missing directory would look like every file was removed
+			if _, err := os.Stat(args[0]); err != nil {
+				return err
+			}
+
+			producer, err = newTreeProducer(args[0], syncNamespace)
+			if err != nil {
+				return err
+			}
+		}
+
+		result, err := db.Sync(syncNamespace, producer, syncOptions)
+		if err != nil {
+			return err
+		}
+
+		if syncOptions.DryRun {
+			printSyncPaths("add", result.Added)
+			printSyncPaths("update", result.Updated)
+			printSyncPaths("restore", result.Restored)
+			printSyncPaths("remove", result.Removed)
+		}
+
+		fmt.Printf("%s: %d added, %d updated, %d restored, %d removed, %d unchanged\n", syncNamespace,
+			len(result.Added), len(result.Updated), len(result.Restored), len(result.Removed), result.Unchanged)
+
+		if len(result.Failed) > 0 {
+			printSyncPaths("failed", result.Failed)
+			return fmt.Errorf("%d files could not be synced", len(result.Failed))
+		}
+
+		return nil
+	},
+}
+
+func printSyncPaths(action string, paths []string) {
+	for _, path := range paths {
+		fmt.Printf("%s\t%s\n", action, path)
+	}
+}
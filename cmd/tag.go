@@ -0,0 +1,122 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+
+package cmd
+
+import (
+	"errors"
+	"log"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	tagAdd    []string
+	tagRemove []string
+	tagSet    []string
+	tagUnset  []string
+	tagAll    bool
+)
+
+func init() {
+	tagCmd.Flags().StringArrayVar(&tagAdd, "add", nil, "add a tag, may be repeated")
+	tagCmd.Flags().StringArrayVar(&tagRemove, "remove", nil, "remove a tag, may be repeated")
+	tagCmd.Flags().StringArrayVar(&tagSet, "set", nil, "set KEY=VALUE metadata, may be repeated")
+	tagCmd.Flags().StringArrayVar(&tagUnset, "unset", nil, "remove the metadata KEY, may be repeated")
+	tagCmd.Flags().BoolVar(&tagAll, "all", false, "change every document, required when no criteria are given")
+
+	initQueryableCommand(tagCmd)
+}
+
+var tagCmd = &cobra.Command{
+	Use:   "tag [criteria]",
+	Short: "Changes the tags and metadata of documents",
+	Long: `Changes the tags and metadata of the documents matching the criteria. To
+change every document use --all rather than leaving out the criteria.
+
+EXAMPLES:
+
+Tag late submissions:
+
+	paraphrase tag -n hw3 -q 'date>2017-03-01T17:00' --add late
+
+Record who wrote a document:
+
+	paraphrase tag -i 6570056963208625016 --set author=jdoe@example.com
+
+Mark everything loaded so far as last year's:
+
+	paraphrase tag --all --set term=2016
+`,
+	PreRunE: openDb,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(tagAdd)+len(tagRemove)+len(tagSet)+len(tagUnset) == 0 {
+			return errors.New("Nothing to change, use --add, --remove, --set or --unset")
+		}
+
+		if !hasQuery() && !tagAll {
+			return errors.New("You must specify which documents to change with -i, -s, -n, -p or -q, or use --all")
+		}
+
+		set, err := parseMetadata(tagSet)
+		if err != nil {
+			return err
+		}
+
+		query, err := getQuery()
+		if err != nil {
+			return err
+		}
+
+		docs, err := db.FindDocumentsMatching(query)
+		if err != nil {
+			return err
+		}
+
+		for _, doc := range docs {
+			metadata := make(map[string]string)
+			for k, v := range doc.Metadata {
+				metadata[k] = v
+			}
+			for k, v := range set {
+				metadata[k] = v
+			}
+			for _, k := range tagUnset {
+				delete(metadata, k)
+			}
+
+			var tags []string
+			for _, tag := range doc.Tags {
+				if !contains(tagRemove, tag) {
+					tags = append(tags, tag)
+				}
+			}
+			for _, tag := range tagAdd {
+				if !contains(tags, tag) {
+					tags = append(tags, tag)
+				}
+			}
+
+			doc.Metadata = metadata
+			doc.Tags = tags
+
+			err := db.UpdateMetadata(&doc)
+			if err != nil {
+				return err
+			}
+		}
+
+		log.Printf("Updated %d documents\n", len(docs))
+		return nil
+	},
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,329 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/josephlewis42/paraphrase/paraphrase"
+	"github.com/spf13/cobra"
+	"gopkg.in/AlecAivazis/survey.v1"
+	"gopkg.in/AlecAivazis/survey.v1/terminal"
+)
+
+const (
+	triageQuit = "Quit"
+	triageHelp = "j/k scroll  space/b page  n/p next/previous match  c confirm  d dismiss  r needs review  q back"
+
+	// rows of the screen that aren't the documents
+	triageChromeRows = 4
+	// rows shown above a match after jumping to it
+	triageMatchContext = 2
+)
+
+var (
+	triageMinScore   float64
+	triageMaxPairs   int
+	triageUnreviewed bool
+)
+
+func init() {
+	triageCmd.Flags().Float64Var(&triageMinScore, "min-score", 0.1, "the lowest similarity to list")
+	triageCmd.Flags().IntVar(&triageMaxPairs, "max-pairs", 100, "the most pairs to list, -1 for all")
+	triageCmd.Flags().BoolVar(&triageUnreviewed, "unreviewed", false, "only list pairs without a verdict")
+
+	initQueryableCommand(triageCmd)
+}
+
+var triageCmd = &cobra.Command{
+	Use:   "triage [criteria]",
+	Short: "Review similar pairs of documents and record verdicts",
+	Long: `Lists the most similar pairs of documents matching the criteria. Picking a
+pair opens the two documents side by side with the text they share
+highlighted, where the pair can be marked as confirmed, dismissed or needing
+review. Verdicts are saved in the database along with who made them.
+
+Each side scrolls on its own, n and p line up the next or previous shared
+region of both documents.
+
+The database is opened read-only while pairs are being compared so other
+commands can keep using it. It's only opened for writing, waiting up to
+--lock-timeout, while a verdict is saved. If it stays locked the verdict
+isn't saved and can be picked again.
+
+EXAMPLES:
+
+Review pairs from hw3 that haven't been looked at yet:
+
+	paraphrase triage -q ns:hw3 --unreviewed
+`,
+	Annotations: map[string]string{runLocallyAnnotation: "it needs a terminal"},
+	PreRunE:     openDbReadOnly,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		query, err := getQuery()
+		if err != nil {
+			return err
+		}
+
+		pairs, err := db.SimilarPairs(query, triageMinScore, triageMaxPairs)
+		if err != nil {
+			return err
+		}
+
+		verdicts, err := db.Verdicts()
+		if err != nil {
+			return err
+		}
+
+		if triageUnreviewed {
+			var unreviewed []paraphrase.Pair
+			for _, pair := range pairs {
+				if _, ok := verdicts[paraphrase.PairKey(pair.A.Id, pair.B.Id)]; !ok {
+					unreviewed = append(unreviewed, pair)
+				}
+			}
+			pairs = unreviewed
+		}
+
+		if len(pairs) == 0 {
+			fmt.Println("No pairs to review")
+			return nil
+		}
+
+		selected := 0
+		for {
+			var options []string
+			for i, pair := range pairs {
+				options = append(options, triageLabel(i, pair, verdicts))
+			}
+			options = append(options, triageQuit)
+
+			var choice string
+			err := survey.AskOne(&survey.Select{
+				Message:  fmt.Sprintf("%d pairs, pick one to compare:", len(pairs)),
+				Options:  options,
+				Default:  options[selected],
+				PageSize: 15,
+			}, &choice, nil)
+
+			if err == terminal.InterruptErr || choice == triageQuit {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			for i, option := range options {
+				if option == choice {
+					selected = i
+				}
+			}
+
+			pair := pairs[selected]
+			verdict, err := triagePair(pair, verdicts[paraphrase.PairKey(pair.A.Id, pair.B.Id)])
+			if err != nil {
+				return err
+			}
+
+			if verdict == "" {
+				continue
+			}
+
+			record, saveErr, err := saveVerdict(cmd, pair, verdict)
+			if err != nil {
+				return err
+			}
+			if saveErr != nil {
+				fmt.Fprintf(os.Stderr, "Couldn't save the verdict: %v\n", saveErr)
+				continue
+			}
+			verdicts[record.Id] = record
+
+			// move on to the next pair
+			if selected+1 < len(pairs) {
+				selected++
+			}
+		}
+	},
+}
+
+// saveVerdict records a verdict with a short writable open then goes back to
+// the read-only database. saveErr is why the verdict couldn't be saved, err is
+// set if the database couldn't be reopened.
+func saveVerdict(cmd *cobra.Command, pair paraphrase.Pair, verdict paraphrase.Verdict) (record *paraphrase.PairVerdict, saveErr, err error) {
+	// both opens can't hold the lock in one process
+	db.Close()
+
+	writable, saveErr := openLocalDb(cmd, false)
+	if saveErr == nil {
+		record, saveErr = writable.SetVerdict(pair.A.Id, pair.B.Id, verdict, "")
+		writable.Close()
+	}
+
+	db, err = openLocalDb(cmd, true)
+	return record, saveErr, err
+}
+
+func triageLabel(i int, pair paraphrase.Pair, verdicts map[string]*paraphrase.PairVerdict) string {
+	status := ""
+	if verdict, ok := verdicts[paraphrase.PairKey(pair.A.Id, pair.B.Id)]; ok {
+		status = "[" + string(verdict.Verdict) + "]"
+	}
+
+	return fmt.Sprintf("%3d. %5.1f%%  %-14s %s:%s ~ %s:%s", i+1, pair.Similarity*100, status,
+		pair.A.Namespace, pair.A.Path, pair.B.Namespace, pair.B.Path)
+}
+
+// triagePair shows the pair side by side until the user picks a verdict or
+// goes back, in which case the verdict is blank.
+func triagePair(pair paraphrase.Pair, current *paraphrase.PairVerdict) (paraphrase.Verdict, error) {
+	stdio := terminal.Stdio{In: os.Stdin, Out: os.Stdout, Err: os.Stderr}
+	reader := terminal.NewRuneReader(stdio)
+
+	err := reader.SetTermMode()
+	if err != nil {
+		return "", err
+	}
+	defer reader.RestoreTermMode()
+
+	cursor := terminal.Cursor{In: os.Stdin, Out: os.Stdout}
+	size, err := cursor.Size(new(bytes.Buffer))
+	if err != nil {
+		return "", err
+	}
+
+	width, height := int(size.X), int(size.Y)
+	columnWidth := (width - 3) / 2
+	bodyRows := height - triageChromeRows
+
+	if columnWidth < 20 || bodyRows < 1 {
+		return "", errors.New("The terminal is too small to compare documents")
+	}
+
+	view, err := db.NewPairView(pair, columnWidth)
+	if err != nil {
+		return "", err
+	}
+
+	status := "no verdict"
+	if current != nil {
+		status = current.String()
+		if current.Notes != "" {
+			status += ": " + current.Notes
+		}
+	}
+
+	leftTop, rightTop := 0, 0
+	match := -1
+
+	cursor.Hide()
+	defer cursor.Show()
+
+	for {
+		var screen bytes.Buffer
+
+		// clear the screen and start at the top
+		screen.WriteString("\x1b[H\x1b[2J")
+
+		fmt.Fprintf(&screen, "%s | %s\r\n",
+			fitColumn(fmt.Sprintf("A: %s:%s (%d)", pair.A.Namespace, pair.A.Path, pair.A.Id), columnWidth),
+			fitColumn(fmt.Sprintf("B: %s:%s (%d)", pair.B.Namespace, pair.B.Path, pair.B.Id), columnWidth))
+		fmt.Fprintf(&screen, "%s\r\n", fitColumn(fmt.Sprintf("%.1f%% similar, %d shared fingerprints, %s", pair.Similarity*100, view.Shared, status), width))
+		fmt.Fprintf(&screen, "%s\r\n", strings.Repeat("-", width))
+
+		for row := 0; row < bodyRows; row++ {
+			fmt.Fprintf(&screen, "%s | %s\r\n", columnRow(view.Left, leftTop+row, columnWidth), columnRow(view.Right, rightTop+row, columnWidth))
+		}
+
+		screen.WriteString(fitColumn(triageHelp, width))
+		os.Stdout.Write(screen.Bytes())
+
+		key, _, err := reader.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch key {
+		case 'j', terminal.KeyArrowDown, terminal.KeyEnter:
+			leftTop, rightTop = leftTop+1, rightTop+1
+		case 'k', terminal.KeyArrowUp:
+			leftTop, rightTop = leftTop-1, rightTop-1
+		case ' ', 'f':
+			leftTop, rightTop = leftTop+bodyRows, rightTop+bodyRows
+		case 'b':
+			leftTop, rightTop = leftTop-bodyRows, rightTop-bodyRows
+		case 'g':
+			leftTop, rightTop = 0, 0
+		case 'n', 'p':
+			if key == 'n' && match+1 < len(view.Matches) {
+				match++
+			}
+			if key == 'p' && match > 0 {
+				match--
+			}
+
+			if match >= 0 {
+				leftTop = matchRow(view.Matches[match][0], leftTop)
+				rightTop = matchRow(view.Matches[match][1], rightTop)
+			}
+		case 'c':
+			return paraphrase.Confirmed, nil
+		case 'd':
+			return paraphrase.Dismissed, nil
+		case 'r':
+			return paraphrase.NeedsReview, nil
+		case 'q', terminal.KeyEscape, terminal.KeyInterrupt, terminal.KeyEndTransmission:
+			os.Stdout.WriteString("\x1b[H\x1b[2J")
+			return "", nil
+		}
+
+		leftTop = clampTop(leftTop, len(view.Left), bodyRows)
+		rightTop = clampTop(rightTop, len(view.Right), bodyRows)
+	}
+}
+
+// matchRow gets the top row showing a match, sides without the match stay
+// where they are.
+func matchRow(row, top int) int {
+	if row < 0 {
+		return top
+	}
+
+	return row - triageMatchContext
+}
+
+func clampTop(top, rows, visible int) int {
+	if top > rows-visible {
+		top = rows - visible
+	}
+
+	if top < 0 {
+		top = 0
+	}
+
+	return top
+}
+
+func columnRow(rows []string, i, width int) string {
+	if i < len(rows) {
+		return rows[i]
+	}
+
+	return strings.Repeat(" ", width)
+}
+
+// fitColumn cuts or pads text to exactly width characters.
+func fitColumn(text string, width int) string {
+	runes := []rune(text)
+
+	if len(runes) > width {
+		return string(runes[:width])
+	}
+
+	return text + strings.Repeat(" ", width-len(runes))
+}
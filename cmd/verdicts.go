@@ -0,0 +1,144 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/josephlewis42/paraphrase/paraphrase"
+	"github.com/spf13/cobra"
+)
+
+var (
+	verdictsFormat string
+	verdictsNotes  string
+)
+
+func init() {
+	verdictsCmd.Flags().StringVar(&verdictsFormat, "format", "text", "output format: text, csv or json")
+	verdictsAuditCmd.Flags().StringVar(&verdictsFormat, "format", "text", "output format: text, csv or json")
+	verdictsSetCmd.Flags().StringVarP(&verdictsNotes, "notes", "m", "", "notes about the case, the earlier notes are kept if blank")
+
+	verdictsCmd.AddCommand(verdictsSetCmd)
+	verdictsCmd.AddCommand(verdictsAuditCmd)
+}
+
+var verdictsCmd = &cobra.Command{
+	Use:   "verdicts",
+	Short: "(read only) Lists the verdicts on reviewed pairs of documents",
+	Long: `Lists the verdict in effect on each reviewed pair of documents along with
+who made it, when and their notes. Verdicts are set with "verdicts set" or
+the triage command.
+
+EXAMPLES:
+
+Mark a pair as copied with a note:
+
+	paraphrase verdicts set 1234 5678 confirmed -m "Admitted in meeting 2017-06-01"
+
+Export every verdict ever made, including the ones replaced since, for the
+integrity office:
+
+	paraphrase verdicts audit --format csv > audit.csv
+`,
+	PreRunE: openDbReadOnly,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		entries, err := db.AuditTrail()
+		if err != nil {
+			return err
+		}
+
+		var current []paraphrase.AuditEntry
+		for _, entry := range entries {
+			if entry.Current {
+				current = append(current, entry)
+			}
+		}
+
+		return writeAuditTrail(current)
+	},
+}
+
+var verdictsSetCmd = &cobra.Command{
+	Use:   "set ID ID VERDICT",
+	Short: "Records the verdict on a pair of documents",
+	Long: `Records the verdict on a pair of documents as the current user. VERDICT is
+one of confirmed, dismissed or needs-review. Any earlier verdict on the pair
+is kept in its history for the audit trail.`,
+	PreRunE: openDb,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 3 {
+			return errors.New("You must specify two document ids and a verdict")
+		}
+
+		var ids [2]int64
+		for i, arg := range args[:2] {
+			id, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil {
+				return fmt.Errorf("Invalid document id %q", arg)
+			}
+
+			_, err = db.FindDocumentById(id)
+			if err != nil {
+				return fmt.Errorf("Could not find document %d: %s", id, err)
+			}
+
+			ids[i] = id
+		}
+
+		if ids[0] == ids[1] {
+			return errors.New("A document can't be paired with itself")
+		}
+
+		verdict, err := paraphrase.ParseVerdict(args[2])
+		if err != nil {
+			return err
+		}
+
+		record, err := db.SetVerdict(ids[0], ids[1], verdict, verdictsNotes)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Marked %d and %d as %s\n", record.A, record.B, record.Verdict)
+		return nil
+	},
+}
+
+var verdictsAuditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "(read only) Exports every verdict ever made, oldest first",
+	Long: `Exports every verdict ever made including the ones replaced since, oldest
+first. The current column marks the verdicts still in effect.
+
+Every verdict is also recorded in the changelog with its reviewer and notes,
+use "changelog verify" to check they haven't been changed since and
+"changelog --operation verdict" to list them.`,
+	PreRunE: openDbReadOnly,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		entries, err := db.AuditTrail()
+		if err != nil {
+			return err
+		}
+
+		return writeAuditTrail(entries)
+	},
+}
+
+func writeAuditTrail(entries []paraphrase.AuditEntry) error {
+	switch verdictsFormat {
+	case "text":
+		paraphrase.WriteAuditTrail(os.Stdout, entries)
+		return nil
+	case "csv":
+		return paraphrase.WriteAuditTrailCsv(os.Stdout, entries)
+	case "json":
+		return paraphrase.WriteAuditTrailJson(os.Stdout, entries)
+	default:
+		return fmt.Errorf("Unknown format %q, expected text, csv or json", verdictsFormat)
+	}
+}
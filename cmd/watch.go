@@ -0,0 +1,143 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/josephlewis42/paraphrase/paraphrase"
+	"github.com/josephlewis42/paraphrase/paraphrase/provider"
+	"github.com/spf13/cobra"
+)
+
+var (
+	watchNamespace string
+	watchMatch     string
+	watchPoll      time.Duration
+	watchForcePoll bool
+	watchAlertLog  string
+	watchOptions   paraphrase.WatchOptions
+)
+
+func init() {
+	watchCmd.Flags().StringVar(&watchNamespace, "namespace", "", "the namespace to add files to")
+	watchCmd.Flags().StringVarP(&watchMatch, "match", "m", WILDCARD, "only add files matching the given glob")
+	watchCmd.Flags().DurationVar(&watchPoll, "interval", 2*time.Second, "how often to scan the directory when polling")
+	watchCmd.Flags().BoolVar(&watchForcePoll, "poll", false, "scan the directory rather than using inotify, for network filesystems")
+	watchCmd.Flags().DurationVar(&watchOptions.Batch, "batch", paraphrase.DefaultWatchBatch, "how long to wait for more files before adding them")
+	watchCmd.Flags().BoolVar(&watchOptions.Check, "check", false, "search for documents like each new one and alert on matches")
+	watchCmd.Flags().Float64Var(&watchOptions.Search.MinScore, "threshold", 0.5, "the lowest similarity alerted on")
+	watchCmd.Flags().IntVar(&watchOptions.Search.Limit, "limit", 5, "the most matches listed in each alert, 0 for all of them")
+	watchCmd.Flags().StringSliceVarP(&watchOptions.Search.Namespaces, "check-namespace", "n", nil, "only check against namespaces matching these globs")
+	watchCmd.Flags().StringSliceVar(&watchOptions.Search.ExcludeNamespaces, "exclude-namespace", nil, "don't check against namespaces matching these globs")
+	watchCmd.Flags().BoolVar(&watchOptions.Search.ExcludeSameAuthor, "exclude-same-author", false, "don't alert on documents by the same author")
+	watchCmd.Flags().StringVar(&watchAlertLog, "alert-log", "", "append alerts to this file as well as printing them")
+}
+
+var watchCmd = &cobra.Command{
+	Use:   "watch DIRECTORY",
+	Short: "Adds files to the database as they land in a directory",
+	Long: `Watches a directory and adds files to a namespace as they're written or
+moved into it until interrupted. Files already there are added when watch
+starts. Files already in the namespace with the same path and SHA1 are
+skipped so watch can be restarted safely, changed files are added again as
+new documents.
+
+Files are picked up through inotify once their writer closes them. Where
+inotify isn't available, or with --poll, the directory is scanned every
+--interval instead and files are added once they stop changing between
+scans. Hidden files and directories are ignored.
+
+The database is only opened while a batch of files is added so other
+commands can use it in between. A batch finding the database locked is kept
+and tried again with the next one.
+
+With --check every new document is searched against the database and an
+alert is printed for matches at or above --threshold, earlier versions of
+the same file aren't reported. Alerts are printed to stdout and appended to
+--alert-log if given:
+
+	2017-10-19T14:03:11Z ALERT hw3:/jdoe/Main.java (42) matches 91.2% hw3:/asmith/Main.java (17)
+
+EXAMPLES:
+
+Flag late submissions the moment they land:
+
+	paraphrase watch --namespace hw3 --check --alert-log alerts.log /srv/dropbox/hw3
+
+Only check against earlier years and leave out each student's own work:
+
+	paraphrase watch --namespace hw3-2017 --check -n "hw3-*" --exclude-same-author /srv/dropbox/hw3
+`,
+	Annotations: map[string]string{runLocallyAnnotation: "it would keep the daemon busy forever"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("You must specify one directory to watch")
+		}
+
+		if watchNamespace == "" {
+			return errors.New("You must specify the --namespace to add files to")
+		}
+
+		var alerts io.Writer = os.Stdout
+		if watchAlertLog != "" {
+			file, err := os.OpenFile(watchAlertLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			alerts = io.MultiWriter(os.Stdout, file)
+		}
+
+		stop := make(chan struct{})
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			log.Println("Stopping, adding the files already found")
+			close(stop)
+		}()
+
+		producer, err := provider.NewWatchProducer(args[0], watchNamespace, watchPoll, watchForcePoll, stop)
+		if err != nil {
+			return err
+		}
+
+		if watchMatch != WILDCARD {
+			producer, err = provider.NewFilterWrapper(watchMatch, producer)
+			if err != nil {
+				return err
+			}
+		}
+
+		log.Printf("Watching %s for namespace %s\n", args[0], watchNamespace)
+
+		open := func() (*paraphrase.ParaphraseDb, error) {
+			return openLocalDb(cmd, false)
+		}
+
+		return paraphrase.Watch(open, producer, watchOptions, func(alert paraphrase.WatchAlert) {
+			writeWatchAlert(alerts, alert)
+		})
+	},
+}
+
+// writeWatchAlert writes one line per match so alert logs can be grepped.
+func writeWatchAlert(w io.Writer, alert paraphrase.WatchAlert) {
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	for _, match := range alert.Matches {
+		fmt.Fprintf(w, "%s ALERT %s:%s (%d) matches %.1f%% %s:%s (%d)\n", now,
+			alert.Doc.Namespace, alert.Doc.Path, alert.Doc.Id,
+			match.Similarity()*100, match.Doc.Namespace, match.Doc.Path, match.Doc.Id)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"github.com/josephlewis42/paraphrase/cmd"
+	"github.com/josephlewis42/paraphrase/paraphrase"
 )
 
 var (
@@ -17,6 +18,7 @@ func main() {
 	cmd.Version = Version
 	cmd.Build = Build
 	cmd.Branch = Branch
+	paraphrase.Version = Version
 
 	if err := cmd.RootCmd.Execute(); err != nil {
 		fmt.Println(err)
@@ -0,0 +1,283 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+package paraphrase
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/boltdb/bolt"
+)
+
+const (
+	ChecksumExt = ".sha256"
+)
+
+var (
+	ChecksumMismatchErr = errors.New("The backup does not match its checksum, it may be corrupt")
+
+	gzipMagic = []byte{0x1f, 0x8b}
+)
+
+// Backup writes a consistent snapshot of the database to dest while other
+// readers keep working and returns the sha256 of the file written. If
+// compress is set the snapshot is gzipped, if checksum is set a sha256sum
+// compatible file is written next to it.
+//
+// Backups aren't recorded in the changelog as they're usually taken from a
+// read-only database, see LogBackup.
+func (p *ParaphraseDb) Backup(dest string, compress, checksum bool) (sum string, err error) {
+	snapshot, ok := p.store.(snapshotter)
+	if !ok {
+		return "", UnsupportedErr
+	}
+
+	if bs, ok := p.store.(*boltStorage); ok && sameFile(bs.path, dest) {
+		return "", fmt.Errorf("%v is the database, write the backup somewhere else", dest)
+	}
+
+	// a failed backup mustn't leave a partial file or replace a good one
+	file, err := ioutil.TempFile(filepath.Dir(dest), filepath.Base(dest)+".tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	hash := sha256.New()
+	var out io.Writer = io.MultiWriter(file, hash)
+
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(out)
+		out = gz
+	}
+
+	err = snapshot.WriteSnapshot(out)
+	if err != nil {
+		return "", err
+	}
+
+	if gz != nil {
+		err = gz.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err = file.Close()
+	if err != nil {
+		return "", err
+	}
+
+	err = os.Rename(file.Name(), dest)
+	if err != nil {
+		return "", err
+	}
+
+	sum = hex.EncodeToString(hash.Sum(nil))
+
+	if checksum {
+		line := fmt.Sprintf("%s  %s\n", sum, filepath.Base(dest))
+
+		err = ioutil.WriteFile(dest+ChecksumExt, []byte(line), 0644)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return sum, nil
+}
+
+// LogBackup records a backup written by Backup in the changelog, the database
+// must be writable.
+func (p *ParaphraseDb) LogBackup(dest, sum string, compress bool) error {
+	_, err := p.appendChange(ChangeLogEntry{Operation: OpBackup}, "Backed up database to %v, compressed? %v, sha256 %v", dest, compress, sum)
+	return err
+}
+
+// Restore replaces the database in directory with the backup at src. The
+// backup is checked against its checksum file if there is one, and its
+// settings and schema version are validated before anything is replaced.
+// The database being replaced is kept next to it.
+func Restore(directory, src string) error {
+	err := verifyChecksum(src)
+	if err != nil {
+		return err
+	}
+
+	target := FindDbPath(directory)
+
+	// Unpack next to the target so the final rename is atomic.
+	tmpPath, err := unpackBackup(src, filepath.Dir(target))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	version, err := validateBackup(tmpPath)
+	if err != nil {
+		return fmt.Errorf("%s is not a usable backup: %s", src, err)
+	}
+
+	// Hold the lock on any existing database until it has been replaced.
+	if _, err := os.Stat(target); err == nil {
+		existing, err := bolt.Open(target, 0600, &bolt.Options{Timeout: lockTimeout})
+		if err == bolt.ErrTimeout {
+			return lockedError(target)
+		}
+		if err != nil {
+			return err
+		}
+		defer existing.Close()
+
+		backup := fmt.Sprintf("%s.pre-restore-%s.bak", target, time.Now().Format(backupTimeFormat))
+		err = existing.View(func(tx *bolt.Tx) error {
+			return tx.CopyFile(backup, 0600)
+		})
+		if err != nil {
+			return fmt.Errorf("Could not back up the database before restoring: %s", err)
+		}
+		log.Printf("Backed up database to %s before restoring\n", backup)
+	}
+
+	log.Printf("Replacing %v with %v\n", target, src)
+	err = os.Rename(tmpPath, target)
+	if err != nil {
+		return err
+	}
+
+	db, err := Open(directory)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	db.logChange(ChangeLogEntry{Operation: OpRestore}, "Restored database from %v (schema version %d)", src, version)
+	return nil
+}
+
+// sameFile checks if the paths are the same file, false if either is missing.
+func sameFile(a, b string) bool {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(infoA, infoB)
+}
+
+// verifyChecksum checks src against src.sha256 if it exists.
+func verifyChecksum(src string) error {
+	expected, err := ioutil.ReadFile(src + ChecksumExt)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	fields := strings.Fields(string(expected))
+	if len(fields) == 0 {
+		return ChecksumMismatchErr
+	}
+
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return err
+	}
+
+	if hex.EncodeToString(hash.Sum(nil)) != strings.ToLower(fields[0]) {
+		return ChecksumMismatchErr
+	}
+
+	return nil
+}
+
+// unpackBackup copies src into a temporary file in directory, decompressing
+// it if needed, and returns the temporary file's path.
+func unpackBackup(src, directory string) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	buffered := bufio.NewReader(in)
+	var reader io.Reader = buffered
+
+	magic, err := buffered.Peek(len(gzipMagic))
+	if err == nil && bytes.Equal(magic, gzipMagic) {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return "", err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	out, err := ioutil.TempFile(directory, filepath.Base(src)+".restore")
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, reader)
+	if err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+
+	return out.Name(), out.Close()
+}
+
+// validateBackup makes sure the database at dbPath has settings and a schema
+// version this binary can read, returning the schema version.
+func validateBackup(dbPath string) (int, error) {
+	store, err := openBoltStorage(dbPath, storm.BoltOptions(0600, &bolt.Options{Timeout: lockTimeout}))
+	if err != nil {
+		return 0, err
+	}
+	defer store.Close()
+
+	_, err = store.Settings()
+	if err != nil {
+		return 0, err
+	}
+
+	version, err := store.SchemaVersion()
+	if err != nil {
+		return 0, err
+	}
+
+	if version > LatestSchemaVersion() {
+		return 0, &SchemaVersionErr{version, LatestSchemaVersion()}
+	}
+
+	return version, nil
+}
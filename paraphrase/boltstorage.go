@@ -0,0 +1,418 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+package paraphrase
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/asdine/storm"
+	"github.com/boltdb/bolt"
+	"github.com/josephlewis42/paraphrase/paraphrase/snappyjson"
+)
+
+const (
+	PostingsBucket = "postings"
+	HeadersBucket  = "headers"
+
+	// postings are keyed by the big endian hash followed by the document id
+	// so all the postings of a hash are next to each other.
+	postingKeyLen = 16
+)
+
+// boltStorage keeps everything in a single bolt file through storm, except
+// for postings and document headers which are written directly to bolt to
+// keep them compact.
+type boltStorage struct {
+	path string
+	db   *storm.DB
+	// holder is set when this process recorded itself in the LockInfo.
+	holder bool
+}
+
+// NewBoltStorage opens (or creates) the bolt file at the given path.
+func NewBoltStorage(path string) (Storage, error) {
+	return openBoltStorage(path)
+}
+
+func openBoltStorage(path string, options ...func(*storm.DB) error) (*boltStorage, error) {
+	var s boltStorage
+	var err error
+
+	s.path = path
+
+	options = append([]func(*storm.DB) error{storm.Codec(snappyjson.MsgpackCodec)}, options...)
+	s.db, err = storm.Open(path, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	// read-only databases were initialized when they were written
+	if s.db.Bolt.IsReadOnly() {
+		return &s, nil
+	}
+
+	err = s.init()
+	if err != nil {
+		s.db.Close()
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+func (s *boltStorage) init() error {
+	for _, data := range []interface{}{&Document{}, &DocumentData{}, &Settings{}, &ChangeLogEntry{}, &PairVerdict{}, &Hook{}} {
+		err := s.db.Init(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.db.Bolt.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range []string{PostingsBucket, HeadersBucket} {
+			_, err := tx.CreateBucketIfNotExists([]byte(bucket))
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *boltStorage) Close() error {
+	if s.holder {
+		removeLockInfo(s.path)
+	}
+
+	return s.db.Close()
+}
+
+// holdLock records the current process in the LockInfo until it's closed.
+func (s *boltStorage) holdLock(command string) error {
+	err := writeLockInfo(s.path, command)
+	if err != nil {
+		return err
+	}
+
+	s.holder = true
+	return nil
+}
+
+func (s *boltStorage) SaveDocument(doc *Document, data *DocumentData) error {
+	return s.db.Bolt.Update(func(tx *bolt.Tx) error {
+		node := s.db.WithTransaction(tx)
+		postings := tx.Bucket([]byte(PostingsBucket))
+
+		// replace the postings of any previous version of the document
+		var old Document
+		err := node.One("Id", doc.Id, &old)
+		if err == nil {
+			err = deletePostings(postings, &old)
+		}
+		if err != nil && err != storm.ErrNotFound {
+			return err
+		}
+
+		for hash, count := range doc.Hashes {
+			err := putPosting(postings, IndexEntry{hash, doc.Id, count})
+			if err != nil {
+				return err
+			}
+		}
+
+		err = putHeader(tx.Bucket([]byte(HeadersBucket)), doc.Header())
+		if err != nil {
+			return err
+		}
+
+		err = node.Save(doc)
+		if err != nil {
+			return err
+		}
+
+		return node.Save(data)
+	})
+}
+
+func (s *boltStorage) DeleteDocument(id int64) error {
+	return s.db.Bolt.Update(func(tx *bolt.Tx) error {
+		node := s.db.WithTransaction(tx)
+
+		var doc Document
+		err := node.One("Id", id, &doc)
+		if err != nil {
+			return convertStormErr(err)
+		}
+
+		err = deletePostings(tx.Bucket([]byte(PostingsBucket)), &doc)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Bucket([]byte(HeadersBucket)).Delete(idKey(id))
+		if err != nil {
+			return err
+		}
+
+		err = node.DeleteStruct(&doc)
+		if err != nil {
+			return err
+		}
+
+		err = node.DeleteStruct(&DocumentData{Id: id})
+		if err != storm.ErrNotFound {
+			return err
+		}
+
+		return nil
+	})
+}
+
+func (s *boltStorage) Document(id int64) (*Document, error) {
+	var doc Document
+	err := s.db.One("Id", id, &doc)
+	return &doc, convertStormErr(err)
+}
+
+func (s *boltStorage) DocumentData(id int64) (*DocumentData, error) {
+	var data DocumentData
+	err := s.db.One("Id", id, &data)
+	return &data, convertStormErr(err)
+}
+
+func (s *boltStorage) DocumentHeader(id int64) (*DocumentHeader, error) {
+	var header DocumentHeader
+
+	err := s.db.Bolt.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket([]byte(HeadersBucket)).Get(idKey(id))
+		if value == nil {
+			return NotFoundErr
+		}
+
+		return snappyjson.MsgpackCodec.Unmarshal(value, &header)
+	})
+
+	return &header, err
+}
+
+func (s *boltStorage) DocumentsBySha1(sha1 string) (results []Document, err error) {
+	err = s.db.Find("Sha1", sha1, &results)
+	return results, maskErrNotFound(convertStormErr(err))
+}
+
+func (s *boltStorage) CountDocuments() (int, error) {
+	return s.db.Count(&Document{})
+}
+
+func (s *boltStorage) EachDocument(fn func(doc *Document) error) error {
+	err := s.db.Select().Each(new(Document), func(record interface{}) error {
+		return fn(record.(*Document))
+	})
+
+	return maskErrNotFound(convertStormErr(err))
+}
+
+func (s *boltStorage) EachDocumentData(fn func(data *DocumentData) error) error {
+	err := s.db.Select().Each(new(DocumentData), func(record interface{}) error {
+		return fn(record.(*DocumentData))
+	})
+
+	return maskErrNotFound(convertStormErr(err))
+}
+
+func (s *boltStorage) Postings(hash uint64) (entries []IndexEntry, err error) {
+	prefix := make([]byte, 8)
+	binary.BigEndian.PutUint64(prefix, hash)
+
+	err = s.db.Bolt.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(PostingsBucket)).Cursor()
+
+		for k, v := c.Seek(prefix); k != nil && hasHash(k, hash); k, v = c.Next() {
+			entries = append(entries, decodePosting(k, v))
+		}
+
+		return nil
+	})
+
+	return entries, err
+}
+
+func (s *boltStorage) DeletePosting(entry IndexEntry) error {
+	return s.db.Bolt.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(PostingsBucket)).Delete(postingKey(entry.Hash, entry.Doc))
+	})
+}
+
+func (s *boltStorage) CountHashes() (int, error) {
+	count := 0
+	first := true
+	var last uint64
+
+	err := s.EachPosting(func(entry *IndexEntry) error {
+		if first || entry.Hash != last {
+			count++
+		}
+
+		first = false
+		last = entry.Hash
+		return nil
+	})
+
+	return count, err
+}
+
+func (s *boltStorage) EachPosting(fn func(entry *IndexEntry) error) error {
+	return s.db.Bolt.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(PostingsBucket)).ForEach(func(k, v []byte) error {
+			entry := decodePosting(k, v)
+			return fn(&entry)
+		})
+	})
+}
+
+func (s *boltStorage) Settings() (Settings, error) {
+	var settings Settings
+	err := s.db.One("Version", CurrentSettingsVersion, &settings)
+
+	if err == storm.ErrNotFound {
+		return settings, SettingsNotDefinedErr
+	}
+
+	return settings, err
+}
+
+func (s *boltStorage) SaveSettings(settings Settings) error {
+	return s.db.Save(&settings)
+}
+
+func (s *boltStorage) AppendChange(change *ChangeLogEntry) error {
+	return s.db.Bolt.Update(func(tx *bolt.Tx) error {
+		node := s.db.WithTransaction(tx)
+
+		var last []ChangeLogEntry
+		err := node.All(&last, storm.Limit(1), storm.Reverse())
+		if err != nil && err != storm.ErrNotFound {
+			return err
+		}
+
+		var prev *ChangeLogEntry
+		if len(last) > 0 {
+			prev = &last[0]
+		}
+
+		chainChange(prev, change)
+		return node.Save(change)
+	})
+}
+
+func (s *boltStorage) EachChange(fn func(change *ChangeLogEntry) error) error {
+	err := s.db.Select().Each(new(ChangeLogEntry), func(record interface{}) error {
+		return fn(record.(*ChangeLogEntry))
+	})
+
+	return maskErrNotFound(convertStormErr(err))
+}
+
+func (s *boltStorage) SaveVerdict(verdict *PairVerdict) error {
+	return s.db.Save(verdict)
+}
+
+func (s *boltStorage) Verdict(id string) (*PairVerdict, error) {
+	var verdict PairVerdict
+	err := s.db.One("Id", id, &verdict)
+	return &verdict, convertStormErr(err)
+}
+
+func (s *boltStorage) EachVerdict(fn func(verdict *PairVerdict) error) error {
+	err := s.db.Select().Each(new(PairVerdict), func(record interface{}) error {
+		return fn(record.(*PairVerdict))
+	})
+
+	return maskErrNotFound(convertStormErr(err))
+}
+
+func (s *boltStorage) SaveHook(hook *Hook) error {
+	return s.db.Save(hook)
+}
+
+func (s *boltStorage) DeleteHook(id int) error {
+	return convertStormErr(s.db.DeleteStruct(&Hook{Id: id}))
+}
+
+func (s *boltStorage) EachHook(fn func(hook *Hook) error) error {
+	err := s.db.Select().Each(new(Hook), func(record interface{}) error {
+		return fn(record.(*Hook))
+	})
+
+	return maskErrNotFound(convertStormErr(err))
+}
+
+// WriteSnapshot writes a consistent copy of the bolt file from a read
+// transaction so other readers aren't blocked.
+func (s *boltStorage) WriteSnapshot(w io.Writer) error {
+	return s.db.Bolt.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(w)
+		return err
+	})
+}
+
+func convertStormErr(err error) error {
+	if err == storm.ErrNotFound {
+		return NotFoundErr
+	}
+
+	return err
+}
+
+func postingKey(hash uint64, docId int64) []byte {
+	key := make([]byte, postingKeyLen)
+	binary.BigEndian.PutUint64(key, hash)
+	binary.BigEndian.PutUint64(key[8:], uint64(docId))
+	return key
+}
+
+func hasHash(key []byte, hash uint64) bool {
+	return len(key) == postingKeyLen && binary.BigEndian.Uint64(key) == hash
+}
+
+func decodePosting(key, value []byte) IndexEntry {
+	return IndexEntry{
+		Hash:      binary.BigEndian.Uint64(key),
+		Doc:       int64(binary.BigEndian.Uint64(key[8:])),
+		Frequency: int16(binary.BigEndian.Uint16(value)),
+	}
+}
+
+func putPosting(postings *bolt.Bucket, entry IndexEntry) error {
+	value := make([]byte, 2)
+	binary.BigEndian.PutUint16(value, uint16(entry.Frequency))
+	return postings.Put(postingKey(entry.Hash, entry.Doc), value)
+}
+
+func idKey(id int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(id))
+	return key
+}
+
+func putHeader(headers *bolt.Bucket, header *DocumentHeader) error {
+	value, err := snappyjson.MsgpackCodec.Marshal(header)
+	if err != nil {
+		return err
+	}
+
+	return headers.Put(idKey(header.Id), value)
+}
+
+func deletePostings(postings *bolt.Bucket, doc *Document) error {
+	for hash := range doc.Hashes {
+		err := postings.Delete(postingKey(hash, doc.Id))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
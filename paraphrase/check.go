@@ -0,0 +1,149 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+package paraphrase
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+const (
+	FindingsText  = "text"
+	FindingsJson  = "json"
+	FindingsSarif = "sarif"
+
+	findingHeader = "Containment\tSimilarity\tFile\tLines\tMatched ID\tMatched Namespace\tMatched Path"
+	findingFormat = "%.3f\t%.3f\t%v\t%v\t%v\t%v\t%v\n"
+)
+
+// Finding is a checked file that contains too much of a protected document.
+type Finding struct {
+	Path string
+	// Containment is the fraction of the file's fingerprints found in the
+	// matched document.
+	Containment float64
+	Similarity  float64
+	Match       *Document
+	Regions     []Region
+}
+
+type findingJson struct {
+	Path        string    `json:"path"`
+	Containment float64   `json:"containment"`
+	Similarity  float64   `json:"similarity"`
+	Regions     []Region  `json:"regions"`
+	Match       matchJson `json:"match"`
+}
+
+type matchJson struct {
+	Id        int64  `json:"id"`
+	Namespace string `json:"namespace"`
+	Path      string `json:"path"`
+	Sha1      string `json:"sha1"`
+}
+
+// Lines gets the line ranges of the finding's regions like "1-5,10-12".
+func (f *Finding) Lines() string {
+	out := ""
+	for i, region := range f.Regions {
+		if i > 0 {
+			out += ","
+		}
+
+		if region.StartLine == region.EndLine {
+			out += fmt.Sprintf("%d", region.StartLine)
+		} else {
+			out += fmt.Sprintf("%d-%d", region.StartLine, region.EndLine)
+		}
+	}
+
+	return out
+}
+
+// CheckDocument compares a body against the documents in namespaces matching
+// any of the given globs (or all documents but licenses if there are none)
+// and returns a finding for each document containing at least threshold of
+// the body.
+func (p *ParaphraseDb) CheckDocument(path string, body []byte, namespaces []string, threshold float64) ([]Finding, error) {
+	var findings []Finding
+
+	options := SearchOptions{Namespaces: namespaces}
+	if len(namespaces) == 0 {
+		options.ExcludeNamespaces = []string{LicenseNamespace}
+	}
+
+	query, err := p.WinnowData(body)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(query) == 0 {
+		return nil, nil
+	}
+
+	results, err := p.QueryByVector(query, options)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, result := range results {
+		shared := make(TermCountVector)
+		for hash, count := range query {
+			if _, ok := result.Doc.Hashes[hash]; ok {
+				shared[hash] = count
+			}
+		}
+
+		containment := float64(len(shared)) / float64(len(query))
+		if containment < threshold {
+			continue
+		}
+
+		findings = append(findings, Finding{
+			Path:        path,
+			Containment: containment,
+			Similarity:  result.Similarity(),
+			Match:       result.Doc,
+			Regions:     Regions(body, p.MatchingSpans(body, shared)),
+		})
+	}
+
+	return findings, nil
+}
+
+// WriteFindings writes findings as text, json or sarif. toolVersion is
+// reported in SARIF output.
+func WriteFindings(w io.Writer, findings []Finding, format, toolVersion string) error {
+	switch format {
+	case FindingsText, "":
+		tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+
+		fmt.Fprintln(tw, findingHeader)
+		for _, f := range findings {
+			fmt.Fprintf(tw, findingFormat, f.Containment, f.Similarity, f.Path, f.Lines(), f.Match.Id, f.Match.Namespace, f.Match.Path)
+		}
+
+		return tw.Flush()
+
+	case FindingsJson:
+		out := make([]findingJson, len(findings))
+		for i, f := range findings {
+			out[i] = findingJson{f.Path, f.Containment, f.Similarity, f.Regions,
+				matchJson{f.Match.Id, f.Match.Namespace, f.Match.Path, f.Match.Sha1}}
+		}
+
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(out)
+
+	case FindingsSarif:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(newSarifLog(findings, toolVersion))
+
+	default:
+		return fmt.Errorf("Unknown format %q, expected %s, %s or %s", format, FindingsText, FindingsJson, FindingsSarif)
+	}
+}
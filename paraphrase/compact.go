@@ -0,0 +1,250 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+package paraphrase
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/boltdb/bolt"
+)
+
+const (
+	// How long to wait for other processes to release the database.
+	lockTimeout = time.Second
+)
+
+var (
+	DatabaseLockedErr = errors.New("The database is in use by another process, try again when it has finished")
+)
+
+// CompactionStats describes the result of a compaction.
+type CompactionStats struct {
+	OriginalSize  int64
+	CompactedSize int64
+	Documents     int
+	Hashes        int
+}
+
+// Saved gets the number of bytes freed by the compaction.
+func (cs *CompactionStats) Saved() int64 {
+	return cs.OriginalSize - cs.CompactedSize
+}
+
+// Compact rewrites the database in the given directory into a fresh file
+// containing only live data. The new file is verified against the original
+// before it atomically replaces it.
+func Compact(directory string) (*CompactionStats, error) {
+	var stats CompactionStats
+
+	source := FindDbPath(directory)
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, DatabaseDNEErr
+	}
+	stats.OriginalSize = info.Size()
+
+	// The exclusive lock keeps readers out too, they would be left reading
+	// the file being replaced.
+	log.Println("Opening database")
+	src, err := bolt.Open(source, info.Mode(), &bolt.Options{Timeout: lockTimeout})
+	if err == bolt.ErrTimeout {
+		return nil, lockedError(source)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeLockInfo(source, "compact")
+	if err != nil {
+		log.Printf("Could not record the lock holder: %s\n", err)
+	}
+
+	released := false
+	release := func() {
+		if !released {
+			released = true
+			removeLockInfo(source)
+			src.Close()
+		}
+	}
+	defer release()
+
+	// The temporary file must live next to the original so the final rename
+	// stays on one filesystem and is atomic.
+	tmpFile, err := ioutil.TempFile(filepath.Dir(source), filepath.Base(source)+".compact")
+	if err != nil {
+		return nil, err
+	}
+	tmpPath := tmpFile.Name()
+	tmpFile.Close()
+	defer os.Remove(tmpPath)
+
+	log.Printf("Compacting into %v\n", tmpPath)
+	dst, err := bolt.Open(tmpPath, info.Mode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = copyBuckets(src, dst)
+	if err != nil {
+		dst.Close()
+		return nil, err
+	}
+
+	log.Println("Verifying compacted database")
+	stats.Documents, stats.Hashes, err = verifyCompaction(src, dst)
+	if err != nil {
+		dst.Close()
+		return nil, err
+	}
+
+	err = dst.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	info, err = os.Stat(tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	stats.CompactedSize = info.Size()
+
+	// Swap while we still hold the lock on the original so no other process
+	// can write to it between the copy and the rename.
+	log.Printf("Replacing %v\n", source)
+	err = os.Rename(tmpPath, source)
+	if err != nil {
+		return nil, err
+	}
+	release()
+
+	db, err := Open(directory)
+	if err != nil {
+		log.Printf("Could not record the compaction in the changelog: %v\n", err)
+		return &stats, nil
+	}
+	defer db.Close()
+
+	db.logChange(ChangeLogEntry{Operation: OpCompact}, "Compacted database from %v to %v bytes", stats.OriginalSize, stats.CompactedSize)
+
+	return &stats, nil
+}
+
+// copyBuckets copies every top level bucket from src into dst, one
+// transaction per bucket.
+func copyBuckets(src, dst *bolt.DB) error {
+	return src.View(func(srcTx *bolt.Tx) error {
+		return srcTx.ForEach(func(name []byte, srcBucket *bolt.Bucket) error {
+			return dst.Update(func(dstTx *bolt.Tx) error {
+				dstBucket, err := dstTx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+
+				return copyBucket(srcBucket, dstBucket)
+			})
+		})
+	})
+}
+
+func copyBucket(src, dst *bolt.Bucket) error {
+	// Keys are inserted in order so pages can be packed completely.
+	dst.FillPercent = 1.0
+
+	err := dst.SetSequence(src.Sequence())
+	if err != nil {
+		return err
+	}
+
+	return src.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return dst.Put(k, v)
+		}
+
+		child, err := dst.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+
+		return copyBucket(src.Bucket(k), child)
+	})
+}
+
+// verifyCompaction checks every bucket in dst holds the same number of keys
+// as the one in src and returns the document and hash counts of dst.
+func verifyCompaction(src, dst *bolt.DB) (documents, hashes int, err error) {
+	srcKeys, err := countKeys(src)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	dstKeys, err := countKeys(dst)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if len(srcKeys) != len(dstKeys) {
+		return 0, 0, fmt.Errorf("Compaction produced %d buckets, expected %d", len(dstKeys), len(srcKeys))
+	}
+
+	for bucket, count := range srcKeys {
+		if dstKeys[bucket] != count {
+			return 0, 0, fmt.Errorf("Compacted bucket %q has %d keys, expected %d", bucket, dstKeys[bucket], count)
+		}
+	}
+
+	compacted, err := openBoltStorage(dst.Path(), storm.UseDB(dst))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	documents, err = compacted.CountDocuments()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	hashes, err = compacted.CountHashes()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return documents, hashes, nil
+}
+
+// countKeys gets the number of non-bucket keys in every bucket of the
+// database keyed by the bucket's path.
+func countKeys(db *bolt.DB) (map[string]int, error) {
+	counts := make(map[string]int)
+
+	var count func(path []string, b *bolt.Bucket) error
+	count = func(path []string, b *bolt.Bucket) error {
+		name := strings.Join(path, "/")
+		counts[name] = 0
+
+		return b.ForEach(func(k, v []byte) error {
+			if v != nil {
+				counts[name]++
+				return nil
+			}
+
+			return count(append(path, string(k)), b.Bucket(k))
+		})
+	}
+
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			return count([]string{string(name)}, b)
+		})
+	})
+
+	return counts, err
+}
@@ -0,0 +1,171 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+package paraphrase
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/bradfitz/slice"
+)
+
+const (
+	pairHeader = "Score\tA\tB"
+	pairFormat = "%.3f\t%v\t%v\n"
+)
+
+// Pair is the similarity between two documents.
+type Pair struct {
+	A          *Document
+	B          *Document
+	Similarity float64
+}
+
+// ComparisonMatrix holds the similarity of every document in one namespace
+// to every document in another.
+type ComparisonMatrix struct {
+	Rows    []Document
+	Columns []Document
+	Scores  [][]float64
+}
+
+// CompareNamespaces scores every document in namespace a against every
+// document in namespace b. Namespaces are matched exactly.
+func (p *ParaphraseDb) CompareNamespaces(a, b string) (*ComparisonMatrix, error) {
+	var matrix ComparisonMatrix
+	var err error
+
+	matrix.Rows, err = p.documentsInNamespace(a)
+	if err != nil {
+		return nil, err
+	}
+
+	matrix.Columns, err = p.documentsInNamespace(b)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make(map[int64]int)
+	for i, doc := range matrix.Columns {
+		columns[doc.Id] = i
+	}
+
+	for _, doc := range matrix.Rows {
+		scores := make([]float64, len(matrix.Columns))
+
+		results, err := p.QueryByVector(doc.Hashes, SearchOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, result := range results {
+			if col, ok := columns[result.Doc.Id]; ok {
+				scores[col] = result.Similarity()
+			}
+		}
+
+		matrix.Scores = append(matrix.Scores, scores)
+	}
+
+	return &matrix, nil
+}
+
+func (p *ParaphraseDb) documentsInNamespace(namespace string) (docs []Document, err error) {
+	err = p.store.EachDocument(func(doc *Document) error {
+		if doc.Namespace == namespace {
+			docs = append(docs, *doc)
+		}
+
+		return nil
+	})
+
+	slice.Sort(docs, func(i, j int) bool {
+		return docs[i].Path < docs[j].Path
+	})
+
+	return docs, err
+}
+
+// BestPairs gets the best match in the columns for every row that matched
+// anything, most similar first.
+func (m *ComparisonMatrix) BestPairs() []Pair {
+	var pairs []Pair
+
+	for row, scores := range m.Scores {
+		best := -1
+		for col, score := range scores {
+			if score > 0 && (best < 0 || score > scores[best]) {
+				best = col
+			}
+		}
+
+		if best >= 0 {
+			pairs = append(pairs, Pair{&m.Rows[row], &m.Columns[best], scores[best]})
+		}
+	}
+
+	slice.Sort(pairs, func(i, j int) bool {
+		return pairs[i].Similarity > pairs[j].Similarity
+	})
+
+	return pairs
+}
+
+// PairsAbove gets every pair with a similarity of at least threshold.
+func (m *ComparisonMatrix) PairsAbove(threshold float64) []Pair {
+	var pairs []Pair
+
+	for row, scores := range m.Scores {
+		for col, score := range scores {
+			if score >= threshold {
+				pairs = append(pairs, Pair{&m.Rows[row], &m.Columns[col], score})
+			}
+		}
+	}
+
+	return pairs
+}
+
+// WriteMatrix writes the matrix in fashion suitable for displaying on-screen.
+// Columns are numbered and listed after the table to keep it narrow.
+func (m *ComparisonMatrix) WriteMatrix(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', tabwriter.AlignRight)
+
+	fmt.Fprint(tw, "\t")
+	for col := range m.Columns {
+		fmt.Fprintf(tw, "[%d]\t", col+1)
+	}
+	fmt.Fprintln(tw)
+
+	for row, scores := range m.Scores {
+		fmt.Fprintf(tw, "%v\t", m.Rows[row].Path)
+		for _, score := range scores {
+			if score == 0 {
+				fmt.Fprint(tw, "-\t")
+			} else {
+				fmt.Fprintf(tw, "%.2f\t", score)
+			}
+		}
+		fmt.Fprintln(tw)
+	}
+
+	tw.Flush()
+
+	fmt.Fprintln(w)
+	for col, doc := range m.Columns {
+		fmt.Fprintf(w, "[%d] %v\n", col+1, doc.Path)
+	}
+}
+
+// WritePairs writes the pairs in fashion suitable for displaying on-screen.
+func WritePairs(w io.Writer, pairs []Pair) {
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+
+	fmt.Fprintln(tw, pairHeader)
+	for _, pair := range pairs {
+		fmt.Fprintf(tw, pairFormat, pair.Similarity, pair.A.Path, pair.B.Path)
+	}
+
+	tw.Flush()
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"os"
 	"path"
 	"regexp"
 	"strings"
@@ -12,10 +13,9 @@ import (
 	"time"
 
 	"github.com/asdine/storm"
-	"github.com/asdine/storm/q"
+	"github.com/boltdb/bolt"
 	"github.com/bradhe/stopwatch"
 	"github.com/josephlewis42/paraphrase/paraphrase/provider"
-	"github.com/josephlewis42/paraphrase/paraphrase/snappyjson"
 	"gopkg.in/cheggaaa/pb.v1"
 )
 
@@ -60,143 +60,228 @@ func NewDefaultSettings() Settings {
 }
 
 type ParaphraseDb struct {
-	directory string
-	settings  Settings
-	db        *storm.DB
+	settings Settings
+	store    Storage
+
+	// hooksAllowed lets hooks run, see SetRunHooks.
+	hooksAllowed bool
+	// hooksSkipped is set once skipping hooks has been logged.
+	hooksSkipped bool
 }
 
 // Creates a new database in the given directory with the given settings
 func Create(directory string, settings Settings) (*ParaphraseDb, error) {
-	db, err := Open(directory)
-
-	switch err {
-	case nil:
-		return nil, AlreadyInitializedErr
-	case SettingsNotDefinedErr:
-		db.settings = settings
-		db.logChange("Created Database")
-		return db, db.saveSettings()
-	default:
+	store, err := openBoltStorage(FindDbPath(directory))
+	if err != nil {
 		return nil, err
 	}
+
+	db, err := CreateWith(store, settings)
+	if err != nil {
+		store.Close()
+	}
+
+	return db, err
+}
+
+// OpenOptions controls how OpenWithOptions opens a database file.
+type OpenOptions struct {
+	// ReadOnly shares the database with other readers, nothing can be
+	// written and writers wait until every reader is done.
+	ReadOnly bool
+	// Timeout is how long to wait for other processes to release the
+	// database, DefaultLockTimeout if it's not positive.
+	Timeout time.Duration
+	// Command is what the process is doing, it's shown to other processes
+	// waiting on a database opened for writing.
+	Command string
 }
 
 // Open or create a new paraphrase database in the given directory
 func Open(directory string) (*ParaphraseDb, error) {
+	return OpenWithOptions(directory, OpenOptions{})
+}
 
-	var paraphrase ParaphraseDb
-	var err error
-
-	paraphrase.directory = directory
+// OpenWithOptions opens the database in the given directory. If another
+// process holds it for longer than the timeout a DatabaseLockedError naming
+// the process is returned. Databases needing a migration are briefly opened
+// for writing to run it before they're opened read-only.
+func OpenWithOptions(directory string, options OpenOptions) (*ParaphraseDb, error) {
+	path := FindDbPath(directory)
 
-	// Open the my.db data file in your current directory.
-	// It will be created if it doesn't exist.
-	dbPath := FindDbPath(directory)
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultLockTimeout
+	}
 
-	// paraphrase.db, err = storm.Open(dbPath, storm.Codec(snappyjson.Codec))
-	paraphrase.db, err = storm.Open(dbPath, storm.Codec(snappyjson.MsgpackCodec))
-	// paraphrase.db, err = storm.Open(dbPath)
-	if err != nil {
-		return nil, err
+	if options.ReadOnly {
+		// read-only opens can't create the file
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return nil, DatabaseDNEErr
+		}
 	}
 
-	err = paraphrase.init()
+	before, _ := os.Stat(path)
+
+	boltOptions := &bolt.Options{Timeout: options.Timeout, ReadOnly: options.ReadOnly}
+	store, err := openBoltStorage(path, storm.BoltOptions(0600, boltOptions))
+	if err == bolt.ErrTimeout {
+		return nil, lockedError(path)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("Could not open the database: %s", err)
 	}
 
-	err = paraphrase.loadSettings()
-	if err != nil {
-		return &paraphrase, err
+	// Compact and Restore rename a new file over the database while holding
+	// its lock, anyone waiting on it gets the lock of the file left behind.
+	if after, err := os.Stat(path); before != nil && (err != nil || !os.SameFile(before, after)) {
+		store.Close()
+		return OpenWithOptions(directory, options)
 	}
 
-	return &paraphrase, nil
-}
+	if !options.ReadOnly {
+		err = store.holdLock(options.Command)
+		if err != nil {
+			log.Printf("Could not record the lock holder: %s\n", err)
+		}
+	}
 
-func FindDbPath(directory string) string {
+	db, err := OpenWith(store)
+	if err != migrationNeededErr {
+		return db, err
+	}
 
-	if path.Ext(directory) == DbExt {
-		return directory
+	writable := options
+	writable.ReadOnly = false
+
+	db, err = OpenWithOptions(directory, writable)
+	if err != nil {
+		return nil, err
 	}
+	db.Close()
 
-	return path.Join(directory, DbName)
+	return OpenWithOptions(directory, options)
 }
 
-func (p *ParaphraseDb) init() error {
+// NewMemoryDb creates a database that only lives in memory, useful for
+// one-off comparisons that shouldn't leave a database behind.
+func NewMemoryDb(settings Settings) (*ParaphraseDb, error) {
+	return CreateWith(NewMemoryStorage(), settings)
+}
 
-	err := p.db.Init(&Document{})
-	if err != nil {
-		return err
-	}
+// CreateWith initializes empty storage with the given settings.
+func CreateWith(store Storage, settings Settings) (*ParaphraseDb, error) {
+	db, err := OpenWith(store)
 
-	err = p.db.Init(&DocumentData{})
-	if err != nil {
-		return err
+	switch err {
+	case nil:
+		return nil, AlreadyInitializedErr
+	case SettingsNotDefinedErr:
+		db.settings = settings
+		db.logChange(ChangeLogEntry{Operation: OpCreateDatabase}, "Created Database")
+
+		if m, ok := store.(migrator); ok {
+			err = m.initSchema()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return db, db.saveSettings()
+	default:
+		return nil, err
 	}
+}
 
-	err = p.db.Init(&IndexEntry{})
+// OpenWith opens a database backed by the given storage, bringing its schema
+// up to date. If the storage has no settings SettingsNotDefinedErr is
+// returned along with the database so it can be initialized.
+func OpenWith(store Storage) (*ParaphraseDb, error) {
+	var paraphrase ParaphraseDb
+
+	paraphrase.store = store
+
+	err := paraphrase.loadSettings()
 	if err != nil {
-		return err
+		return &paraphrase, err
 	}
 
-	err = p.db.Init(&Settings{})
-	if err != nil {
-		return err
+	if m, ok := store.(migrator); ok {
+		err = m.migrate()
+		if err != nil {
+			paraphrase.Close()
+			return nil, err
+		}
 	}
 
-	err = p.db.Init(&ChangeLogEntry{})
-	if err != nil {
-		return err
+	return &paraphrase, nil
+}
+
+func FindDbPath(directory string) string {
+
+	if path.Ext(directory) == DbExt {
+		return directory
 	}
 
-	return err
+	return path.Join(directory, DbName)
 }
 
 func (p *ParaphraseDb) Close() error {
-	return p.db.Close()
+	return p.store.Close()
 }
 
 func (p *ParaphraseDb) loadSettings() error {
-	// load settings
-	var settings Settings
-	err := p.db.One("Version", CurrentSettingsVersion, &settings)
-
-	switch err {
-	case storm.ErrNotFound:
-		return SettingsNotDefinedErr
-	case nil:
-		p.settings = settings
-
-	default:
+	settings, err := p.store.Settings()
+	if err != nil {
 		return err
 	}
 
+	p.settings = settings
 	return nil
 }
 
 func (p *ParaphraseDb) saveSettings() error {
-	p.logChange("Saved Settings")
-	return p.db.Save(&p.settings)
+	p.logChange(ChangeLogEntry{Operation: OpSaveSettings}, "Saved Settings")
+	return p.store.SaveSettings(p.settings)
+}
+
+// ReadOnly checks if the database was opened read-only, changes to it,
+// including changelog entries, can't be written.
+func (p *ParaphraseDb) ReadOnly() bool {
+	bs, ok := p.store.(*boltStorage)
+	return ok && bs.db.Bolt.IsReadOnly()
+}
+
+// SetRunHooks allows the hooks saved in the database to run when documents
+// are added. They're off by default, a database from someone else could
+// otherwise run commands as whoever adds to it.
+func (p *ParaphraseDb) SetRunHooks(run bool) {
+	p.hooksAllowed = run
 }
 
 func (p *ParaphraseDb) GetSettings() Settings {
 	return p.settings
 }
 
+// SchemaVersion gets the schema version of the underlying storage.
+func (p *ParaphraseDb) SchemaVersion() (int, error) {
+	return p.store.SchemaVersion()
+}
+
 // Write information about Paraphrase and the database to an output.
 // Output format _may change without warning_.
 func (p *ParaphraseDb) WriteStats(writer io.Writer) {
 
-	boltInfo := p.db.Bolt.Info()
 	docCount, _ := p.CountDocuments()
-	hashCount, _ := p.db.Count(&IndexEntry{})
-	boltStats := p.db.Bolt.Stats()
+	hashCount, _ := p.store.CountHashes()
+	schemaVersion, _ := p.SchemaVersion()
 
-	settings := []struct {
+	type stat struct {
 		Group   string
 		Title   string
 		Setting interface{}
-	}{
+	}
+
+	settings := []stat{
 		{"Paraphrase Settings", "", ""},
 		{"", "Version", p.settings.Version},
 		{"", "Window Size", p.settings.WindowSize},
@@ -204,16 +289,25 @@ func (p *ParaphraseDb) WriteStats(writer io.Writer) {
 		{"", "Robust Winnow?", p.settings.RobustHash},
 		{"", "Creation Date", p.settings.CreatedAt},
 		{"Database Information", "", ""},
-		{"", "Page Size", boltInfo.PageSize},
+		{"", "Schema Version", schemaVersion},
 		{"", "Number of Documents", docCount},
 		{"", "Number of Distinct Hashes", hashCount},
-		{"BoltDb", "", ""},
-		{"", "FreeAlloc", boltStats.FreeAlloc},
-		{"", "FreePageN", boltStats.FreePageN},
-		{"", "FreelistInuse", boltStats.FreelistInuse},
-		{"", "OpenTxN", boltStats.OpenTxN},
-		{"", "PendingPageN", boltStats.PendingPageN},
-		{"", "TxN", boltStats.TxN},
+	}
+
+	if bs, ok := p.store.(*boltStorage); ok {
+		boltInfo := bs.db.Bolt.Info()
+		boltStats := bs.db.Bolt.Stats()
+
+		settings = append(settings, []stat{
+			{"BoltDb", "", ""},
+			{"", "Page Size", boltInfo.PageSize},
+			{"", "FreeAlloc", boltStats.FreeAlloc},
+			{"", "FreePageN", boltStats.FreePageN},
+			{"", "FreelistInuse", boltStats.FreelistInuse},
+			{"", "OpenTxN", boltStats.OpenTxN},
+			{"", "PendingPageN", boltStats.PendingPageN},
+			{"", "TxN", boltStats.TxN},
+		}...)
 	}
 
 	w := new(tabwriter.Writer)
@@ -251,7 +345,7 @@ func (p *ParaphraseDb) AddDocuments(producer provider.DocumentProducer) (added [
 			}
 
 			log.Println("\tSaving Document")
-			doc, err := p.CreateDocument(key.Path(), key.Namespace(), body)
+			doc, err := p.CreateTaggedDocument(key.Path(), key.Namespace(), body, key.Metadata(), key.Tags())
 			if err != nil {
 				log.Printf("Error saving document %s: %s", key.Path(), err)
 				ok = false
@@ -270,15 +364,23 @@ func (p *ParaphraseDb) AddDocuments(producer provider.DocumentProducer) (added [
 	}
 
 	watch := stopwatch.Stop(start)
-	p.logChange("Added %v documents in %v ms, Had failures? %v", len(added), watch.Milliseconds(), !ok)
+	ids, namespaces := documentIds(added)
+	details := ChangeLogEntry{
+		Operation:  OpAddDocuments,
+		Documents:  ids,
+		Namespaces: namespaces,
+		Duration:   elapsed(watch),
+		Failed:     !ok,
+	}
+	p.logChange(details, "Added %v documents in %v ms, Had failures? %v", len(added), watch.Milliseconds(), !ok)
 
 	return added, ok
 }
 
-func (p *ParaphraseDb) ImportDocumentsMatching(from *ParaphraseDb, query Document) error {
+func (p *ParaphraseDb) ImportDocumentsMatching(from *ParaphraseDb, query Query) error {
 	start := stopwatch.Start()
 
-	docs, err := from.FindDocumentsLike(query)
+	docs, err := from.FindDocumentsMatching(query)
 
 	if err != nil {
 		return err
@@ -288,37 +390,56 @@ func (p *ParaphraseDb) ImportDocumentsMatching(from *ParaphraseDb, query Documen
 
 	bar := pb.StartNew(len(docs))
 	var result error
+	var imported []Document
 
 	for _, doc := range docs {
 		bar.Increment()
 
 		data, err := from.FindDocumentDataById(doc.Id)
 		if err != nil {
-			log.Printf("Error getting the data for document: %s %s: %s", doc.Id, doc.Path, err)
+			log.Printf("Error getting the data for document: %v %s: %s", doc.Id, doc.Path, err)
 			result = ImportErr
 			continue
 		}
 
-		_, locerr := p.CreateDocument(doc.Path, doc.Namespace, data.Body)
+		created, locerr := p.CreateTaggedDocument(doc.Path, doc.Namespace, data.Body, doc.Metadata, doc.Tags)
 
 		if locerr != nil {
 			log.Printf("Error saving document %s: %s", doc.Path, err)
 			result = ImportErr
+			continue
 		}
+
+		imported = append(imported, *created)
 	}
 
 	bar.FinishPrint("Finished importing")
 
 	watch := stopwatch.Stop(start)
-	p.logChange("Imported %v documents matching %v ion %v ms, err %v", len(docs), query, watch.Milliseconds(), result)
+	ids, namespaces := documentIds(imported)
+	details := ChangeLogEntry{
+		Operation:  OpImport,
+		Documents:  ids,
+		Namespaces: namespaces,
+		Duration:   elapsed(watch),
+		Failed:     result != nil,
+	}
+	p.logChange(details, "Imported %v documents matching %v ion %v ms, err %v", len(docs), query, watch.Milliseconds(), result)
 
 	return result
 }
 
 func (p *ParaphraseDb) CreateDocument(path, namespace string, body []byte) (*Document, error) {
+	return p.CreateTaggedDocument(path, namespace, body, nil, nil)
+}
+
+// CreateTaggedDocument creates a document with the given metadata and tags.
+func (p *ParaphraseDb) CreateTaggedDocument(path, namespace string, body []byte, metadata map[string]string, tags []string) (*Document, error) {
 	var err error
 
 	doc, docData := NewDocument(path, namespace, body)
+	doc.Metadata = metadata
+	doc.Tags = tags
 
 	// generate hashes
 
@@ -328,39 +449,61 @@ func (p *ParaphraseDb) CreateDocument(path, namespace string, body []byte) (*Doc
 		return nil, err
 	}
 
-	tx, err := p.db.Begin(true)
+	// the entry is written first so the document can point to it
+	details := ChangeLogEntry{
+		Operation:  OpCreateDocument,
+		Documents:  []int64{doc.Id},
+		Namespaces: []string{doc.Namespace},
+		Sha1:       doc.Sha1,
+	}
 
+	change, err := p.appendChange(details, "Created document %v", doc.Id)
 	if err != nil {
+		return nil, fmt.Errorf("Could not record the document in the changelog: %s", err)
+	}
+	doc.ChangeId = change.Id
+
+	err = p.store.SaveDocument(doc, docData)
+	if err != nil {
+		p.logChange(ChangeLogEntry{Operation: OpCreateDocument, Documents: []int64{doc.Id}, Failed: true}, "Could not save document %v: %s", doc.Id, err)
 		return nil, err
 	}
-	defer tx.Rollback()
 
-	for hash, count := range doc.Hashes {
-		err := p.storeHash(tx, hash, doc.Id, count)
-		if err != nil {
-			return nil, err
-		}
+	err = p.runHooks(doc)
+	if err != nil {
+		log.Printf("Error running hooks for document %v: %s\n", doc.Id, err)
 	}
 
-	err = tx.Save(doc)
+	return doc, nil
+}
+
+// UpdateMetadata saves the metadata and tags of an existing document,
+// everything else is left as it was.
+func (p *ParaphraseDb) UpdateMetadata(doc *Document) error {
+	stored, err := p.store.Document(doc.Id)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	err = tx.Save(docData)
+	data, err := p.store.DocumentData(doc.Id)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	err = tx.Commit()
+	stored.Metadata = doc.Metadata
+	stored.Tags = doc.Tags
 
-	p.logChange("Created document %v", doc.Id)
+	err = p.store.SaveDocument(stored, data)
+	if err != nil {
+		return err
+	}
 
-	return doc, err
+	p.logChange(ChangeLogEntry{Operation: OpUpdateMetadata, Documents: []int64{doc.Id}, Namespaces: []string{stored.Namespace}}, "Updated metadata of document %v", doc.Id)
+	return nil
 }
 
 func (p *ParaphraseDb) CountDocuments() (int, error) {
-	return p.db.Count(&Document{})
+	return p.store.CountDocuments()
 }
 
 // FindDocumentsLike finds documents like the one given.
@@ -369,55 +512,59 @@ func (p *ParaphraseDb) CountDocuments() (int, error) {
 // * Namespaces are searched like globs
 // * Paths are searched like globs
 func (p *ParaphraseDb) FindDocumentsLike(query Document) (results []Document, err error) {
-	var matchers []q.Matcher
+	matcher, err := QueryFromDocument(query)
+	if err != nil {
+		return nil, err
+	}
 
 	if query.Id != 0 {
-		matchers = append(matchers, q.Eq("Id", query.Id))
-	}
+		doc, err := p.FindDocumentById(query.Id)
+		if err != nil {
+			return nil, maskErrNotFound(err)
+		}
 
-	if query.Sha1 != "" {
-		matchers = append(matchers, q.Re("Sha1", "^"+query.Sha1+".*"))
-	}
+		if matcher.Match(doc) {
+			results = append(results, *doc)
+		}
 
-	if query.Namespace != "" {
-		matchers = append(matchers, q.Re("Namespace", GlobToRegexStr(query.Namespace)))
+		return results, nil
 	}
 
-	if query.Path != "" {
-		matchers = append(matchers, q.Re("Path", GlobToRegexStr(query.Path)))
-	}
+	return p.FindDocumentsMatching(matcher)
+}
 
-	err = p.db.Select(matchers...).Find(&results)
+// FindDocumentsMatching finds every document the query matches.
+func (p *ParaphraseDb) FindDocumentsMatching(query Query) (results []Document, err error) {
+	err = p.store.EachDocument(func(doc *Document) error {
+		if query.Match(doc) {
+			results = append(results, *doc)
+		}
+
+		return nil
+	})
 
-	return results, maskErrNotFound(err)
+	return results, err
 }
 
 func (p *ParaphraseDb) FindDocumentById(id int64) (*Document, error) {
-	var doc Document
-	err := p.db.One("Id", id, &doc)
-	return &doc, err
+	return p.store.Document(id)
 }
 
 func (p *ParaphraseDb) FindDocumentsBySha1(sha1 string) (results []Document, err error) {
 
 	if len(sha1) == sha1HexLength {
-		err = p.db.Find("Sha1", sha1, &results)
-
-	} else {
-		err = p.db.Select(q.Re("Sha1", "^"+sha1+".*")).Find(&results)
+		return p.store.DocumentsBySha1(sha1)
 	}
 
-	return results, maskErrNotFound(err)
+	return p.FindDocumentsLike(Document{Sha1: sha1})
 }
 
 func (p *ParaphraseDb) FindDocumentDataById(id int64) (*DocumentData, error) {
-	var doc DocumentData
-	err := p.db.One("Id", id, &doc)
-	return &doc, err
+	return p.store.DocumentData(id)
 }
 
 func maskErrNotFound(err error) error {
-	if err == storm.ErrNotFound {
+	if err == NotFoundErr {
 		return nil
 	}
 
@@ -0,0 +1,916 @@
+package paraphrase
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/boltdb/bolt"
+	"github.com/josephlewis42/paraphrase/paraphrase/provider"
+	"github.com/josephlewis42/paraphrase/paraphrase/snappyjson"
+)
+
+const (
+	testBodyA = `public static void main(String[] args) { System.out.println("Hello, world!"); }`
+	testBodyB = `public static void main(String[] args) { System.out.println("Goodbye, world!"); }`
+	testBodyC = `The quick brown fox jumps over the lazy dog, again and again and again.`
+)
+
+// withEachStorage runs the test against a fresh database for every storage
+// implementation.
+func withEachStorage(t *testing.T, test func(t *testing.T, db *ParaphraseDb)) {
+	mem, err := NewMemoryDb(NewDefaultSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+
+	t.Run("memory", func(t *testing.T) { test(t, mem) })
+
+	dir, err := ioutil.TempDir("", "paraphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bolt, err := Create(dir, NewDefaultSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bolt.Close()
+
+	t.Run("bolt", func(t *testing.T) { test(t, bolt) })
+}
+
+func TestCreateDocument(t *testing.T) {
+	withEachStorage(t, func(t *testing.T, db *ParaphraseDb) {
+		doc, err := db.CreateDocument("Main.java", "hw1", []byte(testBodyA))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		found, err := db.FindDocumentById(doc.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if found.Path != "Main.java" || found.Namespace != "hw1" || found.Sha1 != doc.Sha1 {
+			t.Errorf("expected %v got %v", doc, found)
+		}
+
+		data, err := db.FindDocumentDataById(doc.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(data.Body) != testBodyA {
+			t.Errorf("expected body %q got %q", testBodyA, data.Body)
+		}
+
+		if _, err := db.FindDocumentById(doc.Id + 1); err != NotFoundErr {
+			t.Errorf("expected NotFoundErr got %v", err)
+		}
+	})
+}
+
+func TestFindDocumentsLike(t *testing.T) {
+	withEachStorage(t, func(t *testing.T, db *ParaphraseDb) {
+		a, _ := db.CreateDocument("src/Main.java", "hw1", []byte(testBodyA))
+		db.CreateDocument("src/Other.java", "hw2", []byte(testBodyB))
+		db.CreateDocument("README", "hw1", []byte(testBodyC))
+
+		cases := []struct {
+			query    Document
+			expected int
+		}{
+			{Document{}, 3},
+			{Document{Namespace: "hw1"}, 2},
+			{Document{Namespace: "hw*"}, 3},
+			{Document{Path: "*.java"}, 2},
+			{Document{Path: "*.java", Namespace: "hw1"}, 1},
+			{Document{Id: a.Id}, 1},
+			{Document{Sha1: a.Sha1[:6]}, 1},
+			{Document{Namespace: "nope"}, 0},
+		}
+
+		for _, tc := range cases {
+			results, err := db.FindDocumentsLike(tc.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(results) != tc.expected {
+				t.Errorf("query %v: expected %d results got %d", tc.query, tc.expected, len(results))
+			}
+		}
+	})
+}
+
+func TestQueryFindsEveryDocumentSharingAHash(t *testing.T) {
+	withEachStorage(t, func(t *testing.T, db *ParaphraseDb) {
+		a, _ := db.CreateDocument("a", "ns", []byte(testBodyA))
+		b, _ := db.CreateDocument("b", "ns", []byte(testBodyA))
+		db.CreateDocument("c", "ns", []byte(testBodyC))
+
+		results, err := db.QueryByString(testBodyA, SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != 2 {
+			t.Fatalf("expected 2 results got %d", len(results))
+		}
+
+		found := map[int64]bool{results[0].Doc.Id: true, results[1].Doc.Id: true}
+		if !found[a.Id] || !found[b.Id] {
+			t.Errorf("expected documents %v and %v got %v", a.Id, b.Id, found)
+		}
+	})
+}
+
+func TestQueryOptions(t *testing.T) {
+	withEachStorage(t, func(t *testing.T, db *ParaphraseDb) {
+		db.CreateDocument("src/A.java", "hw1", []byte(testBodyA))
+		db.CreateDocument("test/A.java", "hw1", []byte(testBodyA))
+		db.CreateDocument("src/A.java", "hw2", []byte(testBodyA))
+		db.CreateDocument("src/B.java", "hw2", []byte(testBodyB))
+
+		paths := func(options SearchOptions) (out []string) {
+			results, err := db.QueryByString(testBodyA, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, result := range results {
+				out = append(out, result.Doc.Namespace+":"+result.Doc.Path)
+			}
+			return out
+		}
+
+		if all := paths(SearchOptions{}); len(all) != 4 {
+			t.Fatalf("expected 4 results got %v", all)
+		}
+
+		if got := paths(SearchOptions{Namespaces: []string{"hw2"}, ExcludePaths: []string{"*B.java"}}); len(got) != 1 || got[0] != "hw2:src/A.java" {
+			t.Errorf("expected only hw2:src/A.java got %v", got)
+		}
+
+		if got := paths(SearchOptions{MinScore: 0.99}); len(got) != 3 {
+			t.Errorf("expected the 3 copies above the minimum score got %v", got)
+		}
+
+		first, err := db.QueryByString(testBodyA, SearchOptions{Limit: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		next := paths(SearchOptions{Limit: 2, After: first[1].Cursor()})
+		offset := paths(SearchOptions{Limit: 2, Offset: 2})
+
+		if len(first) != 2 || len(next) != 2 || strings.Join(next, ",") != strings.Join(offset, ",") {
+			t.Errorf("expected pages of 2 with the cursor matching the offset got %v and %v", next, offset)
+		}
+
+		// * stays within a directory like the path: query term, ** doesn't
+		db.CreateDocument("src/main/A.java", "hw3", []byte(testBodyA))
+		if got := paths(SearchOptions{Namespaces: []string{"hw3"}, Paths: []string{"src/*.java"}}); len(got) != 0 {
+			t.Errorf("expected * not to match the nested path got %v", got)
+		}
+
+		if got := paths(SearchOptions{ExcludePaths: []string{"src/*.java"}}); len(got) != 2 {
+			t.Errorf("expected test/A.java and src/main/A.java got %v", got)
+		}
+
+		if got := paths(SearchOptions{Paths: []string{"**/main/*.java"}}); len(got) != 1 || got[0] != "hw3:src/main/A.java" {
+			t.Errorf("expected only hw3:src/main/A.java got %v", got)
+		}
+
+		if got := paths(SearchOptions{Namespaces: []string{"hw3"}, Paths: []string{"*.java"}}); len(got) != 1 {
+			t.Errorf("expected a file name glob to match in any directory got %v", got)
+		}
+	})
+}
+
+func TestQueryByIdExcludesRelatedDocuments(t *testing.T) {
+	withEachStorage(t, func(t *testing.T, db *ParaphraseDb) {
+		alice := map[string]string{"author": "alice"}
+		a, _ := db.CreateTaggedDocument("A.java", "hw1", []byte(testBodyA), alice, nil)
+		db.CreateTaggedDocument("A.java", "hw2", []byte(testBodyA), alice, nil)
+		db.CreateTaggedDocument("B.java", "hw2", []byte(testBodyA+" "), alice, nil)
+		bob, _ := db.CreateTaggedDocument("B.java", "hw1", []byte(testBodyA+" "), map[string]string{"author": "bob"}, nil)
+
+		results, err := db.QueryById(a.Id, SearchOptions{ExcludeCopies: true, ExcludeSameAuthor: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != 1 || results[0].Doc.Id != bob.Id {
+			t.Errorf("expected only bob's document got %v", results)
+		}
+
+		_, err = db.QueryByString(testBodyA, SearchOptions{ExcludeSelf: true})
+		if err == nil {
+			t.Errorf("expected an error leaving out related documents without a document")
+		}
+	})
+}
+
+func TestVerdictsAreKeyedByPair(t *testing.T) {
+	withEachStorage(t, func(t *testing.T, db *ParaphraseDb) {
+		db.SetVerdict(7, 3, NeedsReview, "ask about line 12")
+		db.SetVerdict(3, 7, Confirmed, "")
+
+		verdicts, err := db.Verdicts()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		verdict, ok := verdicts[PairKey(7, 3)]
+		if len(verdicts) != 1 || !ok {
+			t.Fatalf("expected one verdict for the pair got %v", verdicts)
+		}
+
+		if verdict.Verdict != Confirmed || verdict.A != 3 || verdict.B != 7 {
+			t.Errorf("expected the latest verdict got %+v", verdict)
+		}
+
+		if verdict.Notes != "ask about line 12" || len(verdict.History) != 1 || verdict.History[0].Verdict != NeedsReview {
+			t.Errorf("expected the notes and earlier verdict to be kept got %+v", verdict)
+		}
+
+		trail, err := db.AuditTrail()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(trail) != 2 || trail[0].Current || !trail[1].Current {
+			t.Errorf("expected both verdicts in the audit trail got %+v", trail)
+		}
+
+		changes, err := db.Changes(ChangeFilter{Operations: []Operation{OpVerdict}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(changes) != 2 || !strings.Contains(changes[0].Change, "needs review") || !strings.Contains(changes[0].Change, "ask about line 12") {
+			t.Errorf("expected each verdict and its notes in the changelog got %+v", changes)
+		}
+	})
+}
+
+func TestChangesRecordWhenDocumentsWereAdded(t *testing.T) {
+	withEachStorage(t, func(t *testing.T, db *ParaphraseDb) {
+		a, _ := db.CreateDocument("A.java", "hw1", []byte(testBodyA))
+		db.CreateDocument("B.java", "hw2", []byte(testBodyB))
+
+		changes, err := db.Changes(ChangeFilter{Document: a.Id, Operations: []Operation{OpCreateDocument}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(changes) != 1 || changes[0].Namespaces[0] != "hw1" || changes[0].Settings == nil {
+			t.Errorf("expected the creation of A got %+v", changes)
+		}
+
+		changes, _ = db.Changes(ChangeFilter{Since: time.Now().Add(time.Hour)})
+		if len(changes) != 0 {
+			t.Errorf("expected no changes in the future got %+v", changes)
+		}
+	})
+}
+
+func TestVerifyChangesDetectsTampering(t *testing.T) {
+	db, err := NewMemoryDb(NewDefaultSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.CreateDocument("A.java", "hw1", []byte(testBodyA))
+	db.CreateDocument("B.java", "hw1", []byte(testBodyB))
+
+	result, err := db.VerifyChanges("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Problems) != 0 || result.Documents != 2 || result.Unrecorded != 0 {
+		t.Fatalf("expected an intact changelog got %+v", result)
+	}
+
+	store := db.store.(*memoryStorage)
+	store.changes[1].User = "mallory"
+	store.changes = append(store.changes[:2], store.changes[3:]...)
+
+	result, err = db.VerifyChanges(result.Head)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the edit, the missing head and B's missing entry are caught
+	if len(result.Problems) != 3 {
+		t.Errorf("expected the edit and deletion to be found got %+v", result.Problems)
+	}
+}
+
+func TestVerifyChangesDetectsUnlinkedDocuments(t *testing.T) {
+	db, err := NewMemoryDb(NewDefaultSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	a, _ := db.CreateDocument("A.java", "hw1", []byte(testBodyA))
+	b, _ := db.CreateDocument("B.java", "hw1", []byte(testBodyB))
+
+	store := db.store.(*memoryStorage)
+	unlinked := store.docs[a.Id]
+	unlinked.ChangeId = 0
+	store.docs[a.Id] = unlinked
+
+	relinked := store.docs[b.Id]
+	relinked.ChangeId = a.ChangeId
+	store.docs[b.Id] = relinked
+
+	result, err := db.VerifyChanges("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// neither document passes as added before creations were recorded
+	if len(result.Problems) != 2 || result.Unrecorded != 0 {
+		t.Errorf("expected both documents to be reported got %+v", result)
+	}
+}
+
+func TestSyncOnlyIndexesChangedFiles(t *testing.T) {
+	withEachStorage(t, func(t *testing.T, db *ParaphraseDb) {
+		dir, err := ioutil.TempDir("", "paraphrasesync")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		write := func(name, body string) {
+			ioutil.WriteFile(filepath.Join(dir, name), []byte(body), 0644)
+		}
+
+		sync := func() *SyncResult {
+			result, err := db.Sync("repo", provider.NewTreeWalkerProducer(dir, "repo", true, len(dir)), SyncOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return result
+		}
+
+		write("A.java", testBodyA)
+		write("B.java", testBodyB)
+		sync()
+
+		write("A.java", testBodyC)
+		os.Remove(filepath.Join(dir, "B.java"))
+		result := sync()
+
+		if len(result.Updated) != 1 || len(result.Removed) != 1 || len(result.Added) != 0 {
+			t.Errorf("expected A to be updated and B removed got %+v", result)
+		}
+
+		if results, _ := db.QueryByString(testBodyB, SearchOptions{}); len(results) != 0 {
+			t.Errorf("expected searches to leave out removed B got %+v", results)
+		}
+
+		if results, _ := db.QueryByString(testBodyB, SearchOptions{IncludeRemoved: true}); len(results) == 0 || !strings.HasSuffix(results[0].Doc.Path, "B.java") {
+			t.Errorf("expected removed B when asked for got %+v", results)
+		}
+
+		write("B.java", testBodyB)
+		result = sync()
+
+		if len(result.Restored) != 1 || result.Unchanged != 1 {
+			t.Errorf("expected B to be restored and A unchanged got %+v", result)
+		}
+	})
+}
+
+func TestWatchSkipsStoredFilesAndAlertsOnMatches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "paraphrasewatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := filepath.Join(dir, "files")
+	os.Mkdir(files, 0700)
+	ioutil.WriteFile(filepath.Join(files, "A.java"), []byte(testBodyA), 0644)
+
+	db, err := Create(dir, NewDefaultSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.CreateDocument("/old.java", "2016", []byte(testBodyA))
+	db.Close()
+
+	opens := 0
+	open := func() (*ParaphraseDb, error) {
+		opens++
+		return OpenWithOptions(dir, OpenOptions{Timeout: 100 * time.Millisecond})
+	}
+
+	var alerts []WatchAlert
+	watch := func() {
+		producer := provider.NewTreeWalkerProducer(files, "2017", true, len(files))
+		options := WatchOptions{Check: true, Search: SearchOptions{MinScore: 0.5}}
+
+		err := Watch(open, producer, options, func(alert WatchAlert) {
+			alerts = append(alerts, alert)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	watch()
+	watch()
+
+	// the database is closed between batches
+	db, err = OpenWithOptions(dir, OpenOptions{ReadOnly: true, Timeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if count, _ := db.CountDocuments(); count != 2 || opens != 2 {
+		t.Errorf("expected the file to be added once in two batches got %v documents in %v", count, opens)
+	}
+
+	if len(alerts) != 1 || len(alerts[0].Matches) != 1 || alerts[0].Matches[0].Doc.Namespace != "2016" {
+		t.Errorf("expected one alert matching the old document got %+v", alerts)
+	}
+}
+
+func TestHooksGetMatchingDocuments(t *testing.T) {
+	withEachStorage(t, func(t *testing.T, db *ParaphraseDb) {
+		dir, err := ioutil.TempDir("", "paraphrasehooks")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		events := filepath.Join(dir, "events.jsonl")
+		db.AddHook(&Hook{Kind: HookJsonl, Target: events, MinScore: 0.9, Namespaces: []string{"2017*"}})
+		db.SetRunHooks(true)
+
+		db.CreateDocument("a", "2016", []byte(testBodyA))
+		db.CreateDocument("b", "2016", []byte(testBodyA))
+		db.CreateDocument("c", "2017", []byte(testBodyB))
+		copied, _ := db.CreateDocument("d", "2017", []byte(testBodyA))
+
+		body, err := ioutil.ReadFile(events)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("expected an event for each copy of d got %v", lines)
+		}
+
+		var event HookEvent
+		json.Unmarshal([]byte(lines[0]), &event)
+		if event.Document.Id != copied.Id || event.Score < 0.9 || event.Match.Namespace != "2016" {
+			t.Errorf("expected d to match a copy in 2016 got %+v", event)
+		}
+
+		db.SetRunHooks(false)
+		db.CreateDocument("e", "2017", []byte(testBodyA))
+
+		after, _ := ioutil.ReadFile(events)
+		if len(after) != len(body) {
+			t.Errorf("expected no events once hooks weren't allowed got %s", after[len(body):])
+		}
+	})
+}
+
+func TestHooksSkipTheSameFileAndRemovedDocuments(t *testing.T) {
+	withEachStorage(t, func(t *testing.T, db *ParaphraseDb) {
+		dir, err := ioutil.TempDir("", "paraphrasehooks")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		events := filepath.Join(dir, "events.jsonl")
+		db.AddHook(&Hook{Kind: HookJsonl, Target: events, MinScore: 0.9})
+
+		removed, _ := db.CreateDocument("a", "2016", []byte(testBodyA))
+		removed.Tags = append(removed.Tags, RemovedTag)
+		if err := db.UpdateMetadata(removed); err != nil {
+			t.Fatal(err)
+		}
+
+		db.CreateDocument("b", "2017", []byte(testBodyA))
+		db.SetRunHooks(true)
+		db.CreateDocument("b", "2017", []byte(testBodyA))
+
+		if body, err := ioutil.ReadFile(events); err == nil {
+			t.Errorf("expected no events for a resubmitted file or a removed one got %s", body)
+		}
+	})
+}
+
+func TestOpenNamesTheLockHolder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "paraphraselock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	created, err := Create(dir, NewDefaultSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	created.Close()
+
+	readOnly := OpenOptions{ReadOnly: true, Timeout: 100 * time.Millisecond}
+
+	reader, err := OpenWithOptions(dir, readOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := OpenWithOptions(dir, readOnly)
+	if err != nil {
+		t.Fatalf("expected readers to share the database got %v", err)
+	}
+	other.Close()
+	reader.Close()
+
+	writer, err := OpenWithOptions(dir, OpenOptions{Command: "add"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	_, err = OpenWithOptions(dir, readOnly)
+	locked, ok := err.(*DatabaseLockedError)
+	if !ok || locked.Holder.Pid != os.Getpid() || locked.Holder.Command != "add" {
+		t.Errorf("expected the database to be locked by add got %v", err)
+	}
+}
+
+func TestOpenFollowsAReplacedDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "paraphraselock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	replacement := filepath.Join(dir, "replacement")
+	os.Mkdir(replacement, 0700)
+
+	for _, d := range []string{dir, replacement} {
+		created, err := Create(d, NewDefaultSettings())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d == replacement {
+			created.CreateDocument("a", "ns", []byte(testBodyA))
+		}
+		created.Close()
+	}
+
+	writer, err := OpenWithOptions(dir, OpenOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opened := make(chan *ParaphraseDb)
+	go func() {
+		reader, err := OpenWithOptions(dir, OpenOptions{ReadOnly: true, Timeout: 5 * time.Second})
+		if err != nil {
+			t.Error(err)
+		}
+		opened <- reader
+	}()
+
+	// replace the database while the reader waits on its lock like Compact
+	time.Sleep(100 * time.Millisecond)
+	os.Rename(FindDbPath(replacement), FindDbPath(dir))
+	writer.Close()
+
+	reader := <-opened
+	if reader == nil {
+		return
+	}
+	defer reader.Close()
+
+	if count, _ := reader.CountDocuments(); count != 1 {
+		t.Errorf("expected the reader to open the replacement got %d documents", count)
+	}
+}
+
+func TestBackupAndRestoreKeepTheDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "paraphrasebackup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Create(dir, NewDefaultSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.CreateDocument("a", "ns", []byte(testBodyA))
+
+	if _, err := db.Backup(FindDbPath(dir), false, false); err == nil {
+		t.Error("expected backing up over the database to fail")
+	}
+
+	backup := filepath.Join(dir, "backup.ppdb")
+	sum, err := db.Backup(backup, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.LogBackup(backup, sum, true); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	if err := Restore(dir, backup); err != nil {
+		t.Fatal(err)
+	}
+
+	kept, _ := filepath.Glob(FindDbPath(dir) + ".pre-restore-*.bak")
+	if len(kept) != 1 {
+		t.Errorf("expected the replaced database to be kept got %v", kept)
+	}
+
+	restored, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+
+	if count, _ := restored.CountDocuments(); count != 1 {
+		t.Errorf("expected the restored database to have 1 document got %v", count)
+	}
+}
+
+func TestDeleteDocument(t *testing.T) {
+	withEachStorage(t, func(t *testing.T, db *ParaphraseDb) {
+		a, _ := db.CreateDocument("a", "ns", []byte(testBodyA))
+
+		err := db.store.DeleteDocument(a.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for hash := range a.Hashes {
+			entries, err := db.store.Postings(hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(entries) != 0 {
+				t.Errorf("expected no postings for %v got %v", hash, entries)
+			}
+		}
+
+		problems, err := db.Check(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(problems) != 0 {
+			t.Errorf("expected no problems got %v", problems)
+		}
+	})
+}
+
+func TestCheckDocument(t *testing.T) {
+	withEachStorage(t, func(t *testing.T, db *ParaphraseDb) {
+		db.CreateDocument("Main.java", "protected", []byte(testBodyA))
+		db.CreateDocument("README", "public", []byte(testBodyC))
+
+		body := []byte("// header\n\n" + testBodyA + "\n")
+
+		findings, err := db.CheckDocument("New.java", body, []string{"prot*"}, 0.5)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(findings) != 1 || findings[0].Match.Path != "Main.java" {
+			t.Fatalf("expected one finding for Main.java got %v", findings)
+		}
+
+		if lines := findings[0].Lines(); lines != "3" {
+			t.Errorf("expected the match on line 3 got %q", lines)
+		}
+
+		findings, err = db.CheckDocument("New.java", body, []string{"public"}, 0.5)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(findings) != 0 {
+			t.Errorf("expected no findings outside the namespace got %v", findings)
+		}
+	})
+}
+
+func TestCompareGroupsAddsUpSplitCopies(t *testing.T) {
+	withEachStorage(t, func(t *testing.T, db *ParaphraseDb) {
+		alice := map[string]string{"author": "alice"}
+		bob := map[string]string{"author": "bob"}
+
+		db.CreateTaggedDocument("alice/Main.java", "hw", []byte(testBodyA+testBodyC), alice, nil)
+		db.CreateTaggedDocument("bob/A.java", "hw", []byte(testBodyA), bob, nil)
+		db.CreateTaggedDocument("bob/B.java", "hw", []byte(testBodyC), bob, nil)
+		db.CreateDocument("nobody.txt", "hw", []byte(testBodyB))
+
+		grouper, err := ParseGrouper("author")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		matrix, err := db.CompareGroups(MatchAll, grouper, GroupOptions{TopFiles: 5})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(matrix.Groups) != 2 {
+			t.Fatalf("expected groups alice and bob got %v", matrix.Groups)
+		}
+
+		pair := matrix.Get(1, 0)
+		if pair == nil || pair.A != "alice" || pair.B != "bob" {
+			t.Fatalf("expected alice and bob to share fingerprints got %v", pair)
+		}
+
+		if len(pair.Files) != 2 {
+			t.Errorf("expected both of bob's files to contribute got %v", pair.Files)
+		}
+
+		for _, file := range pair.Files {
+			if pair.Shared <= file.Shared {
+				t.Errorf("expected the group to share more than %v alone", file.B.Path)
+			}
+		}
+	})
+}
+
+func TestCompareGroupsSkipsCommonFingerprints(t *testing.T) {
+	withEachStorage(t, func(t *testing.T, db *ParaphraseDb) {
+		for i := 0; i < minCommonHolders+2; i++ {
+			author := map[string]string{"author": fmt.Sprint(i)}
+			db.CreateTaggedDocument(fmt.Sprintf("%d/Main.java", i), "hw", []byte(testBodyA), author, nil)
+		}
+
+		grouper, _ := ParseGrouper("author")
+
+		matrix, err := db.CompareGroups(MatchAll, grouper, GroupOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(matrix.Pairs) != 0 {
+			t.Errorf("expected the template everyone has to be skipped got %d pairs", len(matrix.Pairs))
+		}
+
+		matrix, err = db.CompareGroups(MatchAll, grouper, GroupOptions{MaxCommon: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if groups := minCommonHolders + 2; len(matrix.Pairs) != groups*(groups-1)/2 {
+			t.Errorf("expected every pair of authors to share the template got %d pairs", len(matrix.Pairs))
+		}
+	})
+}
+
+func TestSnippetsShowContextAroundMatches(t *testing.T) {
+	withEachStorage(t, func(t *testing.T, db *ParaphraseDb) {
+		query, err := db.WinnowData([]byte(testBodyA))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body := []byte("1\n2\n3\n4\n" + testBodyA + "\n6\n7\n8\n")
+
+		snippets := db.Snippets(body, query, 1, DefaultSnippetLimit)
+		if len(snippets) != 1 {
+			t.Fatalf("expected one snippet got %v", snippets)
+		}
+
+		if snippets[0].StartLine != 4 || snippets[0].EndLine != 6 {
+			t.Errorf("expected lines 4-6 got %d-%d", snippets[0].StartLine, snippets[0].EndLine)
+		}
+
+		marked, err := snippets[0].Marked(MarkupHtml)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.HasPrefix(marked, "4\n<mark>") || !strings.HasSuffix(marked, "</mark>\n6") {
+			t.Errorf("expected the middle line to be marked got %q", marked)
+		}
+	})
+}
+
+func TestOpenMigratesTheFirstSchema(t *testing.T) {
+	dir, err := ioutil.TempDir("", "paraphrasemigrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// documents are hashed in memory then written the way the first schema
+	// stored them: no sizes, headers, postings or schema version and an
+	// index keyed by hash alone
+	mem, err := NewMemoryDb(NewDefaultSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := mem.CreateDocument("A.java", "hw", []byte(testBodyA))
+	b, _ := mem.CreateDocument("B.java", "hw", []byte(testBodyB))
+
+	type IndexEntry struct {
+		Hash      uint64 `storm:"id,index"`
+		Doc       int64
+		Frequency int16
+	}
+
+	legacy, err := storm.Open(FindDbPath(dir), storm.Codec(snappyjson.MsgpackCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	settings := mem.GetSettings()
+	legacy.Save(&settings)
+	legacy.Save(&ChangeLogEntry{User: "jdoe", Date: time.Now(), Change: "Created Database"})
+
+	for _, doc := range []*Document{a, b} {
+		data, _ := mem.FindDocumentDataById(doc.Id)
+
+		old := *doc
+		old.Size = 0
+		old.ChangeId = 0
+		legacy.Save(&old)
+		legacy.Save(data)
+
+		for hash, count := range doc.Hashes {
+			legacy.Save(&IndexEntry{hash, doc.Id, count})
+		}
+	}
+	legacy.Close()
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if version, _ := db.SchemaVersion(); version != LatestSchemaVersion() {
+		t.Errorf("expected schema version %d got %d", LatestSchemaVersion(), version)
+	}
+
+	// the old index only kept the last document with each hash
+	results, err := db.QueryByString(testBodyA, SearchOptions{})
+	if err != nil || len(results) != 2 {
+		t.Errorf("expected both documents sharing hashes to be found got %v %v", results, err)
+	}
+
+	doc, err := db.FindDocumentById(a.Id)
+	if err != nil || doc.Size != len(testBodyA) {
+		t.Errorf("expected the size to be filled in got %+v %v", doc, err)
+	}
+
+	header, err := db.store.DocumentHeader(b.Id)
+	if err != nil || header.Path != "B.java" || header.Namespace != "hw" {
+		t.Errorf("expected a header for B got %+v %v", header, err)
+	}
+
+	verification, err := db.VerifyChanges("")
+	if err != nil || len(verification.Problems) != 0 || verification.Entries < 2 {
+		t.Errorf("expected the old and migration entries to be chained got %+v %v", verification, err)
+	}
+
+	db.store.(*boltStorage).db.Bolt.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(legacyIndexBucket)) != nil {
+			t.Error("expected the old index to be removed")
+		}
+		return nil
+	})
+
+	backups, _ := filepath.Glob(FindDbPath(dir) + ".v1-*.bak")
+	if len(backups) != 1 {
+		t.Errorf("expected a backup of the first schema got %v", backups)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/josephlewis42/paraphrase/paraphrase/linalg"
+	"github.com/josephlewis42/paraphrase/paraphrase/provider"
 )
 
 const (
@@ -44,7 +45,49 @@ type Document struct {
 	Namespace string
 	IndexDate time.Time
 	Sha1      string `storm:"index"`
-	Hashes    TermCountVector
+	Size      int
+	// Metadata holds arbitrary key/value pairs like a student id or email.
+	Metadata map[string]string
+	Tags     []string
+	Hashes   TermCountVector
+	// ChangeId is the changelog entry recording the document's creation, 0
+	// for documents added before it was recorded.
+	ChangeId int
+}
+
+// DocumentHeader is the part of a document searches filter on, it's stored
+// apart from the document so candidates can be ruled out without loading
+// their hashes.
+type DocumentHeader struct {
+	Id        int64
+	Namespace string
+	Path      string
+	Sha1      string
+	Author    string
+	// Removed is set for documents tagged with RemovedTag.
+	Removed bool
+}
+
+// Header gets the document's header.
+func (d *Document) Header() *DocumentHeader {
+	return &DocumentHeader{d.Id, d.Namespace, d.Path, d.Sha1, d.Author(), d.HasTag(RemovedTag)}
+}
+
+// Author gets the owner of the document from its metadata, it's blank if
+// there is none.
+func (d *Document) Author() string {
+	return d.Metadata[provider.AuthorKey]
+}
+
+// HasTag checks if the document has the given tag.
+func (d *Document) HasTag(tag string) bool {
+	for _, t := range d.Tags {
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
 }
 
 func (d *Document) NormalizedTermFrequency() linalg.IFVector {
@@ -80,6 +123,7 @@ func NewDocument(path, namespace string, body []byte) (*Document, *DocumentData)
 	doc.Path = path
 	doc.Namespace = namespace
 	doc.Sha1 = hex.EncodeToString(docHash.Sum(nil))
+	doc.Size = len(body)
 	doc.IndexDate = time.Now()
 
 	return &doc, NewDocumentData(&doc, body)
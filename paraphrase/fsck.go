@@ -0,0 +1,236 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+package paraphrase
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// ProblemKind is a type of inconsistency found by Check.
+type ProblemKind string
+
+const (
+	MissingBody  ProblemKind = "missing body"  // a Document without DocumentData
+	OrphanBody   ProblemKind = "orphan body"   // DocumentData without a Document
+	OrphanIndex  ProblemKind = "orphan index"  // an IndexEntry for a missing Document
+	Sha1Mismatch ProblemKind = "sha1 mismatch" // Document.Sha1 doesn't match the body
+	StaleHeader  ProblemKind = "stale header"  // a DocumentHeader missing or out of date
+)
+
+const (
+	problemHeader = "Problem\tID\tDetails"
+	problemFormat = "%v\t%v\t%v\n"
+)
+
+// Problem is an inconsistency found in the database.
+type Problem struct {
+	Kind     ProblemKind
+	Id       int64
+	Hash     uint64
+	Detail   string
+	Repaired bool
+}
+
+// Check walks every bucket in the database looking for inconsistencies left
+// behind by partial failures. If repair is set, bodies are re-winnowed to
+// rebuild their documents and orphaned records are dropped.
+func (p *ParaphraseDb) Check(repair bool) (problems []Problem, err error) {
+	docs := make(map[int64]string)
+	headers := make(map[int64]*DocumentHeader)
+
+	err = p.store.EachDocument(func(doc *Document) error {
+		docs[doc.Id] = doc.Sha1
+		headers[doc.Id] = doc.Header()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bodies := make(map[int64]bool)
+
+	err = p.store.EachDocumentData(func(data *DocumentData) error {
+		bodies[data.Id] = true
+
+		sha, ok := docs[data.Id]
+		switch {
+		case !ok:
+			problems = append(problems, Problem{Kind: OrphanBody, Id: data.Id, Detail: data.Path})
+		case sha != data.BodySha1():
+			detail := fmt.Sprintf("document has %v, body has %v", sha, data.BodySha1())
+			problems = append(problems, Problem{Kind: Sha1Mismatch, Id: data.Id, Detail: detail})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for id := range docs {
+		if !bodies[id] {
+			problems = append(problems, Problem{Kind: MissingBody, Id: id})
+		}
+	}
+
+	for id, expected := range headers {
+		// documents without bodies are deleted instead
+		if !bodies[id] {
+			continue
+		}
+
+		header, err := p.store.DocumentHeader(id)
+		if err != nil && err != NotFoundErr {
+			return nil, err
+		}
+
+		if *header != *expected {
+			problems = append(problems, Problem{Kind: StaleHeader, Id: id, Detail: expected.Path})
+		}
+	}
+
+	err = p.store.EachPosting(func(entry *IndexEntry) error {
+		if _, ok := docs[entry.Doc]; !ok {
+			detail := fmt.Sprintf("hash %v", entry.Hash)
+			problems = append(problems, Problem{Kind: OrphanIndex, Id: entry.Doc, Hash: entry.Hash, Detail: detail})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !repair || len(problems) == 0 {
+		return problems, nil
+	}
+
+	repaired := 0
+	for i := range problems {
+		err := p.repair(&problems[i])
+		if err != nil {
+			problems[i].Detail = fmt.Sprintf("%s (repair failed: %s)", problems[i].Detail, err)
+			continue
+		}
+
+		problems[i].Repaired = true
+		repaired++
+	}
+
+	p.logChange(ChangeLogEntry{Operation: OpFsck, Failed: repaired < len(problems)}, "Checked database, found %v problems and repaired %v", len(problems), repaired)
+
+	return problems, nil
+}
+
+func (p *ParaphraseDb) repair(problem *Problem) error {
+	switch problem.Kind {
+	case OrphanBody, Sha1Mismatch:
+		data, err := p.FindDocumentDataById(problem.Id)
+		if err != nil {
+			return err
+		}
+
+		return p.rewinnowDocument(data)
+
+	case MissingBody:
+		return p.store.DeleteDocument(problem.Id)
+
+	case StaleHeader:
+		doc, err := p.FindDocumentById(problem.Id)
+		if err != nil {
+			return err
+		}
+
+		data, err := p.FindDocumentDataById(problem.Id)
+		if err != nil {
+			return err
+		}
+
+		return p.store.SaveDocument(doc, data)
+
+	case OrphanIndex:
+		// The document may have been rebuilt from an orphaned body since the
+		// check.
+		if _, err := p.FindDocumentById(problem.Id); err == nil {
+			return nil
+		}
+
+		return p.store.DeletePosting(IndexEntry{Hash: problem.Hash, Doc: problem.Id})
+	}
+
+	return nil
+}
+
+// rewinnowDocument rebuilds a Document and its index entries from its body.
+// Bodies that don't match the SHA1 recorded in the changelog when their
+// document was added are refused, rebuilding them would hide the change from
+// VerifyChanges.
+func (p *ParaphraseDb) rewinnowDocument(data *DocumentData) error {
+	var err error
+
+	doc, _ := NewDocument(data.Path, data.Namespace, data.Body)
+	doc.Id = data.Id
+	doc.IndexDate = data.IndexDate
+
+	// metadata only lives on the document so keep it if there is one
+	old, err := p.store.Document(data.Id)
+	switch {
+	case err == nil:
+		doc.Metadata = old.Metadata
+		doc.Tags = old.Tags
+		doc.ChangeId = old.ChangeId
+	case err == NotFoundErr:
+		old = nil
+	default:
+		return err
+	}
+
+	creation, err := p.creationEntry(data.Id)
+	if err != nil {
+		return err
+	}
+
+	if creation != nil {
+		if creation.Sha1 != doc.Sha1 {
+			return fmt.Errorf("body doesn't match the SHA1 %v recorded by entry %d", creation.Sha1, creation.Id)
+		}
+
+		doc.ChangeId = creation.Id
+	}
+
+	doc.Hashes, err = p.WinnowData(data.Body)
+	if err != nil {
+		return err
+	}
+
+	err = p.store.SaveDocument(doc, data)
+	if err != nil {
+		return err
+	}
+
+	if old != nil && old.Sha1 != doc.Sha1 {
+		p.logChange(ChangeLogEntry{Operation: OpFsck, Documents: []int64{doc.Id}, Namespaces: []string{doc.Namespace}}, "Changed the SHA1 of document %d from %v to %v", doc.Id, old.Sha1, doc.Sha1)
+	}
+
+	return nil
+}
+
+// WriteProblems writes the problems found by Check in a fashion suitable for
+// displaying on-screen.
+func WriteProblems(w io.Writer, problems []Problem) {
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+
+	fmt.Fprintln(tw, problemHeader)
+	for _, problem := range problems {
+		detail := problem.Detail
+		if problem.Repaired {
+			detail += " (repaired)"
+		}
+
+		fmt.Fprintf(tw, problemFormat, problem.Kind, problem.Id, detail)
+	}
+
+	tw.Flush()
+}
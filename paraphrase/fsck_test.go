@@ -0,0 +1,168 @@
+package paraphrase
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// damage changes the storage behind db directly, the way a partial failure
+// would leave it, with whichever function fits the storage.
+func damage(t *testing.T, db *ParaphraseDb, mem func(m *memoryStorage), onDisk func(s *boltStorage, tx *bolt.Tx) error) {
+	switch s := db.store.(type) {
+	case *memoryStorage:
+		s.lock.Lock()
+		mem(s)
+		s.lock.Unlock()
+	case *boltStorage:
+		err := s.db.Bolt.Update(func(tx *bolt.Tx) error { return onDisk(s, tx) })
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCheckFindsAndRepairsProblems(t *testing.T) {
+	withEachStorage(t, func(t *testing.T, db *ParaphraseDb) {
+		metadata := map[string]string{"author": "jdoe"}
+		tags := []string{"late"}
+
+		orphaned, _ := db.CreateDocument("Orphaned.java", "fsck", []byte(testBodyA))
+		missing, _ := db.CreateDocument("Missing.java", "fsck", []byte(testBodyB))
+		rehashed, _ := db.CreateTaggedDocument("Rehashed.java", "fsck", []byte(testBodyC), metadata, tags)
+		tampered, _ := db.CreateDocument("Tampered.java", "fsck", []byte(testBodyA+" "))
+		stale, _ := db.CreateDocument("Stale.java", "fsck", []byte(testBodyB+" "))
+
+		const orphanHash, ghost = 42, 999
+
+		wrongSha := *rehashed
+		wrongSha.Sha1 = "0000000000000000000000000000000000000000"
+
+		damage(t, db, func(m *memoryStorage) {
+			delete(m.docs, orphaned.Id)
+			delete(m.data, missing.Id)
+			m.docs[rehashed.Id] = wrongSha
+
+			data := m.data[tampered.Id]
+			data.Body = []byte(testBodyC + " ")
+			m.data[tampered.Id] = data
+
+			m.postings[orphanHash] = map[int64]int16{ghost: 1}
+		}, func(s *boltStorage, tx *bolt.Tx) error {
+			node := s.db.WithTransaction(tx)
+
+			var data DocumentData
+			if err := node.One("Id", missing.Id, &data); err != nil {
+				return err
+			}
+
+			var body DocumentData
+			if err := node.One("Id", tampered.Id, &body); err != nil {
+				return err
+			}
+			body.Body = []byte(testBodyC + " ")
+
+			header := stale.Header()
+			header.Path = "Old.java"
+
+			for _, err := range []error{
+				node.DeleteStruct(orphaned),
+				node.DeleteStruct(&data),
+				node.Save(&wrongSha),
+				putHeader(tx.Bucket([]byte(HeadersBucket)), wrongSha.Header()),
+				node.Save(&body),
+				putHeader(tx.Bucket([]byte(HeadersBucket)), header),
+				putPosting(tx.Bucket([]byte(PostingsBucket)), IndexEntry{orphanHash, ghost, 1}),
+			} {
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+
+		expected := map[ProblemKind]int64{
+			OrphanBody:   orphaned.Id,
+			MissingBody:  missing.Id,
+			Sha1Mismatch: rehashed.Id,
+			OrphanIndex:  ghost,
+		}
+
+		// headers are only stored apart from documents on disk
+		if _, ok := db.store.(*boltStorage); ok {
+			expected[StaleHeader] = stale.Id
+		}
+
+		found := func(problems []Problem, kind ProblemKind, id int64) *Problem {
+			for i, problem := range problems {
+				if problem.Kind == kind && problem.Id == id {
+					return &problems[i]
+				}
+			}
+
+			return nil
+		}
+
+		problems, err := db.Check(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for kind, id := range expected {
+			if problem := found(problems, kind, id); problem == nil || problem.Repaired {
+				t.Errorf("expected an unrepaired %v for %d got %+v", kind, id, problems)
+			}
+		}
+
+		problems, err = db.Check(true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for kind, id := range expected {
+			if problem := found(problems, kind, id); problem == nil || !problem.Repaired {
+				t.Errorf("expected %v for %d to be repaired got %+v", kind, id, problems)
+			}
+		}
+
+		// a body changed since the changelog recorded it is left for
+		// "changelog verify" to report
+		if problem := found(problems, Sha1Mismatch, tampered.Id); problem == nil || problem.Repaired {
+			t.Errorf("expected the tampered body not to be repaired got %+v", problems)
+		}
+
+		problems, err = db.Check(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(problems) != 1 || found(problems, Sha1Mismatch, tampered.Id) == nil {
+			t.Errorf("expected only the tampered body to be left got %+v", problems)
+		}
+
+		doc, err := db.FindDocumentById(rehashed.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if doc.Sha1 != rehashed.Sha1 || doc.ChangeId != rehashed.ChangeId ||
+			!reflect.DeepEqual(doc.Metadata, metadata) || !reflect.DeepEqual(doc.Tags, tags) {
+			t.Errorf("expected the rebuilt document to keep its SHA1, change, metadata and tags got %+v", doc)
+		}
+
+		doc, err = db.FindDocumentById(orphaned.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if doc.ChangeId != orphaned.ChangeId || doc.Path != orphaned.Path {
+			t.Errorf("expected the orphaned body's document to be rebuilt got %+v", doc)
+		}
+
+		if _, err := db.FindDocumentById(missing.Id); err == nil {
+			t.Error("expected the document missing its body to be removed")
+		}
+	})
+}
@@ -0,0 +1,431 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+package paraphrase
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"io"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bradfitz/slice"
+	"github.com/josephlewis42/paraphrase/paraphrase/provider"
+)
+
+const (
+	groupPairHeader = "Shared\tScore\tA\tB"
+	groupPairFormat = "%d\t%.3f\t%v\t%v\n"
+	filePairFormat  = "\t\t  %d\t%v ~ %v%v\n"
+
+	// DefaultMaxCommon is the fraction of documents a fingerprint can be in
+	// before CompareGroups skips it as boilerplate.
+	DefaultMaxCommon = 0.5
+	// minCommonHolders is the number of documents a fingerprint can always
+	// be in, small collections would lose everything they share otherwise.
+	minCommonHolders = 10
+)
+
+// A Grouper gets the group a document belongs to, like its author. Documents
+// with a blank group are left out.
+type Grouper func(doc *Document) string
+
+// ParseGrouper builds a grouper from a description:
+//
+//	namespace    the document's namespace
+//	author       the document's author
+//	meta:KEY     the metadata value for KEY
+//	path:REGEX   the first capture group of REGEX matched against the path
+func ParseGrouper(spec string) (Grouper, error) {
+	kind, arg := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		kind, arg = spec[:i], spec[i+1:]
+	}
+
+	switch kind {
+	case "namespace", "ns":
+		return func(doc *Document) string { return doc.Namespace }, nil
+
+	case "author":
+		return func(doc *Document) string { return doc.Metadata[provider.AuthorKey] }, nil
+
+	case "meta":
+		if arg == "" {
+			return nil, fmt.Errorf("Group by metadata like meta:KEY")
+		}
+		return func(doc *Document) string { return doc.Metadata[arg] }, nil
+
+	case "path":
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		if re.NumSubexp() < 1 {
+			return nil, fmt.Errorf("The path pattern %q needs a capture group for the group name", arg)
+		}
+
+		return func(doc *Document) string {
+			match := re.FindStringSubmatch(doc.Path)
+			if match == nil {
+				return ""
+			}
+			return match[1]
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("Unknown grouping %q, expected namespace, author, meta:KEY or path:REGEX", spec)
+	}
+}
+
+// FilePair is the number of fingerprints two documents share.
+type FilePair struct {
+	A      *Document
+	B      *Document
+	Shared int
+	// Verdict is the verdict on the pair, nil if it hasn't been reviewed.
+	Verdict *PairVerdict
+}
+
+// label describes the verdict on the pair for listing after its paths.
+func (f *FilePair) label() string {
+	if f.Verdict == nil {
+		return ""
+	}
+
+	return fmt.Sprintf(" [%s]", f.Verdict.Verdict)
+}
+
+// GroupOptions controls how groups are compared.
+type GroupOptions struct {
+	// TopFiles is the number of contributing document pairs kept for each
+	// pair of groups, -1 keeps all of them.
+	TopFiles int
+	// HideDismissed leaves dismissed document pairs out of the contributing
+	// files. They still count towards the groups' shared fingerprints.
+	HideDismissed bool
+	// MaxCommon skips fingerprints in more than this fraction of the
+	// documents, every pair of their holders would be compared otherwise.
+	// DefaultMaxCommon if it's not positive, 1 keeps all of them.
+	MaxCommon float64
+}
+
+// GroupPair is the overlap between two groups of documents.
+type GroupPair struct {
+	A string
+	B string
+	// Shared is the number of distinct fingerprints found in both groups so
+	// copying split across several files still adds up.
+	Shared int
+	// Score is Shared over the fingerprints in the smaller group.
+	Score float64
+	// Files are the document pairs contributing the most, best first.
+	Files []FilePair
+}
+
+// GroupMatrix holds the overlap between every pair of groups.
+type GroupMatrix struct {
+	Groups []string
+	// Sizes are the number of distinct fingerprints in each group.
+	Sizes []int
+	// Pairs are indexed by the positions of the groups, A before B.
+	Pairs map[[2]int]*GroupPair
+}
+
+// CompareGroups groups the documents matching the query and counts the
+// fingerprints shared between every pair of groups.
+func (p *ParaphraseDb) CompareGroups(query Query, group Grouper, options GroupOptions) (*GroupMatrix, error) {
+	var docs []Document
+	var names []string
+
+	groupIndex := make(map[string]int)
+	var groups []string
+
+	err := p.store.EachDocument(func(doc *Document) error {
+		if !query.Match(doc) {
+			return nil
+		}
+
+		name := group(doc)
+		if name == "" {
+			return nil
+		}
+
+		if _, ok := groupIndex[name]; !ok {
+			groupIndex[name] = 0
+			groups = append(groups, name)
+		}
+
+		docs = append(docs, *doc)
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slice.Sort(groups, func(i, j int) bool { return groups[i] < groups[j] })
+	for i, name := range groups {
+		groupIndex[name] = i
+	}
+
+	docGroups := make([]int, len(docs))
+	for i, name := range names {
+		docGroups[i] = groupIndex[name]
+	}
+
+	// which documents have each hash
+	holders := make(map[uint64][]int)
+	for i, doc := range docs {
+		for hash := range doc.Hashes {
+			holders[hash] = append(holders[hash], i)
+		}
+	}
+
+	matrix := GroupMatrix{
+		Groups: groups,
+		Sizes:  make([]int, len(groups)),
+		Pairs:  make(map[[2]int]*GroupPair),
+	}
+
+	fileShared := make(map[[2]int]int)
+
+	maxCommon := options.MaxCommon
+	if maxCommon <= 0 {
+		maxCommon = DefaultMaxCommon
+	}
+	maxHolders := int(maxCommon * float64(len(docs)))
+	if maxHolders < minCommonHolders {
+		maxHolders = minCommonHolders
+	}
+
+	for _, docIdxs := range holders {
+		if len(docIdxs) > maxHolders {
+			continue
+		}
+
+		inGroup := make(map[int]bool)
+		for _, d := range docIdxs {
+			inGroup[docGroups[d]] = true
+		}
+
+		for g := range inGroup {
+			matrix.Sizes[g]++
+		}
+
+		for x, a := range docIdxs {
+			for _, b := range docIdxs[x+1:] {
+				if docGroups[a] != docGroups[b] {
+					fileShared[orderedPair(a, b, docGroups)]++
+				}
+			}
+		}
+
+		for ga := range inGroup {
+			for gb := range inGroup {
+				if ga < gb {
+					matrix.pair(ga, gb).Shared++
+				}
+			}
+		}
+	}
+
+	verdicts, err := p.Verdicts()
+	if err != nil {
+		return nil, err
+	}
+
+	for key, shared := range fileShared {
+		a, b := key[0], key[1]
+
+		verdict := verdicts[PairKey(docs[a].Id, docs[b].Id)]
+		if options.HideDismissed && verdict != nil && verdict.Verdict == Dismissed {
+			continue
+		}
+
+		pair := matrix.pair(docGroups[a], docGroups[b])
+		pair.Files = append(pair.Files, FilePair{&docs[a], &docs[b], shared, verdict})
+	}
+
+	for key, pair := range matrix.Pairs {
+		smaller := min(matrix.Sizes[key[0]], matrix.Sizes[key[1]])
+		if smaller > 0 {
+			pair.Score = float64(pair.Shared) / float64(smaller)
+		}
+
+		slice.Sort(pair.Files, func(i, j int) bool {
+			return pair.Files[i].Shared > pair.Files[j].Shared
+		})
+
+		if options.TopFiles >= 0 && len(pair.Files) > options.TopFiles {
+			pair.Files = pair.Files[:options.TopFiles]
+		}
+	}
+
+	return &matrix, nil
+}
+
+// orderedPair orders two documents by their groups so the first belongs to
+// the group listed first.
+func orderedPair(a, b int, docGroups []int) [2]int {
+	if docGroups[a] > docGroups[b] {
+		return [2]int{b, a}
+	}
+	return [2]int{a, b}
+}
+
+func (m *GroupMatrix) pair(a, b int) *GroupPair {
+	key := [2]int{a, b}
+
+	pair, ok := m.Pairs[key]
+	if !ok {
+		pair = &GroupPair{A: m.Groups[a], B: m.Groups[b]}
+		m.Pairs[key] = pair
+	}
+
+	return pair
+}
+
+// Get gets the pair for two groups by position in either order, nil if they
+// share nothing.
+func (m *GroupMatrix) Get(a, b int) *GroupPair {
+	if a > b {
+		a, b = b, a
+	}
+
+	return m.Pairs[[2]int{a, b}]
+}
+
+// SortedPairs gets every pair of groups sharing at least minShared
+// fingerprints, most shared first.
+func (m *GroupMatrix) SortedPairs(minShared int) []*GroupPair {
+	var pairs []*GroupPair
+	for _, pair := range m.Pairs {
+		if pair.Shared >= minShared {
+			pairs = append(pairs, pair)
+		}
+	}
+
+	slice.Sort(pairs, func(i, j int) bool {
+		if pairs[i].Shared != pairs[j].Shared {
+			return pairs[i].Shared > pairs[j].Shared
+		}
+		return pairs[i].A+pairs[i].B < pairs[j].A+pairs[j].B
+	})
+
+	return pairs
+}
+
+// WriteGroupPairs writes the pairs and their top files in fashion suitable
+// for displaying on-screen.
+func WriteGroupPairs(w io.Writer, pairs []*GroupPair) {
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+
+	fmt.Fprintln(tw, groupPairHeader)
+	for _, pair := range pairs {
+		fmt.Fprintf(tw, groupPairFormat, pair.Shared, pair.Score, pair.A, pair.B)
+
+		for _, file := range pair.Files {
+			fmt.Fprintf(tw, filePairFormat, file.Shared, file.A.Path, file.B.Path, file.label())
+		}
+	}
+
+	tw.Flush()
+}
+
+// WriteGroupPairsCsv writes one row per pair of groups with their top files.
+func WriteGroupPairsCsv(w io.Writer, pairs []*GroupPair) error {
+	out := csv.NewWriter(w)
+
+	out.Write([]string{"a", "b", "shared", "score", "top_files"})
+	for _, pair := range pairs {
+		var files []string
+		for _, file := range pair.Files {
+			files = append(files, fmt.Sprintf("%s ~ %s (%d)%s", file.A.Path, file.B.Path, file.Shared, file.label()))
+		}
+
+		out.Write([]string{
+			pair.A,
+			pair.B,
+			fmt.Sprint(pair.Shared),
+			fmt.Sprintf("%.3f", pair.Score),
+			strings.Join(files, "; "),
+		})
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+var heatmapTemplate = template.Must(template.New("heatmap").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Paraphrase Group Similarity</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: right; }
+th.row { text-align: left; }
+th.col { writing-mode: vertical-rl; transform: rotate(180deg); text-align: left; }
+td.self { background: #eee; }
+</style>
+</head>
+<body>
+<h1>Group Similarity</h1>
+<p>Cells show the fingerprints shared by two groups, darker cells share more
+of the smaller group. Hover over a cell to see the files contributing most.</p>
+<table>
+<tr><th></th>{{range .Groups}}<th class="col">{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr><th class="row">{{.Group}}</th>{{range .Cells}}{{if .Self}}<td class="self"></td>{{else if .Pair}}<td style="background: rgba(200, 0, 0, {{.Alpha}})" title="{{.Title}}">{{.Pair.Shared}}</td>{{else}}<td></td>{{end}}{{end}}</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+type heatmapCell struct {
+	Self  bool
+	Pair  *GroupPair
+	Alpha string
+	Title string
+}
+
+type heatmapRow struct {
+	Group string
+	Cells []heatmapCell
+}
+
+// WriteHeatmap writes the matrix as a standalone HTML page.
+func (m *GroupMatrix) WriteHeatmap(w io.Writer) error {
+	var rows []heatmapRow
+
+	for a, group := range m.Groups {
+		row := heatmapRow{Group: group}
+
+		for b := range m.Groups {
+			cell := heatmapCell{Self: a == b}
+
+			if pair := m.Get(a, b); pair != nil && a != b {
+				cell.Pair = pair
+				cell.Alpha = fmt.Sprintf("%.2f", pair.Score)
+
+				title := fmt.Sprintf("%s ~ %s: %.0f%%", pair.A, pair.B, pair.Score*100)
+				for _, file := range pair.Files {
+					title += fmt.Sprintf("\n%d %s ~ %s%s", file.Shared, file.A.Path, file.B.Path, file.label())
+				}
+				cell.Title = title
+			}
+
+			row.Cells = append(row.Cells, cell)
+		}
+
+		rows = append(rows, row)
+	}
+
+	return heatmapTemplate.Execute(w, struct {
+		Groups []string
+		Rows   []heatmapRow
+	}{m.Groups, rows})
+}
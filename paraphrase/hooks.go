@@ -0,0 +1,337 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+package paraphrase
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/josephlewis42/paraphrase/paraphrase/provider"
+)
+
+// HookKind is how a hook delivers its events.
+type HookKind string
+
+const (
+	// HookCommand runs Target with sh, the event is written to its stdin.
+	HookCommand HookKind = "command"
+	// HookHttp POSTs the event as JSON to the URL in Target.
+	HookHttp HookKind = "http"
+	// HookJsonl appends the event as a line to the file in Target.
+	HookJsonl HookKind = "jsonl"
+
+	// SimilarDocumentEvent is sent when a new document matches another.
+	SimilarDocumentEvent = "similar-document"
+
+	// hookTimeout is how long commands and requests get before they're given
+	// up on, hooks run before the next document is added.
+	hookTimeout = 10 * time.Second
+	// hookMatchLimit is the most matches of a new document sent to hooks.
+	hookMatchLimit = 10
+)
+
+// Hook is run when a document is created that matches an existing one at or
+// above MinScore.
+type Hook struct {
+	Id       int `storm:"id,increment"`
+	Kind     HookKind
+	Target   string
+	MinScore float64
+	// Namespaces are globs the new document's namespace must match one of,
+	// any namespace if there are none.
+	Namespaces []string
+	Created    time.Time
+}
+
+// ParseHookKind checks the name is a HookKind.
+func ParseHookKind(name string) (HookKind, error) {
+	switch kind := HookKind(name); kind {
+	case HookCommand, HookHttp, HookJsonl:
+		return kind, nil
+	default:
+		return "", fmt.Errorf("Unknown hook kind %q, expected command, http or jsonl", name)
+	}
+}
+
+// HookDocument describes a document in a HookEvent, bodies are left out.
+type HookDocument struct {
+	Id        int64             `json:"id"`
+	Namespace string            `json:"namespace"`
+	Path      string            `json:"path"`
+	Sha1      string            `json:"sha1"`
+	Size      int               `json:"size"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	Tags      []string          `json:"tags,omitempty"`
+}
+
+// HookEvent is the payload sent to hooks.
+type HookEvent struct {
+	Event    string       `json:"event"`
+	Hook     int          `json:"hook"`
+	Date     time.Time    `json:"date"`
+	Score    float64      `json:"score"`
+	Document HookDocument `json:"document"`
+	Match    HookDocument `json:"match"`
+}
+
+func newHookDocument(doc *Document) HookDocument {
+	return HookDocument{
+		Id:        doc.Id,
+		Namespace: doc.Namespace,
+		Path:      doc.Path,
+		Sha1:      doc.Sha1,
+		Size:      doc.Size,
+		Metadata:  doc.Metadata,
+		Tags:      doc.Tags,
+	}
+}
+
+// AddHook saves a new hook, it's run for documents created from then on.
+func (p *ParaphraseDb) AddHook(hook *Hook) error {
+	if _, err := ParseHookKind(string(hook.Kind)); err != nil {
+		return err
+	}
+
+	if hook.Target == "" {
+		return fmt.Errorf("A %s hook needs a target", hook.Kind)
+	}
+
+	for _, glob := range hook.Namespaces {
+		if _, err := provider.GlobToRegex(glob); err != nil {
+			return err
+		}
+	}
+
+	hook.Id = 0
+	hook.Created = time.Now()
+
+	err := p.store.SaveHook(hook)
+	if err != nil {
+		return err
+	}
+
+	p.logChange(ChangeLogEntry{Operation: OpHook}, "Added %s hook %d for scores of at least %v: %s", hook.Kind, hook.Id, hook.MinScore, hook.Target)
+	return nil
+}
+
+// DeleteHook removes a hook, NotFoundErr if there isn't one with the id.
+func (p *ParaphraseDb) DeleteHook(id int) error {
+	err := p.store.DeleteHook(id)
+	if err != nil {
+		return err
+	}
+
+	p.logChange(ChangeLogEntry{Operation: OpHook}, "Deleted hook %d", id)
+	return nil
+}
+
+// Hooks gets every hook in the order they were added.
+func (p *ParaphraseDb) Hooks() (hooks []Hook, err error) {
+	err = p.store.EachHook(func(hook *Hook) error {
+		hooks = append(hooks, *hook)
+		return nil
+	})
+
+	return hooks, err
+}
+
+// runHooks sends an event to each hook for every document doc matches above
+// the hook's score. Hooks failing are logged, they never stop the document
+// being added. Nothing is run unless hooks were allowed with SetRunHooks.
+func (p *ParaphraseDb) runHooks(doc *Document) error {
+	hooks, err := p.Hooks()
+	if err != nil || len(hooks) == 0 {
+		return err
+	}
+
+	if !p.hooksAllowed {
+		if !p.hooksSkipped {
+			log.Printf("Not running %d hook(s) saved in the database, allow them with --run-hooks\n", len(hooks))
+			p.hooksSkipped = true
+		}
+		return nil
+	}
+
+	var applicable []Hook
+	minScore := 1.0
+	for _, hook := range hooks {
+		if !hookApplies(&hook, doc) {
+			continue
+		}
+
+		applicable = append(applicable, hook)
+		if hook.MinScore < minScore {
+			minScore = hook.MinScore
+		}
+	}
+
+	if len(applicable) == 0 {
+		return nil
+	}
+
+	results, err := p.QueryByVector(doc.Hashes, SearchOptions{
+		Limit:       hookMatchLimit,
+		MinScore:    minScore,
+		Related:     doc,
+		ExcludeSelf: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		// an older version of the same file isn't a match worth telling
+		// anyone about, removed documents are already left out
+		if result.Doc.Namespace == doc.Namespace && result.Doc.Path == doc.Path {
+			continue
+		}
+
+		for _, hook := range applicable {
+			if result.Similarity() < hook.MinScore {
+				continue
+			}
+
+			event := HookEvent{
+				Event:    SimilarDocumentEvent,
+				Hook:     hook.Id,
+				Date:     time.Now().UTC(),
+				Score:    result.Similarity(),
+				Document: newHookDocument(doc),
+				Match:    newHookDocument(result.Doc),
+			}
+
+			err := runHook(&hook, &event)
+			if err != nil {
+				log.Printf("Error running %s hook %d for document %d: %s\n", hook.Kind, hook.Id, doc.Id, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// hookApplies checks if the hook wants events about documents like doc.
+func hookApplies(hook *Hook, doc *Document) bool {
+	if len(hook.Namespaces) == 0 {
+		return true
+	}
+
+	var matchers []func(string) bool
+	for _, glob := range hook.Namespaces {
+		matcher, err := provider.GlobToRegex(glob)
+		if err == nil {
+			matchers = append(matchers, matcher.MatchString)
+		}
+	}
+
+	return matchesAny(doc.Namespace, matchers)
+}
+
+// runHook delivers one event.
+func runHook(hook *Hook, event *HookEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	switch hook.Kind {
+	case HookCommand:
+		return runHookCommand(hook.Target, event, payload)
+	case HookHttp:
+		return postHook(hook.Target, payload)
+	case HookJsonl:
+		return appendHookLine(hook.Target, payload)
+	default:
+		return fmt.Errorf("Unknown hook kind %q", hook.Kind)
+	}
+}
+
+// runHookCommand runs the command with the event on stdin, the scores and
+// ids are in the environment too for simple scripts.
+func runHookCommand(command string, event *HookEvent, payload []byte) error {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("PARAPHRASE_EVENT=%s", event.Event),
+		fmt.Sprintf("PARAPHRASE_SCORE=%v", event.Score),
+		fmt.Sprintf("PARAPHRASE_DOCUMENT=%d", event.Document.Id),
+		fmt.Sprintf("PARAPHRASE_MATCH=%d", event.Match.Id),
+	)
+
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(hookTimeout):
+		cmd.Process.Kill()
+		return fmt.Errorf("command timed out after %v", hookTimeout)
+	}
+}
+
+func postHook(url string, payload []byte) error {
+	client := http.Client{Timeout: hookTimeout}
+
+	resp, err := client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+
+	return nil
+}
+
+func appendHookLine(path string, payload []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(append(payload, '\n'))
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// WriteHooks writes the hooks in a table.
+func WriteHooks(w io.Writer, hooks []Hook) {
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+
+	fmt.Fprintln(tw, "ID\tKind\tMin Score\tNamespaces\tTarget")
+	for _, hook := range hooks {
+		namespaces := strings.Join(hook.Namespaces, ",")
+		if namespaces == "" {
+			namespaces = "*"
+		}
+
+		fmt.Fprintf(tw, "%d\t%s\t%v\t%s\t%s\n", hook.Id, hook.Kind, hook.MinScore, namespaces, hook.Target)
+	}
+
+	tw.Flush()
+}
@@ -0,0 +1,380 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+package paraphrase
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bradfitz/slice"
+)
+
+// HtmlReportOptions controls what goes into an HTML report.
+type HtmlReportOptions struct {
+	// MinScore is the lowest similarity a pair of documents needs to be listed.
+	MinScore float64
+	// MaxPairs limits the number of pairs, the most similar are kept.
+	MaxPairs int
+	// Group adds a heatmap of the similarity between groups if it isn't nil.
+	Group Grouper
+	// HideDismissed leaves out pairs with a dismissed verdict.
+	HideDismissed bool
+}
+
+// reviewedPair is a pair in a report along with its verdict, if any.
+type reviewedPair struct {
+	Pair
+	Verdict *PairVerdict
+}
+
+// SimilarPairs finds pairs of documents matching the query with a similarity
+// of at least minScore, most similar first. If limit isn't negative at most
+// that many pairs are returned.
+func (p *ParaphraseDb) SimilarPairs(query Query, minScore float64, limit int) ([]Pair, error) {
+	docs, err := p.FindDocumentsMatching(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var pairs []Pair
+	for i := range docs {
+		a := &docs[i]
+
+		results, err := p.QueryByVector(a.Hashes, SearchOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, result := range results {
+			// each pair is found from both sides, keep one
+			if result.Doc.Id <= a.Id || !query.Match(result.Doc) {
+				continue
+			}
+
+			if score := result.Similarity(); score >= minScore {
+				pairs = append(pairs, Pair{a, result.Doc, score})
+			}
+		}
+	}
+
+	slice.Sort(pairs, func(i, j int) bool {
+		return pairs[i].Similarity > pairs[j].Similarity
+	})
+
+	if limit >= 0 && len(pairs) > limit {
+		pairs = pairs[:limit]
+	}
+
+	return pairs, nil
+}
+
+// WriteHtmlReport writes a static website describing the most similar pairs
+// of documents matching the query to dir. Every page and asset is written to
+// dir so it can be zipped and read without paraphrase.
+func (p *ParaphraseDb) WriteHtmlReport(dir string, query Query, options HtmlReportOptions) error {
+	// dismissed pairs don't count towards the limit
+	limit := options.MaxPairs
+	if options.HideDismissed {
+		limit = -1
+	}
+
+	found, err := p.SimilarPairs(query, options.MinScore, limit)
+	if err != nil {
+		return err
+	}
+
+	verdicts, err := p.Verdicts()
+	if err != nil {
+		return err
+	}
+
+	var pairs []reviewedPair
+	for _, pair := range found {
+		verdict := verdicts[PairKey(pair.A.Id, pair.B.Id)]
+		if options.HideDismissed && verdict != nil && verdict.Verdict == Dismissed {
+			continue
+		}
+
+		if options.MaxPairs >= 0 && len(pairs) == options.MaxPairs {
+			break
+		}
+
+		pairs = append(pairs, reviewedPair{pair, verdict})
+	}
+
+	for _, sub := range []string{"", "pairs", "docs"} {
+		err := os.MkdirAll(filepath.Join(dir, sub), 0755)
+		if err != nil {
+			return err
+		}
+	}
+
+	site := htmlSite{Generated: time.Now(), Pairs: pairs}
+
+	if options.Group != nil {
+		matrix, err := p.CompareGroups(query, options.Group, GroupOptions{TopFiles: 3, HideDismissed: options.HideDismissed})
+		if err != nil {
+			return err
+		}
+
+		if len(matrix.Groups) > 1 {
+			var heatmap bytes.Buffer
+			err = matrix.WriteHeatmap(&heatmap)
+			if err != nil {
+				return err
+			}
+
+			err = writeFile(filepath.Join(dir, "groups.html"), heatmap.Bytes())
+			if err != nil {
+				return err
+			}
+
+			site.HasGroups = true
+		}
+	}
+
+	err = writeFile(filepath.Join(dir, "style.css"), []byte(reportStyle))
+	if err != nil {
+		return err
+	}
+
+	err = renderPage(filepath.Join(dir, "index.html"), "index", site)
+	if err != nil {
+		return err
+	}
+
+	// documents get one page no matter how many pairs they're in
+	docPairs := make(map[int64][]reviewedPair)
+	docs := make(map[int64]*Document)
+
+	for _, pair := range pairs {
+		docPairs[pair.A.Id] = append(docPairs[pair.A.Id], pair)
+		docPairs[pair.B.Id] = append(docPairs[pair.B.Id], pair)
+		docs[pair.A.Id] = pair.A
+		docs[pair.B.Id] = pair.B
+
+		page, err := p.pairPage(pair)
+		if err != nil {
+			return err
+		}
+
+		err = renderPage(filepath.Join(dir, "pairs", pairFileName(pair.Pair)), "pair", page)
+		if err != nil {
+			return err
+		}
+	}
+
+	for id, doc := range docs {
+		data, err := p.FindDocumentDataById(id)
+		if err != nil {
+			return err
+		}
+
+		page := docPage{Doc: doc, Body: highlightHtml(data.Body, nil), Pairs: docPairs[id]}
+
+		err = renderPage(filepath.Join(dir, "docs", fmt.Sprintf("%d.html", id)), "doc", page)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type htmlSite struct {
+	Generated time.Time
+	Pairs     []reviewedPair
+	HasGroups bool
+}
+
+type pairPage struct {
+	Pair   reviewedPair
+	Shared int
+	BodyA  template.HTML
+	BodyB  template.HTML
+}
+
+type docPage struct {
+	Doc   *Document
+	Body  template.HTML
+	Pairs []reviewedPair
+}
+
+func (p *ParaphraseDb) pairPage(pair reviewedPair) (*pairPage, error) {
+	dataA, err := p.FindDocumentDataById(pair.A.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	dataB, err := p.FindDocumentDataById(pair.B.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	shared := sharedHashes(pair.A, pair.B)
+
+	return &pairPage{
+		Pair:   pair,
+		Shared: len(shared),
+		BodyA:  highlightHtml(dataA.Body, p.MatchingSpans(dataA.Body, shared)),
+		BodyB:  highlightHtml(dataB.Body, p.MatchingSpans(dataB.Body, shared)),
+	}, nil
+}
+
+// highlightHtml escapes body and wraps the spans in <mark> tags.
+func highlightHtml(body []byte, spans []Span) template.HTML {
+	var out bytes.Buffer
+
+	last := 0
+	for _, span := range spans {
+		template.HTMLEscape(&out, body[last:span.Start])
+		out.WriteString("<mark>")
+		template.HTMLEscape(&out, body[span.Start:span.End])
+		out.WriteString("</mark>")
+		last = span.End
+	}
+	template.HTMLEscape(&out, body[last:])
+
+	return template.HTML(out.String())
+}
+
+func pairFileName(pair Pair) string {
+	return fmt.Sprintf("%d-%d.html", pair.A.Id, pair.B.Id)
+}
+
+func renderPage(path, name string, data interface{}) error {
+	var out bytes.Buffer
+
+	err := reportTemplates.ExecuteTemplate(&out, name, data)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(path, out.Bytes())
+}
+
+func writeFile(path string, data []byte) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+const reportStyle = `body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; }
+th, td { border-bottom: 1px solid #ddd; padding: 4px 8px; text-align: left; }
+td.score { text-align: right; font-family: monospace; }
+.dismissed { color: #777; }
+.confirmed { color: #b00; font-weight: bold; }
+pre { background: #f7f7f7; padding: 1em; overflow-x: auto; white-space: pre-wrap; }
+mark { background: #ffd54f; }
+.columns { display: flex; gap: 1em; }
+.columns > div { flex: 1; min-width: 0; }
+.muted { color: #777; }
+`
+
+var reportTemplates = template.Must(template.New("report").Funcs(template.FuncMap{
+	"pairFile": pairFileName,
+	"percent":  func(f float64) string { return fmt.Sprintf("%.1f%%", f*100) },
+	"other": func(pair Pair, doc *Document) *Document {
+		if pair.A.Id == doc.Id {
+			return pair.B
+		}
+		return pair.A
+	},
+}).Parse(`
+{{define "header"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.}} - Paraphrase Report</title>
+<link rel="stylesheet" href="style.css">
+</head>
+<body>
+{{end}}
+
+{{define "subheader"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.}} - Paraphrase Report</title>
+<link rel="stylesheet" href="../style.css">
+</head>
+<body>
+<p><a href="../index.html">&larr; All pairs</a></p>
+{{end}}
+
+{{define "footer"}}</body>
+</html>
+{{end}}
+
+{{define "verdict"}}{{with .}}<span class="{{.Verdict}}" title="{{.}}{{with .Notes}}: {{.}}{{end}}">{{.Verdict}}</span>{{end}}{{end}}
+
+{{define "doclabel"}}{{.Path}} <span class="muted">{{.Namespace}}{{with .Author}}, {{.}}{{end}}</span>{{end}}
+
+{{define "index"}}{{template "header" "Most Similar Pairs"}}
+<h1>Most Similar Pairs</h1>
+<p class="muted">Generated {{.Generated.Format "2006-01-02 15:04"}}. {{len .Pairs}} pairs.
+{{if .HasGroups}}See also the <a href="groups.html">group heatmap</a>.{{end}}</p>
+<table>
+<tr><th>Score</th><th>Document A</th><th>Document B</th><th>Verdict</th><th></th></tr>
+{{range .Pairs}}<tr>
+<td class="score">{{percent .Similarity}}</td>
+<td><a href="docs/{{.A.Id}}.html">{{template "doclabel" .A}}</a></td>
+<td><a href="docs/{{.B.Id}}.html">{{template "doclabel" .B}}</a></td>
+<td>{{template "verdict" .Verdict}}</td>
+<td><a href="pairs/{{pairFile .Pair}}">compare</a></td>
+</tr>
+{{end}}</table>
+{{template "footer"}}{{end}}
+
+{{define "pair"}}{{template "subheader" "Comparison"}}
+<h1>{{percent .Pair.Similarity}} similar</h1>
+<p>{{.Shared}} shared fingerprints are highlighted.</p>
+{{with .Pair.Verdict}}<h2>Review</h2>
+<table>
+<tr><th>Date</th><th>Reviewer</th><th>Verdict</th><th>Notes</th></tr>
+{{range .History}}<tr class="muted"><td>{{.Date.Format "2006-01-02 15:04"}}</td><td>{{.Reviewer}}</td><td>{{.Verdict}}</td><td>{{.Notes}}</td></tr>
+{{end}}<tr><td>{{.Date.Format "2006-01-02 15:04"}}</td><td>{{.Reviewer}}</td><td>{{template "verdict" .}}</td><td>{{.Notes}}</td></tr>
+</table>{{end}}
+<div class="columns">
+<div><h2><a href="../docs/{{.Pair.A.Id}}.html">{{template "doclabel" .Pair.A}}</a></h2><pre>{{.BodyA}}</pre></div>
+<div><h2><a href="../docs/{{.Pair.B.Id}}.html">{{template "doclabel" .Pair.B}}</a></h2><pre>{{.BodyB}}</pre></div>
+</div>
+{{template "footer"}}{{end}}
+
+{{define "doc"}}{{template "subheader" .Doc.Path}}
+<h1>{{.Doc.Path}}</h1>
+<table>
+<tr><th>ID</th><td>{{.Doc.Id}}</td></tr>
+<tr><th>Namespace</th><td>{{.Doc.Namespace}}</td></tr>
+<tr><th>SHA1</th><td>{{.Doc.Sha1}}</td></tr>
+<tr><th>Indexed</th><td>{{.Doc.IndexDate.Format "2006-01-02 15:04"}}</td></tr>
+{{range $k, $v := .Doc.Metadata}}<tr><th>{{$k}}</th><td>{{$v}}</td></tr>
+{{end}}{{with .Doc.Tags}}<tr><th>Tags</th><td>{{range .}}{{.}} {{end}}</td></tr>{{end}}
+</table>
+<h2>Similar Documents</h2>
+<table>
+{{$doc := .Doc}}{{range .Pairs}}<tr>
+<td class="score">{{percent .Similarity}}</td>
+<td><a href="{{(other .Pair $doc).Id}}.html">{{template "doclabel" (other .Pair $doc)}}</a></td>
+<td>{{template "verdict" .Verdict}}</td>
+<td><a href="../pairs/{{pairFile .Pair}}">compare</a></td>
+</tr>
+{{end}}</table>
+<h2>Body</h2>
+<pre>{{.Body}}</pre>
+{{template "footer"}}{{end}}
+`))
@@ -1,37 +1,28 @@
 package paraphrase
 
 import (
+	"container/heap"
 	"errors"
 	"fmt"
 	"log"
 	"math"
 
-	"github.com/asdine/storm"
 	"github.com/bradfitz/slice"
-	"github.com/josephlewis42/paraphrase/paraphrase/linalg"
 )
 
+// IndexEntry is a posting recording how often a hash appears in a document.
 type IndexEntry struct {
-	Hash      uint64 `storm:"id,index"`
+	Hash      uint64
 	Doc       int64
 	Frequency int16
 }
 
-func (p *ParaphraseDb) storeHash(tx storm.Node, hash uint64, docId int64, count int16) error {
-	return tx.Save(&IndexEntry{hash, docId, count})
-}
-
-func (p *ParaphraseDb) getIndex(hash uint64) ([]IndexEntry, error) {
-	var index []IndexEntry
-
-	//err := p.db.Select(q.Eq("Hash", hash)).Find(&index)
-	err := p.db.Find("Hash", hash, &index)
-	return index, err
-}
-
 type SearchResult struct {
-	Query      *TermCountVector
-	Doc        *Document
+	Query *TermCountVector
+	Doc   *Document
+	// Verdict is the verdict on the pair of the result and the document
+	// searched for, nil if there isn't one.
+	Verdict    *PairVerdict
 	similarity float64
 }
 
@@ -55,16 +46,70 @@ func (sr *SearchResult) Similarity() float64 {
 	return match / (match + mismatch)
 }
 
-func (p *ParaphraseDb) QueryById(id int64) (results []SearchResult, err error) {
+// SearchOptions narrows and pages search results. The zero value returns
+// every matching document.
+type SearchOptions struct {
+	// Limit is the most results returned, all of them if it's not positive.
+	Limit int
+	// Offset skips this many of the best results.
+	Offset int
+	// After skips results up to and including the one with this cursor, see
+	// SearchResult.Cursor.
+	After string
+	// MinScore is the lowest similarity returned.
+	MinScore float64
+
+	// Namespaces and Paths are globs a result must match one of if any are
+	// given, results matching any of the Exclude globs are dropped. They
+	// match like the ns: and path: query terms.
+	Namespaces        []string
+	ExcludeNamespaces []string
+	Paths             []string
+	ExcludePaths      []string
+
+	// Related is the document being searched for, QueryById sets it. The
+	// flags below leave out documents related to it.
+	Related *Document
+	// ExcludeSelf leaves out the related document.
+	ExcludeSelf bool
+	// ExcludeCopies leaves out documents with the same SHA1.
+	ExcludeCopies bool
+	// ExcludeSameNamespace leaves out documents in the same namespace.
+	ExcludeSameNamespace bool
+	// ExcludeSameAuthor leaves out documents by the same author, documents
+	// without an author are never left out.
+	ExcludeSameAuthor bool
+
+	// IncludeRemoved returns documents a sync found gone, see RemovedTag.
+	IncludeRemoved bool
+}
+
+// excludesRelated checks if any of the flags leaving out related documents
+// are set.
+func (o *SearchOptions) excludesRelated() bool {
+	return o.ExcludeSelf || o.ExcludeCopies || o.ExcludeSameNamespace || o.ExcludeSameAuthor
+}
+
+// Cursor identifies the result's position so the next page can start after
+// it with SearchOptions.After.
+func (sr *SearchResult) Cursor() string {
+	return fmt.Sprintf("%x:%d", math.Float64bits(sr.similarity), sr.Doc.Id)
+}
+
+func (p *ParaphraseDb) QueryById(id int64, options SearchOptions) (results []SearchResult, err error) {
 
 	doc, err := p.FindDocumentById(id)
 	if err != nil {
 		return nil, err
 	}
-	return p.QueryByVector(doc.Hashes)
+	if options.Related == nil {
+		options.Related = doc
+	}
+
+	return p.QueryByVector(doc.Hashes, options)
 }
 
-func (p *ParaphraseDb) QueryByString(query string) (results []SearchResult, err error) {
+func (p *ParaphraseDb) QueryByString(query string, options SearchOptions) (results []SearchResult, err error) {
 	vec, err := p.WinnowData([]byte(query))
 
 	if err != nil {
@@ -75,10 +120,51 @@ func (p *ParaphraseDb) QueryByString(query string) (results []SearchResult, err
 		return results, errors.New("Query was not long enough to search.")
 	}
 
-	return p.QueryByVector(vec)
+	return p.QueryByVector(vec, options)
 }
 
-func (p *ParaphraseDb) QueryByVector(query TermCountVector) (results []SearchResult, err error) {
+// scoredDoc is a candidate result that hasn't been loaded yet.
+type scoredDoc struct {
+	id    int64
+	score float64
+}
+
+// before orders results best first, breaking ties by id so pages are stable.
+func (a scoredDoc) before(b scoredDoc) bool {
+	if a.score != b.score {
+		return a.score > b.score
+	}
+	return a.id < b.id
+}
+
+// worstFirst is a heap of the best results found so far with the worst on top
+// so it can be replaced when something better comes along.
+type worstFirst []scoredDoc
+
+func (h worstFirst) Len() int            { return len(h) }
+func (h worstFirst) Less(i, j int) bool  { return h[j].before(h[i]) }
+func (h worstFirst) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *worstFirst) Push(x interface{}) { *h = append(*h, x.(scoredDoc)) }
+func (h *worstFirst) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// QueryByVector finds the documents most similar to the query by the cosine
+// of their tf-idf vectors. Scores are computed from the postings and only the
+// documents returned are loaded.
+func (p *ParaphraseDb) QueryByVector(query TermCountVector, options SearchOptions) (results []SearchResult, err error) {
+	filter, err := newSearchFilter(p, options)
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := parseCursor(options.After)
+	if err != nil {
+		return nil, err
+	}
 
 	countI, err := p.CountDocuments()
 
@@ -88,51 +174,240 @@ func (p *ParaphraseDb) QueryByVector(query TermCountVector) (results []SearchRes
 
 	count := float64(countI)
 
-	idfVector := make(linalg.IFVector)
-	matchingDocIds := make(map[int64]bool)
+	// Only the query's hashes have an idf so the rest of a document's hashes
+	// don't count towards its score. Term frequencies are divided by the same
+	// total for every hash of a document which cosine similarity ignores, so
+	// raw counts from the postings are enough.
+	dots := make(map[int64]float64)
+	norms := make(map[int64]float64)
+	queryNorm := 0.0
+
+	// sum in a fixed order so scores, and cursors, are the same every time
+	hashes := make([]uint64, 0, len(query))
+	for hash := range query {
+		hashes = append(hashes, hash)
+	}
+	slice.Sort(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
 
-	for hash, _ := range query {
-		idx, err := p.getIndex(hash)
+	for _, hash := range hashes {
+		queryCount := query[hash]
 
-		switch err {
-		case nil:
-			docFrequency := 1 + len(idx)
-			idfVector[hash] = 1 + math.Log(count/float64(docFrequency))
+		idx, err := p.store.Postings(hash)
+		if err != nil {
+			return results, err
+		}
+
+		if len(idx) == 0 {
+			continue // a query might not have any matching documents
+		}
 
-			for _, doc := range idx {
-				matchingDocIds[doc.Doc] = true
+		docFrequency := 1 + len(idx)
+		idf := 1 + math.Log(count/float64(docFrequency))
+
+		queryWeight := float64(queryCount) * idf
+		queryNorm += queryWeight * queryWeight
+
+		for _, entry := range idx {
+			ok, err := filter.allows(entry.Doc)
+			if err != nil {
+				return results, err
+			}
+			if !ok {
+				continue
 			}
 
-		case storm.ErrNotFound:
-			fmt.Printf("Nothing found for %d\n", hash)
-			continue // a query might not have any matching documents
+			weight := float64(entry.Frequency) * idf
+			dots[entry.Doc] += weight * queryWeight
+			norms[entry.Doc] += weight * weight
+		}
+	}
 
-		default:
-			return results, err
+	keep := options.Offset + options.Limit
+	best := make(worstFirst, 0)
+
+	for id, dot := range dots {
+		candidate := scoredDoc{id, dot / (math.Sqrt(norms[id]) * math.Sqrt(queryNorm))}
+
+		if candidate.score < options.MinScore {
+			continue
+		}
+
+		if after != nil && !after.before(candidate) {
+			continue
+		}
+
+		if options.Limit <= 0 || best.Len() < keep {
+			heap.Push(&best, candidate)
+		} else if candidate.before(best[0]) {
+			best[0] = candidate
+			heap.Fix(&best, 0)
 		}
 	}
 
-	queryNorm := query.NormalizedTermFrequency()
-	queryNorm.Prod(idfVector)
+	slice.Sort(best, func(i, j int) bool {
+		return best[i].before(best[j])
+	})
+
+	if options.Offset >= len(best) {
+		return results, nil
+	}
 
-	for id, _ := range matchingDocIds {
-		doc, err := p.FindDocumentById(id)
+	for _, candidate := range best[options.Offset:] {
+		doc, err := p.FindDocumentById(candidate.id)
 		if err != nil {
-			log.Printf("Could not fetch doc %d: %s", id, err)
+			log.Printf("Could not fetch doc %d: %s", candidate.id, err)
 			continue
 		}
 
-		docNorm := doc.NormalizedTermFrequency()
-		docNorm.Prod(idfVector)
+		result := SearchResult{Query: &query, Doc: doc, similarity: candidate.score}
+
+		if options.Related != nil {
+			result.Verdict, err = p.FindVerdict(options.Related.Id, doc.Id)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		results = append(results, result)
+	}
 
-		similarity := docNorm.CosineSimilarity(queryNorm)
+	return results, nil
+}
 
-		results = append(results, SearchResult{&query, doc, similarity})
+func parseCursor(cursor string) (*scoredDoc, error) {
+	if cursor == "" {
+		return nil, nil
 	}
 
-	slice.Sort(results[:], func(i, j int) bool {
-		return results[i].Similarity() > results[j].Similarity()
-	})
+	var bits uint64
+	var id int64
 
-	return results, nil
+	_, err := fmt.Sscanf(cursor, "%x:%d", &bits, &id)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid cursor %q", cursor)
+	}
+
+	return &scoredDoc{id, math.Float64frombits(bits)}, nil
+}
+
+// searchFilter checks candidates' headers against the search options,
+// remembering the answer for each document.
+type searchFilter struct {
+	p *ParaphraseDb
+
+	namespaces, excludeNamespaces []func(string) bool
+	paths, excludePaths           []func(string) bool
+
+	options SearchOptions
+	related *DocumentHeader
+
+	allowed map[int64]bool
+}
+
+func newSearchFilter(p *ParaphraseDb, options SearchOptions) (*searchFilter, error) {
+	f := searchFilter{p: p, options: options, allowed: make(map[int64]bool)}
+
+	if options.excludesRelated() {
+		if options.Related == nil {
+			return nil, errors.New("Leaving out related documents needs the document being searched for")
+		}
+
+		f.related = options.Related.Header()
+	}
+
+	// globs match like the ns: and path: query terms
+	for _, globs := range []struct {
+		in      []string
+		out     *[]func(string) bool
+		matcher func(string) (func(string) bool, error)
+	}{
+		{options.Namespaces, &f.namespaces, globMatcher},
+		{options.ExcludeNamespaces, &f.excludeNamespaces, globMatcher},
+		{options.Paths, &f.paths, pathGlobMatcher},
+		{options.ExcludePaths, &f.excludePaths, pathGlobMatcher},
+	} {
+		for _, glob := range globs.in {
+			match, err := globs.matcher(glob)
+			if err != nil {
+				return nil, err
+			}
+
+			*globs.out = append(*globs.out, match)
+		}
+	}
+
+	return &f, nil
+}
+
+func (f *searchFilter) empty() bool {
+	return len(f.namespaces)+len(f.excludeNamespaces)+len(f.paths)+len(f.excludePaths) == 0 && f.related == nil &&
+		f.options.IncludeRemoved
+}
+
+func (f *searchFilter) allows(id int64) (bool, error) {
+	if f.empty() {
+		return true, nil
+	}
+
+	if ok, seen := f.allowed[id]; seen {
+		return ok, nil
+	}
+
+	header, err := f.p.store.DocumentHeader(id)
+	if err == NotFoundErr {
+		// fsck reports postings without documents
+		f.allowed[id] = false
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// matchesAny allows anything if there are no globs
+	ok := matchesAny(header.Namespace, f.namespaces) &&
+		(len(f.excludeNamespaces) == 0 || !matchesAny(header.Namespace, f.excludeNamespaces)) &&
+		matchesAny(header.Path, f.paths) &&
+		(len(f.excludePaths) == 0 || !matchesAny(header.Path, f.excludePaths)) &&
+		(f.options.IncludeRemoved || !header.Removed) &&
+		!f.isRelated(header)
+
+	f.allowed[id] = ok
+	return ok, nil
+}
+
+// isRelated checks if the options leave out the document because of how it's
+// related to the one being searched for.
+func (f *searchFilter) isRelated(header *DocumentHeader) bool {
+	related := f.related
+	if related == nil {
+		return false
+	}
+
+	switch {
+	case f.options.ExcludeSelf && header.Id == related.Id:
+		return true
+	case f.options.ExcludeCopies && header.Sha1 == related.Sha1:
+		return true
+	case f.options.ExcludeSameNamespace && header.Namespace == related.Namespace:
+		return true
+	case f.options.ExcludeSameAuthor && related.Author != "" && header.Author == related.Author:
+		return true
+	default:
+		return false
+	}
+}
+
+// matchesAny checks if any matcher matches the text, or if there are none.
+func matchesAny(text string, matchers []func(string) bool) bool {
+	if len(matchers) == 0 {
+		return true
+	}
+
+	for _, match := range matchers {
+		if match(text) {
+			return true
+		}
+	}
+
+	return false
 }
@@ -0,0 +1,109 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+package paraphrase
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bradfitz/slice"
+)
+
+const (
+	// LicenseNamespace is reserved for known license texts. The name of each
+	// license is the base name of its path without an extension, so loading
+	// SPDX's license-list-data text directory gives names like "Apache-2.0".
+	LicenseNamespace = "_licenses"
+
+	licenseMatchHeader = "ID\tNamespace\tPath\tLicense\tContainment"
+	licenseMatchFormat = "%v\t%v\t%v\t%v\t%.3f\n"
+)
+
+// LicenseMatch is a license whose text was found in a document.
+type LicenseMatch struct {
+	Document *Document
+	License  *Document
+	// Containment is the fraction of the license's fingerprints found in the
+	// document.
+	Containment float64
+}
+
+// LicenseName gets the name of a license document.
+func LicenseName(license *Document) string {
+	name := path.Base(license.Path)
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+// FindLicenses reports every license containing at least threshold of its
+// text in a document outside of the license namespace. If namespace isn't
+// blank only documents in namespaces matching the glob are reported.
+func (p *ParaphraseDb) FindLicenses(namespace string, threshold float64) ([]LicenseMatch, error) {
+	var matches []LicenseMatch
+
+	options := SearchOptions{ExcludeNamespaces: []string{LicenseNamespace}}
+	if namespace != "" {
+		options.Namespaces = []string{namespace}
+	}
+
+	licenses, err := p.documentsInNamespace(LicenseNamespace)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range licenses {
+		license := &licenses[i]
+		if len(license.Hashes) == 0 {
+			continue
+		}
+
+		results, err := p.QueryByVector(license.Hashes, options)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, result := range results {
+			doc := result.Doc
+
+			shared := 0
+			for hash := range license.Hashes {
+				if _, ok := doc.Hashes[hash]; ok {
+					shared++
+				}
+			}
+
+			containment := float64(shared) / float64(len(license.Hashes))
+			if containment >= threshold {
+				matches = append(matches, LicenseMatch{doc, license, containment})
+			}
+		}
+	}
+
+	slice.Sort(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.Document.Id != b.Document.Id {
+			if a.Document.Path != b.Document.Path {
+				return a.Document.Path < b.Document.Path
+			}
+			return a.Document.Id < b.Document.Id
+		}
+		return a.Containment > b.Containment
+	})
+
+	return matches, nil
+}
+
+// WriteLicenseMatches writes the matches in fashion suitable for displaying
+// on-screen.
+func WriteLicenseMatches(w io.Writer, matches []LicenseMatch) {
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+
+	fmt.Fprintln(tw, licenseMatchHeader)
+	for _, m := range matches {
+		fmt.Fprintf(tw, licenseMatchFormat, m.Document.Id, m.Document.Namespace, m.Document.Path, LicenseName(m.License), m.Containment)
+	}
+
+	tw.Flush()
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+package paraphrase
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	// DefaultLockTimeout is how long Open waits for other processes to
+	// release the database.
+	DefaultLockTimeout = 5 * time.Second
+
+	lockExt = ".lock"
+)
+
+// LockInfo describes the process holding the database open for writing, it's
+// kept next to the database so others can say who they're waiting on.
+type LockInfo struct {
+	Pid     int       `json:"pid"`
+	Command string    `json:"command"`
+	Since   time.Time `json:"since"`
+}
+
+// DatabaseLockedError is returned when another process has held the database
+// for longer than the timeout.
+type DatabaseLockedError struct {
+	Holder LockInfo
+}
+
+func (e *DatabaseLockedError) Error() string {
+	running := ""
+	if e.Holder.Command != "" {
+		running = fmt.Sprintf(" running `%s`", e.Holder.Command)
+	}
+
+	return fmt.Sprintf("The database is locked by PID %d%s since %s, try again when it has finished",
+		e.Holder.Pid, running, e.Holder.Since.Format("2006-01-02 15:04:05"))
+}
+
+// lockedError explains who has the database at dbPath locked. Readers don't
+// leave a LockInfo so DatabaseLockedErr is returned if the writer is gone.
+func lockedError(dbPath string) error {
+	info, err := readLockInfo(dbPath)
+	if err != nil || !processExists(info.Pid) {
+		return DatabaseLockedErr
+	}
+
+	return &DatabaseLockedError{*info}
+}
+
+// isLocked checks if the error is from another process holding the database.
+func isLocked(err error) bool {
+	_, ok := err.(*DatabaseLockedError)
+	return ok || err == DatabaseLockedErr
+}
+
+func readLockInfo(dbPath string) (*LockInfo, error) {
+	body, err := ioutil.ReadFile(dbPath + lockExt)
+	if err != nil {
+		return nil, err
+	}
+
+	var info LockInfo
+	err = json.Unmarshal(body, &info)
+	return &info, err
+}
+
+// writeLockInfo records the current process as holding the database, it must
+// only be called with bolt's lock held.
+func writeLockInfo(dbPath, command string) error {
+	body, err := json.Marshal(LockInfo{os.Getpid(), command, time.Now()})
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(dbPath+lockExt, body, 0644)
+}
+
+// removeLockInfo removes the LockInfo if it's the current process's.
+func removeLockInfo(dbPath string) {
+	info, err := readLockInfo(dbPath)
+	if err == nil && info.Pid == os.Getpid() {
+		os.Remove(dbPath + lockExt)
+	}
+}
+
+func processExists(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,392 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+package paraphrase
+
+import (
+	"sync"
+
+	"github.com/bradfitz/slice"
+)
+
+// memoryStorage keeps everything in memory, it's useful for tests and
+// throwaway comparisons that shouldn't leave a database behind.
+type memoryStorage struct {
+	lock     sync.RWMutex
+	docs     map[int64]Document
+	data     map[int64]DocumentData
+	postings map[uint64]map[int64]int16
+	settings *Settings
+	changes  []ChangeLogEntry
+	verdicts map[string]PairVerdict
+	hooks    []Hook
+	lastHook int
+}
+
+// NewMemoryStorage creates empty storage that lives until the process exits.
+func NewMemoryStorage() Storage {
+	return &memoryStorage{
+		docs:     make(map[int64]Document),
+		data:     make(map[int64]DocumentData),
+		postings: make(map[uint64]map[int64]int16),
+		verdicts: make(map[string]PairVerdict),
+	}
+}
+
+func (m *memoryStorage) Close() error {
+	return nil
+}
+
+func (m *memoryStorage) SaveDocument(doc *Document, data *DocumentData) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if old, ok := m.docs[doc.Id]; ok {
+		m.deletePostings(&old)
+	}
+
+	for hash, count := range doc.Hashes {
+		entries, ok := m.postings[hash]
+		if !ok {
+			entries = make(map[int64]int16)
+			m.postings[hash] = entries
+		}
+
+		entries[doc.Id] = count
+	}
+
+	m.docs[doc.Id] = *doc
+	m.data[doc.Id] = *data
+
+	return nil
+}
+
+func (m *memoryStorage) DeleteDocument(id int64) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	doc, ok := m.docs[id]
+	if !ok {
+		return NotFoundErr
+	}
+
+	m.deletePostings(&doc)
+	delete(m.docs, id)
+	delete(m.data, id)
+
+	return nil
+}
+
+func (m *memoryStorage) deletePostings(doc *Document) {
+	for hash := range doc.Hashes {
+		delete(m.postings[hash], doc.Id)
+
+		if len(m.postings[hash]) == 0 {
+			delete(m.postings, hash)
+		}
+	}
+}
+
+func (m *memoryStorage) Document(id int64) (*Document, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	doc, ok := m.docs[id]
+	if !ok {
+		return &doc, NotFoundErr
+	}
+
+	return &doc, nil
+}
+
+func (m *memoryStorage) DocumentHeader(id int64) (*DocumentHeader, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	doc, ok := m.docs[id]
+	if !ok {
+		return &DocumentHeader{}, NotFoundErr
+	}
+
+	return doc.Header(), nil
+}
+
+func (m *memoryStorage) DocumentData(id int64) (*DocumentData, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	data, ok := m.data[id]
+	if !ok {
+		return &data, NotFoundErr
+	}
+
+	return &data, nil
+}
+
+func (m *memoryStorage) DocumentsBySha1(sha1 string) (results []Document, err error) {
+	err = m.EachDocument(func(doc *Document) error {
+		if doc.Sha1 == sha1 {
+			results = append(results, *doc)
+		}
+
+		return nil
+	})
+
+	return results, err
+}
+
+func (m *memoryStorage) CountDocuments() (int, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return len(m.docs), nil
+}
+
+// EachDocument visits documents in id order, like the bolt storage.
+func (m *memoryStorage) EachDocument(fn func(doc *Document) error) error {
+	m.lock.RLock()
+	ids := make([]int64, 0, len(m.docs))
+	for id := range m.docs {
+		ids = append(ids, id)
+	}
+	m.lock.RUnlock()
+
+	slice.Sort(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+		doc, err := m.Document(id)
+		if err == NotFoundErr {
+			continue
+		}
+
+		err = fn(doc)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *memoryStorage) EachDocumentData(fn func(data *DocumentData) error) error {
+	m.lock.RLock()
+	ids := make([]int64, 0, len(m.data))
+	for id := range m.data {
+		ids = append(ids, id)
+	}
+	m.lock.RUnlock()
+
+	slice.Sort(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+		data, err := m.DocumentData(id)
+		if err == NotFoundErr {
+			continue
+		}
+
+		err = fn(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *memoryStorage) Postings(hash uint64) (entries []IndexEntry, err error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	for doc, count := range m.postings[hash] {
+		entries = append(entries, IndexEntry{hash, doc, count})
+	}
+
+	return entries, nil
+}
+
+func (m *memoryStorage) DeletePosting(entry IndexEntry) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.postings[entry.Hash], entry.Doc)
+
+	if len(m.postings[entry.Hash]) == 0 {
+		delete(m.postings, entry.Hash)
+	}
+
+	return nil
+}
+
+func (m *memoryStorage) CountHashes() (int, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return len(m.postings), nil
+}
+
+func (m *memoryStorage) EachPosting(fn func(entry *IndexEntry) error) error {
+	m.lock.RLock()
+	var entries []IndexEntry
+	for hash, docs := range m.postings {
+		for doc, count := range docs {
+			entries = append(entries, IndexEntry{hash, doc, count})
+		}
+	}
+	m.lock.RUnlock()
+
+	for i := range entries {
+		err := fn(&entries[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *memoryStorage) Settings() (Settings, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if m.settings == nil {
+		return Settings{}, SettingsNotDefinedErr
+	}
+
+	return *m.settings, nil
+}
+
+func (m *memoryStorage) SaveSettings(settings Settings) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.settings = &settings
+
+	return nil
+}
+
+// SchemaVersion is always the latest, memory storage is never migrated.
+func (m *memoryStorage) SchemaVersion() (int, error) {
+	return LatestSchemaVersion(), nil
+}
+
+func (m *memoryStorage) AppendChange(change *ChangeLogEntry) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var prev *ChangeLogEntry
+	if len(m.changes) > 0 {
+		prev = &m.changes[len(m.changes)-1]
+	}
+
+	chainChange(prev, change)
+	m.changes = append(m.changes, *change)
+
+	return nil
+}
+
+func (m *memoryStorage) EachChange(fn func(change *ChangeLogEntry) error) error {
+	m.lock.RLock()
+	changes := append([]ChangeLogEntry(nil), m.changes...)
+	m.lock.RUnlock()
+
+	for i := range changes {
+		err := fn(&changes[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *memoryStorage) SaveVerdict(verdict *PairVerdict) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.verdicts[verdict.Id] = *verdict
+	return nil
+}
+
+func (m *memoryStorage) Verdict(id string) (*PairVerdict, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	verdict, ok := m.verdicts[id]
+	if !ok {
+		return &verdict, NotFoundErr
+	}
+
+	return &verdict, nil
+}
+
+func (m *memoryStorage) EachVerdict(fn func(verdict *PairVerdict) error) error {
+	m.lock.RLock()
+	verdicts := make([]PairVerdict, 0, len(m.verdicts))
+	for _, verdict := range m.verdicts {
+		verdicts = append(verdicts, verdict)
+	}
+	m.lock.RUnlock()
+
+	slice.Sort(verdicts, func(i, j int) bool {
+		return verdicts[i].Id < verdicts[j].Id
+	})
+
+	for i := range verdicts {
+		err := fn(&verdicts[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *memoryStorage) SaveHook(hook *Hook) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if hook.Id == 0 {
+		m.lastHook++
+		hook.Id = m.lastHook
+		m.hooks = append(m.hooks, *hook)
+		return nil
+	}
+
+	for i := range m.hooks {
+		if m.hooks[i].Id == hook.Id {
+			m.hooks[i] = *hook
+			return nil
+		}
+	}
+
+	m.hooks = append(m.hooks, *hook)
+	return nil
+}
+
+func (m *memoryStorage) DeleteHook(id int) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for i := range m.hooks {
+		if m.hooks[i].Id == id {
+			m.hooks = append(m.hooks[:i], m.hooks[i+1:]...)
+			return nil
+		}
+	}
+
+	return NotFoundErr
+}
+
+func (m *memoryStorage) EachHook(fn func(hook *Hook) error) error {
+	m.lock.RLock()
+	hooks := append([]Hook{}, m.hooks...)
+	m.lock.RUnlock()
+
+	for i := range hooks {
+		err := fn(&hooks[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,264 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+package paraphrase
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/boltdb/bolt"
+)
+
+const (
+	MetaBucket       = "meta"
+	schemaVersionKey = "schema_version"
+
+	// legacySchemaVersion is assumed for databases created before the schema
+	// version was recorded.
+	legacySchemaVersion = 1
+	legacyIndexBucket   = "IndexEntry"
+	backupTimeFormat    = "20060102T150405"
+)
+
+// migrationNeededErr is returned when opening a read-only database with an
+// older schema, OpenWithOptions migrates it first.
+var migrationNeededErr = errors.New("The database must be migrated before it can be opened read-only")
+
+// SchemaVersionErr is returned when a database was written by a newer
+// version of paraphrase than the one trying to open it.
+type SchemaVersionErr struct {
+	Found     int
+	Supported int
+}
+
+func (e *SchemaVersionErr) Error() string {
+	return fmt.Sprintf("The database uses schema version %d but this version of paraphrase only understands up to version %d, upgrade paraphrase to open it", e.Found, e.Supported)
+}
+
+// A migration upgrades a bolt database from schema Version-1 to Version
+// inside a single transaction.
+type migration struct {
+	Version     int
+	Description string
+	Migrate     func(s *boltStorage, tx *bolt.Tx) error
+}
+
+// migrations holds every schema change in the order it must be applied.
+// New migrations MUST be appended with the next version number, existing ones
+// must never be changed once released.
+var migrations = []migration{
+	{1, "Initial schema", func(s *boltStorage, tx *bolt.Tx) error { return nil }},
+	{2, "Store one index entry per hash and document", migratePostings},
+	{3, "Record the size of every document", migrateDocumentSizes},
+	{4, "Store document headers for filtering searches", migrateDocumentHeaders},
+	{5, "Chain changelog entries with hashes", migrateChangeChain},
+	{6, "Mark removed documents in their headers", migrateDocumentHeaders},
+}
+
+// LatestSchemaVersion is the newest schema version this binary can read and
+// write.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// SchemaVersion gets the schema version stored in the database.
+func (s *boltStorage) SchemaVersion() (int, error) {
+	var version int
+	err := s.db.Get(MetaBucket, schemaVersionKey, &version)
+
+	switch err {
+	case nil:
+		return version, nil
+	case storm.ErrNotFound:
+		return legacySchemaVersion, nil
+	default:
+		return 0, err
+	}
+}
+
+// initSchema marks a freshly created database as having the latest schema.
+func (s *boltStorage) initSchema() error {
+	return s.db.Set(MetaBucket, schemaVersionKey, LatestSchemaVersion())
+}
+
+// migrate brings the database up to the latest schema version, backing it up
+// first if anything needs to change.
+func (s *boltStorage) migrate() error {
+	current, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	latest := LatestSchemaVersion()
+
+	if current > latest {
+		return &SchemaVersionErr{current, latest}
+	}
+
+	if current == latest {
+		return nil
+	}
+
+	if s.db.Bolt.IsReadOnly() {
+		return migrationNeededErr
+	}
+
+	backup, err := s.backupForMigration(current)
+	if err != nil {
+		return fmt.Errorf("Could not back up the database before migrating: %s", err)
+	}
+	log.Printf("Backed up database to %s before migrating\n", backup)
+
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+
+		log.Printf("Migrating database to schema version %d (%s)\n", m.Version, m.Description)
+
+		err := s.runMigration(m)
+		if err != nil {
+			return fmt.Errorf("Migration to schema version %d failed, the original database is in %s: %s", m.Version, backup, err)
+		}
+
+		change := newChangeLogEntry(ChangeLogEntry{Operation: OpMigrate}, "Migrated schema from version %d to %d: %s", current, m.Version, m.Description)
+		err = s.AppendChange(change)
+		if err != nil {
+			log.Printf("Error writing changelog entry %v\n", err)
+		}
+
+		current = m.Version
+	}
+
+	return nil
+}
+
+func (s *boltStorage) runMigration(m migration) error {
+	return s.db.Bolt.Update(func(tx *bolt.Tx) error {
+		err := m.Migrate(s, tx)
+		if err != nil {
+			return err
+		}
+
+		return s.db.WithTransaction(tx).Set(MetaBucket, schemaVersionKey, m.Version)
+	})
+}
+
+// backupForMigration writes a consistent copy of the database next to the
+// original and returns its path.
+func (s *boltStorage) backupForMigration(version int) (string, error) {
+	backup := fmt.Sprintf("%s.v%d-%s.bak", s.path, version, time.Now().Format(backupTimeFormat))
+
+	err := s.db.Bolt.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(backup, 0600)
+	})
+
+	return backup, err
+}
+
+// migratePostings rebuilds the index from the documents' hashes. The old
+// IndexEntry bucket was keyed by hash alone so only the last document added
+// with each hash was kept.
+func migratePostings(s *boltStorage, tx *bolt.Tx) error {
+	postings, err := tx.CreateBucketIfNotExists([]byte(PostingsBucket))
+	if err != nil {
+		return err
+	}
+
+	err = s.db.WithTransaction(tx).Select().Each(new(Document), func(record interface{}) error {
+		doc := record.(*Document)
+
+		for hash, count := range doc.Hashes {
+			err := putPosting(postings, IndexEntry{hash, doc.Id, count})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+
+	err = tx.DeleteBucket([]byte(legacyIndexBucket))
+	if err != nil && err != bolt.ErrBucketNotFound {
+		return err
+	}
+
+	return nil
+}
+
+// migrateDocumentSizes fills in Document.Size from the bodies.
+func migrateDocumentSizes(s *boltStorage, tx *bolt.Tx) error {
+	node := s.db.WithTransaction(tx)
+
+	err := node.Select().Each(new(DocumentData), func(record interface{}) error {
+		data := record.(*DocumentData)
+
+		var doc Document
+		err := node.One("Id", data.Id, &doc)
+		if err == storm.ErrNotFound {
+			// fsck reports bodies without documents
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		doc.Size = len(data.Body)
+		return node.Save(&doc)
+	})
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+
+	return nil
+}
+
+// migrateDocumentHeaders writes the header of every document.
+func migrateDocumentHeaders(s *boltStorage, tx *bolt.Tx) error {
+	headers, err := tx.CreateBucketIfNotExists([]byte(HeadersBucket))
+	if err != nil {
+		return err
+	}
+
+	err = s.db.WithTransaction(tx).Select().Each(new(Document), func(record interface{}) error {
+		return putHeader(headers, record.(*Document).Header())
+	})
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+
+	return nil
+}
+
+// migrateChangeChain hashes the existing changelog entries into a chain in
+// id order. Ids are left alone so gaps from before the chain stay visible.
+func migrateChangeChain(s *boltStorage, tx *bolt.Tx) error {
+	node := s.db.WithTransaction(tx)
+
+	var changes []ChangeLogEntry
+	err := node.All(&changes)
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+
+	var prev *ChangeLogEntry
+	for i := range changes {
+		change := &changes[i]
+		linkChange(prev, change)
+
+		err := node.Save(change)
+		if err != nil {
+			return err
+		}
+
+		prev = change
+	}
+
+	return nil
+}
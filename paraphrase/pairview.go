@@ -0,0 +1,232 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+package paraphrase
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const (
+	pairViewTabWidth    = 4
+	pairViewNumberWidth = 5
+)
+
+// PairView lays a pair of documents out in two columns for a terminal with
+// the text they share highlighted. Each side scrolls on its own so matching
+// regions can be lined up.
+type PairView struct {
+	Pair Pair
+	// Shared is the number of fingerprints the documents share.
+	Shared int
+	// Left and Right are the rows of each document, padded to the width of
+	// the column.
+	Left  []string
+	Right []string
+	// Matches are the rows shared regions start on in each column, in the
+	// order of the left column. Regions are paired up by their text, a side
+	// without a matching region is -1.
+	Matches [][2]int
+}
+
+// viewRegion is a highlighted region of a column.
+type viewRegion struct {
+	row  int
+	text string
+}
+
+// NewPairView lays out the pair in two columns of the given width, long
+// lines are wrapped.
+func (p *ParaphraseDb) NewPairView(pair Pair, width int) (*PairView, error) {
+	view := PairView{Pair: pair}
+
+	shared := sharedHashes(pair.A, pair.B)
+	view.Shared = len(shared)
+
+	left, leftRegions, err := p.pairViewColumn(pair.A, shared, width)
+	if err != nil {
+		return nil, err
+	}
+
+	right, rightRegions, err := p.pairViewColumn(pair.B, shared, width)
+	if err != nil {
+		return nil, err
+	}
+
+	view.Left, view.Right = left, right
+	view.Matches = alignRegions(leftRegions, rightRegions)
+
+	return &view, nil
+}
+
+// alignRegions pairs each region on the left with the first unused region on
+// the right with the same text, or failing that one containing it or
+// contained by it. Regions on the right without a partner are added at the
+// end.
+func alignRegions(left, right []viewRegion) [][2]int {
+	var matches [][2]int
+	used := make([]bool, len(right))
+
+	partner := func(l viewRegion, same func(a, b string) bool) int {
+		for j, r := range right {
+			if !used[j] && same(l.text, r.text) {
+				return j
+			}
+		}
+		return -1
+	}
+
+	equal := func(a, b string) bool { return a == b }
+	overlaps := func(a, b string) bool { return strings.Contains(a, b) || strings.Contains(b, a) }
+
+	for _, l := range left {
+		j := partner(l, equal)
+		if j < 0 {
+			j = partner(l, overlaps)
+		}
+
+		if j < 0 {
+			matches = append(matches, [2]int{l.row, -1})
+			continue
+		}
+
+		used[j] = true
+		matches = append(matches, [2]int{l.row, right[j].row})
+	}
+
+	for j, r := range right {
+		if !used[j] {
+			matches = append(matches, [2]int{-1, r.row})
+		}
+	}
+
+	return matches
+}
+
+// pairViewColumn lays out one document, returning its rows and highlighted
+// regions.
+func (p *ParaphraseDb) pairViewColumn(doc *Document, shared TermCountVector, width int) ([]string, []viewRegion, error) {
+	data, err := p.FindDocumentDataById(doc.Id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	before, after, _, _ := markupTags(MarkupAnsi)
+
+	body := data.Body
+	spans := p.MatchingSpans(body, shared)
+
+	textWidth := width - pairViewNumberWidth
+	if textWidth < 1 {
+		textWidth = 1
+	}
+
+	var rows []string
+	var regions []viewRegion
+	var regionText bytes.Buffer
+
+	// cell is a single character on screen
+	type cell struct {
+		char      rune
+		highlight bool
+	}
+
+	offset := 0
+	spanIdx := 0
+	lastHighlighted := false
+	for lineNo, line := range strings.Split(string(body), "\n") {
+		var cells []cell
+
+		for i, char := range line {
+			at := offset + i
+			for spanIdx < len(spans) && spans[spanIdx].End <= at {
+				spanIdx++
+			}
+			highlight := spanIdx < len(spans) && spans[spanIdx].Start <= at
+
+			switch {
+			case char == '\t':
+				for n := 0; n < pairViewTabWidth; n++ {
+					cells = append(cells, cell{' ', highlight})
+				}
+			case !unicode.IsPrint(char):
+				cells = append(cells, cell{' ', highlight})
+			default:
+				cells = append(cells, cell{char, highlight})
+			}
+		}
+		offset += len(line) + 1
+
+		for start := 0; start == 0 || start < len(cells); start += textWidth {
+			end := start + textWidth
+			if end > len(cells) {
+				end = len(cells)
+			}
+
+			var row bytes.Buffer
+			if start == 0 {
+				fmt.Fprintf(&row, "%*d ", pairViewNumberWidth-1, lineNo+1)
+			} else {
+				row.WriteString(strings.Repeat(" ", pairViewNumberWidth))
+			}
+
+			lit := false
+			rowHighlighted := false
+			var highlighted bytes.Buffer
+			for _, c := range cells[start:end] {
+				if c.highlight != lit {
+					if c.highlight {
+						row.WriteString(before)
+					} else {
+						row.WriteString(after)
+					}
+					lit = c.highlight
+				}
+
+				if c.highlight {
+					rowHighlighted = true
+					if !unicode.IsSpace(c.char) {
+						highlighted.WriteRune(c.char)
+					}
+				}
+				row.WriteRune(c.char)
+			}
+			if lit {
+				row.WriteString(after)
+			}
+
+			// blank lines don't split a region
+			if strings.TrimSpace(string(row.Bytes()[pairViewNumberWidth:])) != "" {
+				if rowHighlighted && !lastHighlighted {
+					regions = append(regions, viewRegion{row: len(rows)})
+					regionText.Reset()
+				}
+				lastHighlighted = rowHighlighted
+			}
+
+			if lastHighlighted {
+				regionText.Write(highlighted.Bytes())
+				regions[len(regions)-1].text = regionText.String()
+			}
+
+			row.WriteString(strings.Repeat(" ", textWidth-(end-start)))
+			rows = append(rows, row.String())
+		}
+	}
+
+	return rows, regions, nil
+}
+
+// sharedHashes gets the hashes of a found in b.
+func sharedHashes(a, b *Document) TermCountVector {
+	shared := make(TermCountVector)
+	for hash, count := range a.Hashes {
+		if _, ok := b.Hashes[hash]; ok {
+			shared[hash] = count
+		}
+	}
+
+	return shared
+}
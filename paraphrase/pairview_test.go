@@ -0,0 +1,130 @@
+package paraphrase
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestAlignRegions(t *testing.T) {
+	cases := []struct {
+		name     string
+		left     []viewRegion
+		right    []viewRegion
+		expected [][2]int
+	}{
+		{
+			name:     "same text in a different order",
+			left:     []viewRegion{{1, "foo"}, {5, "bar"}},
+			right:    []viewRegion{{2, "bar"}, {8, "foo"}},
+			expected: [][2]int{{1, 8}, {5, 2}},
+		},
+		{
+			name:     "the same text is preferred over containing it",
+			left:     []viewRegion{{0, "foo"}},
+			right:    []viewRegion{{3, "foobar"}, {7, "foo"}},
+			expected: [][2]int{{0, 7}, {-1, 3}},
+		},
+		{
+			name:     "regions containing each other are paired",
+			left:     []viewRegion{{0, "foobar"}, {4, "baz"}},
+			right:    []viewRegion{{2, "xbazx"}, {6, "bar"}},
+			expected: [][2]int{{0, 6}, {4, 2}},
+		},
+		{
+			name:     "regions are only used once",
+			left:     []viewRegion{{0, "foo"}, {3, "foo"}},
+			right:    []viewRegion{{1, "foo"}},
+			expected: [][2]int{{0, 1}, {3, -1}},
+		},
+		{
+			name:     "unmatched regions on the right come last",
+			left:     []viewRegion{{0, "foo"}},
+			right:    []viewRegion{{1, "qux"}, {4, "foo"}},
+			expected: [][2]int{{0, 4}, {-1, 1}},
+		},
+		{
+			name:     "nothing highlighted",
+			expected: nil,
+		},
+	}
+
+	for _, tc := range cases {
+		if actual := alignRegions(tc.left, tc.right); !reflect.DeepEqual(actual, tc.expected) {
+			t.Errorf("%s: expected %v got %v", tc.name, tc.expected, actual)
+		}
+	}
+}
+
+func TestPairViewLinesUpASharedBlock(t *testing.T) {
+	db, err := NewMemoryDb(NewDefaultSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	shared := "for (int i = 0; i < args.length; i++) {\n" +
+		"    System.out.println(args[i].trim());\n" +
+		"}\n"
+
+	a, _ := db.CreateDocument("A.java", "hw", []byte("package alpha; // by the first student\n\n"+shared))
+	b, _ := db.CreateDocument("B.java", "hw", []byte("import beta.Util;\n// written by another student entirely\nclass Other {}\n"+shared))
+
+	const width = 60
+	view, err := db.NewPairView(Pair{A: a, B: b}, width)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the block starts on the third line of A and the fourth of B
+	if !reflect.DeepEqual(view.Matches, [][2]int{{2, 3}}) {
+		t.Errorf("expected the shared block to be lined up got %v", view.Matches)
+	}
+
+	ansi := regexp.MustCompile("\x1b\\[[0-9;]*m")
+	for side, rows := range [][]string{view.Left, view.Right} {
+		for i, row := range rows {
+			plain := ansi.ReplaceAllString(row, "")
+			if utf8.RuneCountInString(plain) != width {
+				t.Errorf("expected column %d row %d to be padded to %d got %q", side, i, width, plain)
+			}
+		}
+	}
+
+	if strings.Contains(view.Left[0], "\x1b") || !strings.Contains(view.Left[3], "\x1b") {
+		t.Errorf("expected only the shared block to be highlighted got %q", view.Left)
+	}
+
+	if !strings.HasPrefix(view.Right[1], "   2 // written") {
+		t.Errorf("expected rows to start with their line number got %q", view.Right[1])
+	}
+}
+
+func TestPairViewColumnWrapsLongLines(t *testing.T) {
+	db, err := NewMemoryDb(NewDefaultSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	doc, _ := db.CreateDocument("A.txt", "hw", []byte("short\n"+strings.Repeat("x", 25)+"\n\tend"))
+
+	rows, regions, err := db.pairViewColumn(doc, TermCountVector{}, 15)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"   1 short     ",
+		"   2 xxxxxxxxxx",
+		"     xxxxxxxxxx",
+		"     xxxxx     ",
+		"   3     end   ",
+	}
+
+	if !reflect.DeepEqual(rows, expected) || len(regions) != 0 {
+		t.Errorf("expected %q got %q %v", expected, rows, regions)
+	}
+}
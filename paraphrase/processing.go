@@ -20,17 +20,22 @@ func removeWhitespace(document []byte) []byte {
 	// https://github.com/golang/go/wiki/SliceTricks
 	output := make([]byte, 0, len(document))
 	for _, x := range document {
-
-		switch x {
-		case '\t', '\n', '\v', '\f', '\r', ' ', 0x85, 0xA0:
-			continue
-		default:
+		if !isWhitespace(x) {
 			output = append(output, x)
 		}
 	}
 	return output
 }
 
+func isWhitespace(x byte) bool {
+	switch x {
+	case '\t', '\n', '\v', '\f', '\r', ' ', 0x85, 0xA0:
+		return true
+	default:
+		return false
+	}
+}
+
 func fingerprintDocument(document []byte, size int) []Fingerprint {
 	fingerprintCount := len(document) - size
 
@@ -15,7 +15,7 @@ func NewFileListProducer(namespace string, reader io.Reader) (DocumentProducer,
 		scanner := bufio.NewScanner(reader)
 		for scanner.Scan() {
 			path := scanner.Text()
-			d := Document{path, namespace, readFileCallback(path)}
+			d := Document{path: path, namespace: namespace, callback: readFileCallback(path)}
 			output <- d
 		}
 	}()
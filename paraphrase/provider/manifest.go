@@ -0,0 +1,208 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ManifestCsv       = "csv"
+	ManifestJsonLines = "jsonl"
+
+	// AuthorKey is the metadata key holding the owner of a document, files
+	// with the same author are grouped together in reports.
+	AuthorKey = "author"
+
+	// tagSeparator separates tags in a single CSV cell
+	tagSeparator = ";"
+)
+
+// ManifestFormat guesses the format of a manifest from its file name.
+func ManifestFormat(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return ManifestCsv, nil
+	case ".jsonl", ".ndjson", ".json":
+		return ManifestJsonLines, nil
+	default:
+		return "", fmt.Errorf("Can't tell the format of manifest %s, use a .csv or .jsonl extension", path)
+	}
+}
+
+// NewManifestProducer reads a manifest describing the files to add. Every
+// row has a path and optionally a namespace, an author (or owner) and tags,
+// any other columns or fields become metadata. Relative paths are read from
+// baseDir but keep the path in the manifest as their document path. Rows
+// without a namespace get the default one.
+//
+// CSV manifests must have a header row, tags are separated by semicolons.
+// JSON Lines manifests have one object per line, tags may be a list and
+// metadata may also be given as an object under "metadata".
+//
+// The whole manifest is parsed before anything is produced so mistakes are
+// reported before any documents are added.
+func NewManifestProducer(defaultNamespace, format, baseDir string, reader io.Reader) (DocumentProducer, error) {
+	var rows []map[string]interface{}
+	var err error
+
+	switch format {
+	case ManifestCsv:
+		rows, err = readCsvManifest(reader)
+	case ManifestJsonLines:
+		rows, err = readJsonLinesManifest(reader)
+	default:
+		err = fmt.Errorf("Unknown manifest format %q", format)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []Document
+	for i, row := range rows {
+		doc, err := manifestDocument(row, defaultNamespace, baseDir)
+		if err != nil {
+			return nil, fmt.Errorf("Manifest row %d: %s", i+1, err)
+		}
+
+		docs = append(docs, doc)
+	}
+
+	output := make(DocumentProducer, 10)
+
+	go func() {
+		defer close(output)
+
+		for _, doc := range docs {
+			output <- doc
+		}
+	}()
+
+	return output, nil
+}
+
+func readCsvManifest(reader io.Reader) ([]map[string]interface{}, error) {
+	records, err := csv.NewReader(reader).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	header := records[0]
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+
+	var rows []map[string]interface{}
+	for _, record := range records[1:] {
+		row := make(map[string]interface{})
+
+		for i, value := range record {
+			if value == "" {
+				continue
+			}
+
+			if header[i] == "tags" {
+				row[header[i]] = strings.Split(value, tagSeparator)
+			} else {
+				row[header[i]] = value
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+func readJsonLinesManifest(reader io.Reader) ([]map[string]interface{}, error) {
+	var rows []map[string]interface{}
+
+	scanner := bufio.NewScanner(reader)
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+
+		// keep numbers like student ids as they were written
+		decoder := json.NewDecoder(bytes.NewReader(text))
+		decoder.UseNumber()
+
+		var row map[string]interface{}
+		err := decoder.Decode(&row)
+		if err != nil {
+			return nil, fmt.Errorf("Manifest line %d: %s", line, err)
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, scanner.Err()
+}
+
+// manifestDocument converts a parsed row into a document.
+func manifestDocument(row map[string]interface{}, defaultNamespace, baseDir string) (Document, error) {
+	doc := Document{namespace: defaultNamespace, metadata: make(map[string]string)}
+
+	for key, value := range row {
+		switch key {
+		case "path":
+			doc.path = fmt.Sprint(value)
+
+		case "namespace":
+			doc.namespace = fmt.Sprint(value)
+
+		case "author", "owner":
+			doc.metadata[AuthorKey] = fmt.Sprint(value)
+
+		case "tags":
+			switch tags := value.(type) {
+			case []string:
+				doc.tags = append(doc.tags, tags...)
+			case []interface{}:
+				for _, tag := range tags {
+					doc.tags = append(doc.tags, fmt.Sprint(tag))
+				}
+			default:
+				doc.tags = append(doc.tags, strings.Split(fmt.Sprint(value), tagSeparator)...)
+			}
+
+		case "metadata":
+			fields, ok := value.(map[string]interface{})
+			if !ok {
+				return doc, fmt.Errorf("metadata must be an object")
+			}
+
+			for k, v := range fields {
+				doc.metadata[k] = fmt.Sprint(v)
+			}
+
+		default:
+			if value != nil {
+				doc.metadata[key] = fmt.Sprint(value)
+			}
+		}
+	}
+
+	if doc.path == "" {
+		return doc, fmt.Errorf("missing path")
+	}
+
+	source := doc.path
+	if !filepath.IsAbs(source) {
+		source = filepath.Join(baseDir, source)
+	}
+	doc.callback = readFileCallback(source)
+
+	return doc, nil
+}
@@ -0,0 +1,174 @@
+package provider
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// manifestDoc is what a produced document is compared on.
+type manifestDoc struct {
+	Path      string
+	Namespace string
+	Metadata  map[string]string
+	Tags      []string
+	Body      string
+}
+
+func TestNewManifestProducer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "paraphrasemanifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "jdoe"), 0700)
+	ioutil.WriteFile(filepath.Join(dir, "jdoe", "Main.java"), []byte("jdoe's main"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "Util.java"), []byte("shared util"), 0644)
+
+	absolute := filepath.Join(dir, "Util.java")
+
+	cases := []struct {
+		name     string
+		format   string
+		manifest string
+		expected []manifestDoc
+		err      string
+	}{
+		{
+			name:   "csv with a header, tags and metadata",
+			format: ManifestCsv,
+			manifest: "path, author ,section,tags\n" +
+				"jdoe/Main.java,jdoe,2,late;resubmitted\n",
+			expected: []manifestDoc{
+				{"jdoe/Main.java", "hw3", map[string]string{"author": "jdoe", "section": "2"}, []string{"late", "resubmitted"}, "jdoe's main"},
+			},
+		},
+		{
+			name:     "csv blank cells are left out",
+			format:   ManifestCsv,
+			manifest: "path,namespace,owner,tags\nUtil.java,,,\n",
+			expected: []manifestDoc{
+				{"Util.java", "hw3", map[string]string{}, nil, "shared util"},
+			},
+		},
+		{
+			name:     "csv owner is the author and namespaces override the default",
+			format:   ManifestCsv,
+			manifest: "path,namespace,owner\nUtil.java,lib,asmith\n",
+			expected: []manifestDoc{
+				{"Util.java", "lib", map[string]string{"author": "asmith"}, nil, "shared util"},
+			},
+		},
+		{
+			name:     "csv header only",
+			format:   ManifestCsv,
+			manifest: "path,author\n",
+		},
+		{
+			name:     "csv absolute paths ignore the base directory",
+			format:   ManifestCsv,
+			manifest: "path\n" + absolute + "\n",
+			expected: []manifestDoc{
+				{absolute, "hw3", map[string]string{}, nil, "shared util"},
+			},
+		},
+		{
+			name:     "csv row missing its path",
+			format:   ManifestCsv,
+			manifest: "path,author\njdoe/Main.java,jdoe\n,asmith\n",
+			err:      "Manifest row 2: missing path",
+		},
+		{
+			name:     "csv row with too many cells",
+			format:   ManifestCsv,
+			manifest: "path,author\njdoe/Main.java,jdoe,extra\n",
+			err:      "wrong number of fields",
+		},
+		{
+			name:   "jsonl with a tag list, metadata object and numbers",
+			format: ManifestJsonLines,
+			manifest: `{"path": "jdoe/Main.java", "author": "jdoe", "tags": ["late"], "metadata": {"section": 2, "id": 1234567890123}}` + "\n\n" +
+				`{"path": "Util.java", "namespace": "lib", "owner": "asmith", "tags": "a;b"}` + "\n",
+			expected: []manifestDoc{
+				{"jdoe/Main.java", "hw3", map[string]string{"author": "jdoe", "section": "2", "id": "1234567890123"}, []string{"late"}, "jdoe's main"},
+				{"Util.java", "lib", map[string]string{"author": "asmith"}, []string{"a", "b"}, "shared util"},
+			},
+		},
+		{
+			name:     "jsonl nulls are left out",
+			format:   ManifestJsonLines,
+			manifest: `{"path": "Util.java", "section": null}`,
+			expected: []manifestDoc{
+				{"Util.java", "hw3", map[string]string{}, nil, "shared util"},
+			},
+		},
+		{
+			name:     "jsonl bad line",
+			format:   ManifestJsonLines,
+			manifest: `{"path": "Util.java"}` + "\n" + `{"path": ` + "\n",
+			err:      "Manifest line 2",
+		},
+		{
+			name:     "jsonl metadata that isn't an object",
+			format:   ManifestJsonLines,
+			manifest: `{"path": "Util.java", "metadata": "section 2"}`,
+			err:      "Manifest row 1: metadata must be an object",
+		},
+		{
+			name:     "unknown format",
+			format:   "xml",
+			manifest: "<path>Util.java</path>",
+			err:      `Unknown manifest format "xml"`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			producer, err := NewManifestProducer("hw3", tc.format, dir, strings.NewReader(tc.manifest))
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected an error containing %q got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var docs []manifestDoc
+			for doc := range producer {
+				body, err := doc.Body()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				docs = append(docs, manifestDoc{doc.Path(), doc.Namespace(), doc.Metadata(), doc.Tags(), string(body)})
+			}
+
+			if !reflect.DeepEqual(docs, tc.expected) {
+				t.Errorf("expected %+v got %+v", tc.expected, docs)
+			}
+		})
+	}
+}
+
+func TestManifestFormat(t *testing.T) {
+	cases := map[string]string{
+		"submissions.csv":   ManifestCsv,
+		"SUBMISSIONS.CSV":   ManifestCsv,
+		"submissions.jsonl": ManifestJsonLines,
+		"submissions.json":  ManifestJsonLines,
+		"submissions.txt":   "",
+	}
+
+	for path, expected := range cases {
+		format, err := ManifestFormat(path)
+		if format != expected || (expected == "") != (err != nil) {
+			t.Errorf("expected %s to be %q got %q %v", path, expected, format, err)
+		}
+	}
+}
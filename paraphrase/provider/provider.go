@@ -15,6 +15,8 @@ type Document struct {
 	path      string
 	namespace string
 	callback  BodyFetcher
+	metadata  map[string]string
+	tags      []string
 }
 
 // Path Grabs the path of the document
@@ -27,6 +29,16 @@ func (d *Document) Namespace() string {
 	return d.namespace
 }
 
+// Metadata gets the key/value pairs describing the document, it may be nil.
+func (d *Document) Metadata() map[string]string {
+	return d.metadata
+}
+
+// Tags gets the document's tags, it may be nil.
+func (d *Document) Tags() []string {
+	return d.tags
+}
+
 // Body Fetches the contents of the source. This SHOULD be lazy because
 // the caller might skip processing a document
 func (d *Document) Body() ([]byte, error) {
@@ -104,6 +116,32 @@ func NewFilterWrapper(glob string, producer DocumentProducer) (DocumentProducer,
 	return filter, nil
 }
 
+// NewMetadataWrapper adds the metadata and tags to every document from the
+// producer, replacing metadata with the same keys.
+func NewMetadataWrapper(metadata map[string]string, tags []string, producer DocumentProducer) DocumentProducer {
+	wrapper := make(DocumentProducer, 10)
+
+	go func() {
+		defer close(wrapper)
+
+		for doc := range producer {
+			merged := make(map[string]string)
+			for k, v := range doc.metadata {
+				merged[k] = v
+			}
+			for k, v := range metadata {
+				merged[k] = v
+			}
+
+			doc.metadata = merged
+			doc.tags = append(append([]string{}, doc.tags...), tags...)
+			wrapper <- doc
+		}
+	}()
+
+	return wrapper
+}
+
 func GlobToRegex(glob string) (*regexp.Regexp, error) {
 	return regexp.Compile(GlobToRegexStr(glob))
 }
@@ -120,3 +158,14 @@ func GlobToRegexStr(glob string) string {
 
 	return "^" + strings.Join(split, ".*") + "$"
 }
+
+// WithBody gets a copy of the document that returns body rather than fetching
+// it again.
+func (d *Document) WithBody(body []byte) Document {
+	copy := *d
+	copy.callback = func() ([]byte, error) {
+		return body, nil
+	}
+
+	return copy
+}
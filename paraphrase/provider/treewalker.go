@@ -19,7 +19,7 @@ func generatePaths(root, namespace string, ignoreHidden bool, prefixlen int, out
 	defer close(output)
 
 	if !isDirectory(root) {
-		output <- Document{root[prefixlen:], namespace, readFileCallback(root)}
+		output <- Document{path: root[prefixlen:], namespace: namespace, callback: readFileCallback(root)}
 		return
 	}
 
@@ -39,7 +39,7 @@ func generatePaths(root, namespace string, ignoreHidden bool, prefixlen int, out
 			return nil
 		}
 
-		output <- Document{path[prefixlen:], namespace, readFileCallback(path)}
+		output <- Document{path: path[prefixlen:], namespace: namespace, callback: readFileCallback(path)}
 
 		return nil
 	})
@@ -0,0 +1,143 @@
+package provider
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// NewWatchProducer produces every file under root, then each file created or
+// changed after that until stop is closed. Files are only produced once
+// they've been closed by their writer (or moved in) so partially written
+// submissions aren't read. Changes are found through inotify where it's
+// available, otherwise root is scanned every poll interval. Polling can be
+// forced by setting forcePoll. A file may be produced more than once.
+func NewWatchProducer(root, namespace string, poll time.Duration, forcePoll bool, stop <-chan struct{}) (DocumentProducer, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(absRoot)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+
+	output := make(DocumentProducer, 10)
+
+	emit := func(path string) {
+		output <- Document{path: path[len(absRoot):], namespace: namespace, callback: readFileCallback(path)}
+	}
+
+	var watch func() error
+	if !forcePoll {
+		watch, err = newInotifyWatch(absRoot, emit, stop)
+		if err != nil {
+			log.Printf("Could not watch %s with inotify, polling every %v instead: %s\n", root, poll, err)
+		}
+	}
+
+	if watch == nil {
+		watch = newPollWatch(absRoot, poll, emit, stop)
+	}
+
+	go func() {
+		defer close(output)
+
+		err := watch()
+		if err != nil {
+			log.Printf("Stopped watching %s: %s\n", root, err)
+		}
+	}()
+
+	return output, nil
+}
+
+// isHiddenPath checks if any part of the path under root starts with a dot,
+// the tree walker skips those too.
+func isHiddenPath(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		if strings.HasPrefix(part, ".") && part != "." {
+			return true
+		}
+	}
+
+	return false
+}
+
+// walkFiles calls fn with every file under root that isn't hidden.
+func walkFiles(root string, fn func(path string, info os.FileInfo)) {
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		if path != root && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !info.IsDir() {
+			fn(path, info)
+		}
+
+		return nil
+	})
+}
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// newPollWatch scans root every interval. New and changed files are
+// produced once they look the same in two scans in a row so files still
+// being written are left for later.
+func newPollWatch(root string, interval time.Duration, emit func(path string), stop <-chan struct{}) func() error {
+	return func() error {
+		produced := make(map[string]fileState)
+		pending := make(map[string]fileState)
+
+		// the first scan produces what's already there
+		first := true
+
+		for {
+			walkFiles(root, func(path string, info os.FileInfo) {
+				state := fileState{info.Size(), info.ModTime()}
+
+				if last, ok := produced[path]; ok && last == state {
+					return
+				}
+
+				if last, ok := pending[path]; first || ok && last == state {
+					emit(path)
+					produced[path] = state
+					delete(pending, path)
+					return
+				}
+
+				pending[path] = state
+			})
+			first = false
+
+			select {
+			case <-stop:
+				return nil
+			case <-time.After(interval):
+			}
+		}
+	}
+}
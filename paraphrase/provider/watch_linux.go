@@ -0,0 +1,122 @@
+package provider
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE
+
+// newInotifyWatch produces the files under root and then waits for files to
+// be closed after writing or moved in. New directories are watched too.
+func newInotifyWatch(root string, emit func(path string), stop <-chan struct{}) (func() error, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+
+	// wrapping the descriptor lets closing it interrupt a blocked read
+	file := os.NewFile(uintptr(fd), "inotify")
+
+	dirs := make(map[int32]string)
+
+	addDir := func(dir string) error {
+		wd, err := syscall.InotifyAddWatch(fd, dir, inotifyMask)
+		if err != nil {
+			return err
+		}
+
+		dirs[int32(wd)] = dir
+		return nil
+	}
+
+	// watchTree watches every directory under dir before scanning it so
+	// nothing written in between is missed.
+	watchTree := func(dir string) error {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || !info.IsDir() {
+				return nil
+			}
+
+			if isHiddenPath(root, path) {
+				return filepath.SkipDir
+			}
+
+			return addDir(path)
+		})
+		if err != nil {
+			return err
+		}
+
+		walkFiles(dir, func(path string, info os.FileInfo) {
+			emit(path)
+		})
+
+		return nil
+	}
+
+	if err := addDir(root); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return func() error {
+		go func() {
+			<-stop
+			file.Close()
+		}()
+
+		if err := watchTree(root); err != nil {
+			return err
+		}
+
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := file.Read(buf)
+			if err != nil {
+				select {
+				case <-stop:
+					return nil
+				default:
+					return err
+				}
+			}
+
+			for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+				event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+				nameStart := offset + syscall.SizeofInotifyEvent
+				nameEnd := nameStart + int(event.Len)
+				offset = nameEnd
+
+				dir, ok := dirs[event.Wd]
+				if !ok || event.Len == 0 {
+					continue
+				}
+
+				// names are padded with NULs
+				name := string(buf[nameStart:nameEnd])
+				for len(name) > 0 && name[len(name)-1] == 0 {
+					name = name[:len(name)-1]
+				}
+
+				path := filepath.Join(dir, name)
+				if isHiddenPath(root, path) {
+					continue
+				}
+
+				switch {
+				case event.Mask&syscall.IN_ISDIR != 0:
+					if event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+						if err := watchTree(path); err != nil {
+							return err
+						}
+					}
+				case event.Mask&(syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO) != 0:
+					emit(path)
+				}
+			}
+		}
+	}, nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package provider
+
+import "errors"
+
+// newInotifyWatch is only available on Linux, other systems poll.
+func newInotifyWatch(root string, emit func(path string), stop <-chan struct{}) (func() error, error) {
+	return nil, errors.New("inotify is not supported on this system")
+}
@@ -0,0 +1,40 @@
+package provider
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPollWatchProducesExistingFilesOnTheFirstScan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "paraphrasepoll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"A.java", "B.java", "C.java"} {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644)
+	}
+
+	stop := make(chan struct{})
+	var emitted []string
+	emit := func(path string) {
+		emitted = append(emitted, path)
+		if len(emitted) == 1 {
+			// nothing may wait for a second scan
+			close(stop)
+		}
+	}
+
+	err = newPollWatch(dir, time.Hour, emit, stop)()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(emitted) != 3 {
+		t.Errorf("expected every file from the first scan got %v", emitted)
+	}
+}
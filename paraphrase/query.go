@@ -0,0 +1,673 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+package paraphrase
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/josephlewis42/paraphrase/paraphrase/provider"
+)
+
+// QuerySyntax describes the query language for command line help.
+const QuerySyntax = `QUERY LANGUAGE:
+
+Queries are made of terms like FIELD OPERATOR VALUE, for example
+path:src/**/*.java or size>10k. Terms next to each other must all match,
+"or" matches either side, "not" (or "!") inverts the term after it and
+parentheses group terms. Values with spaces or parentheses can be quoted
+with double quotes. A term without a field is a path glob.
+
+Fields:
+
+	id         the document's id
+	sha        the SHA1 of the body
+	path       the internal path of the document, looks like "/bar/bazz"
+	ns         the namespace of the document, also "namespace"
+	date       when the document was indexed, also "indexed"
+	size       the size of the body in bytes, suffixes k, m and g are allowed
+	tag        any of the document's tags
+	meta.KEY   the metadata value for KEY, documents without it only match !=
+	author     the owner of the document, short for meta.author
+
+Operators:
+
+	:          glob for text, prefix for sha, equals otherwise
+	= !=       equals or doesn't equal
+	~          matches a regular expression
+	< <= > >=  compares ids, dates and sizes
+
+Globs: * matches anything but /, ** matches anything including /, ? matches
+one character that isn't /. Path globs without a / match the file's name in
+any directory, others match from the start of the path.
+
+Dates are given as 2006-01-02, 2006-01-02T15:04 or RFC3339 in local time
+unless a zone is given. Comparisons cover the whole day or minute given so
+date>2017-03-01 means March 2nd or later.
+`
+
+// A Query matches documents.
+type Query interface {
+	Match(doc *Document) bool
+	String() string
+}
+
+// MatchAll is a query that matches every document.
+var MatchAll Query = andQuery(nil)
+
+type andQuery []Query
+
+func (q andQuery) Match(doc *Document) bool {
+	for _, sub := range q {
+		if !sub.Match(doc) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (q andQuery) String() string {
+	if len(q) == 0 {
+		return "*"
+	}
+
+	return joinQueries(q, " ")
+}
+
+type orQuery []Query
+
+func (q orQuery) Match(doc *Document) bool {
+	for _, sub := range q {
+		if sub.Match(doc) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (q orQuery) String() string {
+	return joinQueries(q, " or ")
+}
+
+type notQuery struct {
+	Query
+}
+
+func (q notQuery) Match(doc *Document) bool {
+	return !q.Query.Match(doc)
+}
+
+func (q notQuery) String() string {
+	return "not " + q.Query.String()
+}
+
+// termQuery is a single FIELD OPERATOR VALUE term.
+type termQuery struct {
+	text  string
+	match func(doc *Document) bool
+}
+
+func (q termQuery) Match(doc *Document) bool {
+	return q.match(doc)
+}
+
+func (q termQuery) String() string {
+	return q.text
+}
+
+func joinQueries(queries []Query, sep string) string {
+	parts := make([]string, len(queries))
+	for i, q := range queries {
+		parts[i] = q.String()
+		if _, ok := q.(termQuery); !ok && len(queries) > 1 {
+			parts[i] = "(" + parts[i] + ")"
+		}
+	}
+
+	return strings.Join(parts, sep)
+}
+
+// And combines queries so all of them must match.
+func And(queries ...Query) Query {
+	var out andQuery
+	for _, q := range queries {
+		if and, ok := q.(andQuery); ok {
+			out = append(out, and...)
+		} else {
+			out = append(out, q)
+		}
+	}
+
+	return out
+}
+
+// QueryFromDocument builds a query from a document's fields the same way
+// FindDocumentsLike uses them.
+func QueryFromDocument(query Document) (Query, error) {
+	var out andQuery
+
+	if query.Id != 0 {
+		out = append(out, termQuery{fmt.Sprintf("id=%d", query.Id), func(doc *Document) bool { return doc.Id == query.Id }})
+	}
+
+	if query.Sha1 != "" {
+		out = append(out, termQuery{"sha:" + query.Sha1, func(doc *Document) bool { return strings.HasPrefix(doc.Sha1, query.Sha1) }})
+	}
+
+	// globs match like the ns: and path: terms of a query
+	if query.Namespace != "" {
+		match, err := globMatcher(query.Namespace)
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, termQuery{"ns:" + strconv.Quote(query.Namespace), func(doc *Document) bool { return match(doc.Namespace) }})
+	}
+
+	if query.Path != "" {
+		match, err := pathGlobMatcher(query.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, termQuery{"path:" + strconv.Quote(query.Path), func(doc *Document) bool { return match(doc.Path) }})
+	}
+
+	return out, nil
+}
+
+// QuerySyntaxErr is returned when a query can't be parsed.
+type QuerySyntaxErr struct {
+	Query   string
+	Message string
+}
+
+func (e *QuerySyntaxErr) Error() string {
+	return fmt.Sprintf("Invalid query %q: %s", e.Query, e.Message)
+}
+
+// ParseQuery parses a query written in the language described by QuerySyntax.
+// A blank query matches everything.
+func ParseQuery(text string) (Query, error) {
+	tokens, err := lexQuery(text)
+	if err != nil {
+		return nil, &QuerySyntaxErr{text, err.Error()}
+	}
+
+	if len(tokens) == 0 {
+		return MatchAll, nil
+	}
+
+	parser := queryParser{tokens: tokens}
+
+	query, err := parser.parseOr()
+	if err != nil {
+		return nil, &QuerySyntaxErr{text, err.Error()}
+	}
+
+	if !parser.done() {
+		return nil, &QuerySyntaxErr{text, fmt.Sprintf("unexpected %q", parser.peek())}
+	}
+
+	return query, nil
+}
+
+// lexQuery splits a query into parentheses and words, words may contain
+// quoted sections.
+func lexQuery(text string) ([]string, error) {
+	var tokens []string
+
+	for i := 0; i < len(text); {
+		switch c := text[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c == '(' || c == ')':
+			tokens = append(tokens, string(c))
+			i++
+
+		default:
+			start := i
+			for i < len(text) && !strings.ContainsRune(" \t\n\r()", rune(text[i])) {
+				if text[i] != '"' {
+					i++
+					continue
+				}
+
+				// skip to the closing quote
+				i++
+				for i < len(text) && text[i] != '"' {
+					if text[i] == '\\' {
+						i++
+					}
+					i++
+				}
+
+				if i >= len(text) {
+					return nil, fmt.Errorf("unterminated quote starting at %d", start)
+				}
+				i++
+			}
+
+			tokens = append(tokens, text[start:i])
+		}
+	}
+
+	return tokens, nil
+}
+
+type queryParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *queryParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *queryParser) peek() string {
+	if p.done() {
+		return ""
+	}
+
+	return p.tokens[p.pos]
+}
+
+func (p *queryParser) isKeyword(keyword string) bool {
+	return strings.EqualFold(p.peek(), keyword)
+}
+
+func (p *queryParser) parseOr() (Query, error) {
+	var out orQuery
+
+	for {
+		and, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, and)
+
+		if !p.isKeyword("or") {
+			break
+		}
+		p.pos++
+	}
+
+	if len(out) == 1 {
+		return out[0], nil
+	}
+
+	return out, nil
+}
+
+func (p *queryParser) parseAnd() (Query, error) {
+	var out andQuery
+
+	for !p.done() && p.peek() != ")" && !p.isKeyword("or") {
+		if p.isKeyword("and") {
+			p.pos++
+			continue
+		}
+
+		query, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, query)
+	}
+
+	if len(out) == 0 {
+		if p.done() {
+			return nil, fmt.Errorf("unexpected end of query")
+		}
+		return nil, fmt.Errorf("unexpected %q", p.peek())
+	}
+
+	if len(out) == 1 {
+		return out[0], nil
+	}
+
+	return out, nil
+}
+
+func (p *queryParser) parseNot() (Query, error) {
+	switch {
+	case p.isKeyword("not") || p.peek() == "!":
+		p.pos++
+		query, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notQuery{query}, nil
+
+	case strings.HasPrefix(p.peek(), "!") && !strings.HasPrefix(p.peek(), "!="):
+		p.tokens[p.pos] = p.peek()[1:]
+		query, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notQuery{query}, nil
+
+	case p.peek() == "(":
+		p.pos++
+		query, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+
+		return query, nil
+
+	case p.done():
+		return nil, fmt.Errorf("unexpected end of query")
+
+	default:
+		token := p.peek()
+		p.pos++
+		return parseTerm(token)
+	}
+}
+
+var termRegex = regexp.MustCompile(`^([a-zA-Z0-9]+(?:\.[^!<>:~=]+)?)(!=|<=|>=|:|~|=|<|>)(.*)$`)
+
+func parseTerm(token string) (Query, error) {
+	groups := termRegex.FindStringSubmatch(token)
+	if groups == nil {
+		groups = []string{token, "path", ":", token}
+	}
+
+	field, op, value := groups[1], groups[2], groups[3]
+
+	// metadata keys are case sensitive so only the field is lowered
+	key := ""
+	if dot := strings.Index(field, "."); dot >= 0 {
+		field, key = field[:dot], field[dot+1:]
+	}
+	field = strings.ToLower(field)
+
+	if strings.HasPrefix(value, `"`) {
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return nil, fmt.Errorf("bad quoting in %s", token)
+		}
+		value = unquoted
+	}
+
+	var match func(doc *Document) bool
+	var err error
+
+	switch field {
+	case "id":
+		match, err = intTerm(op, value, func(doc *Document) int64 { return doc.Id })
+	case "sha", "sha1":
+		match, err = stringTerm(op, value, func(doc *Document) string { return doc.Sha1 }, shaPrefixMatcher)
+	case "path":
+		match, err = stringTerm(op, value, func(doc *Document) string { return doc.Path }, pathGlobMatcher)
+	case "ns", "namespace":
+		match, err = stringTerm(op, value, func(doc *Document) string { return doc.Namespace }, globMatcher)
+	case "date", "indexed":
+		match, err = dateTerm(op, value)
+	case "size":
+		match, err = sizeTerm(op, value)
+	case "tag":
+		match, err = tagTerm(op, value)
+	case "meta":
+		match, err = metaTerm(key, op, value)
+	case "author":
+		match, err = metaTerm(provider.AuthorKey, op, value)
+	default:
+		return nil, fmt.Errorf("unknown field %q", field)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", token, err)
+	}
+
+	return termQuery{token, match}, nil
+}
+
+// textMatcher builds a matcher for text, loose builds the matcher used by the
+// : operator.
+func textMatcher(op, value string, loose func(string) (func(string) bool, error)) (func(string) bool, error) {
+	switch op {
+	case "=":
+		return func(s string) bool { return s == value }, nil
+	case "!=":
+		return func(s string) bool { return s != value }, nil
+	case ":":
+		return loose(value)
+	case "~":
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	default:
+		return nil, fmt.Errorf("operator %s can't be used with text", op)
+	}
+}
+
+func stringTerm(op, value string, get func(doc *Document) string, loose func(string) (func(string) bool, error)) (func(doc *Document) bool, error) {
+	match, err := textMatcher(op, value, loose)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(doc *Document) bool { return match(get(doc)) }, nil
+}
+
+// tagTerm matches documents with any tag matching the value, or for != with
+// no tag equal to it.
+func tagTerm(op, value string) (func(doc *Document) bool, error) {
+	if op == "!=" {
+		return func(doc *Document) bool { return !doc.HasTag(value) }, nil
+	}
+
+	match, err := textMatcher(op, value, globMatcher)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(doc *Document) bool {
+		for _, tag := range doc.Tags {
+			if match(tag) {
+				return true
+			}
+		}
+
+		return false
+	}, nil
+}
+
+// metaTerm matches documents whose metadata has the key with a value
+// matching the value. Documents without the key only match !=.
+func metaTerm(key, op, value string) (func(doc *Document) bool, error) {
+	if key == "" {
+		return nil, fmt.Errorf("metadata must be queried like meta.KEY")
+	}
+
+	match, err := textMatcher(op, value, globMatcher)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(doc *Document) bool {
+		v, ok := doc.Metadata[key]
+		if !ok {
+			return op == "!="
+		}
+
+		return match(v)
+	}, nil
+}
+
+func shaPrefixMatcher(prefix string) (func(string) bool, error) {
+	prefix = strings.ToLower(prefix)
+	return func(s string) bool { return strings.HasPrefix(s, prefix) }, nil
+}
+
+func globMatcher(glob string) (func(string) bool, error) {
+	re, err := globRegex(glob)
+	if err != nil {
+		return nil, err
+	}
+
+	return re.MatchString, nil
+}
+
+// pathGlobMatcher is like globMatcher but treats paths as starting at / and
+// matches globs without a / against the file name in any directory.
+func pathGlobMatcher(glob string) (func(string) bool, error) {
+	if !strings.Contains(glob, "/") {
+		glob = "**/" + glob
+	}
+	glob = strings.TrimLeft(glob, "/")
+
+	re, err := globRegex("/" + glob)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(p string) bool { return re.MatchString(path.Clean("/" + p)) }, nil
+}
+
+func intTerm(op, value string, get func(doc *Document) int64) (func(doc *Document) bool, error) {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return compareTerm(op, func(doc *Document) int64 { return get(doc) - n })
+}
+
+// compareTerm builds a matcher from cmp, which must return a negative number
+// if the document is less than the value, 0 if it's equal or a positive
+// number if it's greater.
+func compareTerm(op string, cmp func(doc *Document) int64) (func(doc *Document) bool, error) {
+	switch op {
+	case ":", "=":
+		return func(doc *Document) bool { return cmp(doc) == 0 }, nil
+	case "!=":
+		return func(doc *Document) bool { return cmp(doc) != 0 }, nil
+	case "<":
+		return func(doc *Document) bool { return cmp(doc) < 0 }, nil
+	case "<=":
+		return func(doc *Document) bool { return cmp(doc) <= 0 }, nil
+	case ">":
+		return func(doc *Document) bool { return cmp(doc) > 0 }, nil
+	case ">=":
+		return func(doc *Document) bool { return cmp(doc) >= 0 }, nil
+	default:
+		return nil, fmt.Errorf("operator %s can't be used with numbers or dates", op)
+	}
+}
+
+var sizeSuffixes = map[string]int64{
+	"":  1,
+	"b": 1,
+	"k": 1 << 10,
+	"m": 1 << 20,
+	"g": 1 << 30,
+}
+
+func sizeTerm(op, value string) (func(doc *Document) bool, error) {
+	value = strings.ToLower(value)
+	number := strings.TrimRight(value, "bkmg")
+
+	multiplier, ok := sizeSuffixes[value[len(number):]]
+	if !ok {
+		return nil, fmt.Errorf("unknown size suffix %q", value[len(number):])
+	}
+
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return nil, err
+	}
+	size := int64(n * float64(multiplier))
+
+	return compareTerm(op, func(doc *Document) int64 { return int64(doc.Size) - size })
+}
+
+// dateLayouts are the accepted date formats along with how much time each
+// one covers.
+var dateLayouts = []struct {
+	layout    string
+	precision time.Duration
+}{
+	{time.RFC3339, time.Second},
+	{"2006-01-02T15:04:05", time.Second},
+	{"2006-01-02T15:04", time.Minute},
+	{"2006-01-02", 24 * time.Hour},
+}
+
+// ParseDate reads a date in any of the dateLayouts, returning the start and
+// end of the time it covers e.g. a day for "2017-06-01".
+func ParseDate(value string) (start, end time.Time, err error) {
+	for _, format := range dateLayouts {
+		start, err := time.ParseInLocation(format.layout, value, time.Local)
+		if err != nil {
+			continue
+		}
+
+		return start, start.Add(format.precision), nil
+	}
+
+	return start, end, fmt.Errorf("can't parse date %q", value)
+}
+
+func dateTerm(op, value string) (func(doc *Document) bool, error) {
+	start, end, err := ParseDate(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return compareTerm(op, func(doc *Document) int64 {
+		switch {
+		case doc.IndexDate.Before(start):
+			return -1
+		case doc.IndexDate.Before(end):
+			return 0
+		default:
+			return 1
+		}
+	})
+}
+
+// globRegex converts a glob to a regex matching the whole text. * doesn't
+// match /, ** does.
+func globRegex(glob string) (*regexp.Regexp, error) {
+	var out bytes.Buffer
+	out.WriteString("^")
+
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			out.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			out.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			out.WriteString("[^/]*")
+		case glob[i] == '?':
+			out.WriteString("[^/]")
+		default:
+			out.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+
+	out.WriteString("$")
+	return regexp.Compile(out.String())
+}
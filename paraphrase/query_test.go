@@ -0,0 +1,162 @@
+package paraphrase
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseQuery(t *testing.T) {
+	deadline := time.Date(2017, 3, 1, 17, 0, 0, 0, time.Local)
+
+	docs := []Document{
+		{Id: 1, Path: "/src/Main.java", Namespace: "hw3", Sha1: "abc123", Size: 2048, IndexDate: deadline.Add(time.Hour),
+			Metadata: map[string]string{"Student": "jdoe", "section": "2"}, Tags: []string{"late", "reviewed"}},
+		{Id: 2, Path: "/src/MainTest.java", Namespace: "hw3", Sha1: "abd456", Size: 100, IndexDate: deadline.Add(time.Hour),
+			Metadata: map[string]string{"Student": "asmith"}, Tags: []string{"late"}},
+		{Id: 3, Path: "/Main.java", Namespace: "hw3", Sha1: "fff000", Size: 500, IndexDate: deadline.Add(-time.Hour)},
+		{Id: 4, Path: "src/lib/util.go", Namespace: "hw3/extra", Sha1: "000fff", Size: 5 << 20, IndexDate: deadline.Add(-48 * time.Hour)},
+	}
+
+	cases := []struct {
+		query    string
+		expected []int64
+	}{
+		{"", []int64{1, 2, 3, 4}},
+		{"path:*.java", []int64{1, 2, 3}},
+		{"path:/*.java", []int64{3}},
+		{"path:src/*", []int64{1, 2}},
+		{"path:src/**", []int64{1, 2, 4}},
+		{"path:**/util.go", []int64{4}},
+		{"Main.java", []int64{1, 3}},
+		{"ns:hw3", []int64{1, 2, 3}},
+		{"ns:hw3*", []int64{1, 2, 3}},
+		{"ns:hw3**", []int64{1, 2, 3, 4}},
+		{"sha:ab", []int64{1, 2}},
+		{"sha:AB", []int64{1, 2}},
+		{"id=3", []int64{3}},
+		{"id>=3", []int64{3, 4}},
+		{"size>1k", []int64{1, 4}},
+		{"size<=500", []int64{2, 3}},
+		{"size>=5m", []int64{4}},
+		{"date>2017-03-01T17:00", []int64{1, 2}},
+		{"date:2017-03-01", []int64{1, 2, 3}},
+		{"date<2017-03-01", []int64{4}},
+		{"date>2017-03-01", nil},
+		{"ns:hw3 path:**/*.java date>2017-03-01T17:00 not path:*Test*.java", []int64{1}},
+		{"path:*Test* or size>1m", []int64{2, 4}},
+		{"not (path:*Test* or size>1m)", []int64{1, 3}},
+		{"!path:*.java", []int64{4}},
+		{`path~"^/src/Main(Test)?\\.java$" and ns="hw3"`, []int64{1, 2}},
+		{"path!=/Main.java AND ns=hw3", []int64{1, 2}},
+		{"tag:late", []int64{1, 2}},
+		{"tag:rev*", []int64{1}},
+		{"tag!=late", []int64{3, 4}},
+		{"meta.Student=jdoe", []int64{1}},
+		{"meta.Student:*", []int64{1, 2}},
+		{"meta.student:*", nil},
+		{"meta.section!=2", []int64{2, 3, 4}},
+		{`meta.Student~"^a"`, []int64{2}},
+	}
+
+	for _, tc := range cases {
+		query, err := ParseQuery(tc.query)
+		if err != nil {
+			t.Errorf("%q: %v", tc.query, err)
+			continue
+		}
+
+		var found []int64
+		for i := range docs {
+			if query.Match(&docs[i]) {
+				found = append(found, docs[i].Id)
+			}
+		}
+
+		if len(found) != len(tc.expected) {
+			t.Errorf("%q (%v): expected %v got %v", tc.query, query, tc.expected, found)
+			continue
+		}
+
+		for i := range found {
+			if found[i] != tc.expected[i] {
+				t.Errorf("%q (%v): expected %v got %v", tc.query, query, tc.expected, found)
+				break
+			}
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, query := range []string{
+		"color:red",
+		"path:a or",
+		"(path:a",
+		"path:a)",
+		"()",
+		`path:"unterminated`,
+		"size>lots",
+		"date>yesterday",
+		"path<a",
+		"id~1",
+		"meta:x",
+		"tag<a",
+	} {
+		if _, err := ParseQuery(query); err == nil {
+			t.Errorf("expected an error parsing %q", query)
+		}
+	}
+}
+
+func TestQueryFromDocumentMatchesLikeQueryTerms(t *testing.T) {
+	docs := []Document{
+		{Id: 1, Path: "/src/Main.java", Namespace: "hw3", Sha1: "abc123"},
+		{Id: 2, Path: "/src/MainTest.java", Namespace: "hw3", Sha1: "abd456"},
+		{Id: 3, Path: "/Main.java", Namespace: "hw3/extra", Sha1: "fff000"},
+		{Id: 4, Path: "src/lib/util.go", Namespace: "hw4", Sha1: "000fff"},
+	}
+
+	cases := []struct {
+		query    Document
+		equiv    string
+		expected []int64
+	}{
+		{Document{Path: "*.java"}, "path:*.java", []int64{1, 2, 3}},
+		{Document{Path: "/*.java"}, "path:/*.java", []int64{3}},
+		{Document{Path: "src/*"}, "path:src/*", []int64{1, 2}},
+		{Document{Path: "src/**"}, "path:src/**", []int64{1, 2, 4}},
+		{Document{Path: "Main?.java"}, "path:Main?.java", nil},
+		{Document{Namespace: "hw3*"}, "ns:hw3*", []int64{1, 2}},
+		{Document{Namespace: "hw3**"}, "ns:hw3**", []int64{1, 2, 3}},
+		{Document{Namespace: "hw?"}, "ns:hw?", []int64{1, 2, 4}},
+		{Document{Namespace: "hw3", Path: "*Test*", Sha1: "abd"}, "ns:hw3 path:*Test* sha:abd", []int64{2}},
+		{Document{Id: 4, Path: "**/*.go"}, "id=4 path:**/*.go", []int64{4}},
+	}
+
+	for _, tc := range cases {
+		query, err := QueryFromDocument(tc.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		equiv, err := ParseQuery(tc.equiv)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var found []int64
+		for i := range docs {
+			if query.Match(&docs[i]) {
+				found = append(found, docs[i].Id)
+			}
+
+			if query.Match(&docs[i]) != equiv.Match(&docs[i]) {
+				t.Errorf("%v: expected document %d to match like %q", query, docs[i].Id, tc.equiv)
+			}
+		}
+
+		if !reflect.DeepEqual(found, tc.expected) {
+			t.Errorf("%v: expected %v got %v", query, tc.expected, found)
+		}
+	}
+}
@@ -1,82 +1,265 @@
 package paraphrase
 
 import (
+	"encoding/json"
+	"fmt"
 	"io"
 	"log"
-	"os"
+	"regexp"
 	"strings"
 	"text/template"
 	"time"
+	"unicode/utf8"
 )
 
+const (
+	// Templates defining these are rendered once before and after the items,
+	// templates defining itemTemplate render it for each item instead of the
+	// whole template.
+	headerTemplate = "header"
+	itemTemplate   = "item"
+	footerTemplate = "footer"
+)
+
+// Summary is passed to the header and footer templates.
+type Summary struct {
+	Count     int
+	Generated time.Time
+}
+
 // Writes the documents in fashion suitable for displaying on-screen
-func FormatDocuments(w io.Writer, docs []Document, templateFormat string, shortSha bool, db *ParaphraseDb) {
+func FormatDocuments(w io.Writer, docs []Document, templateFormat string, shortSha bool, db *ParaphraseDb) error {
 	if templateFormat == "" {
 		WriteDocuments(w, docs, shortSha)
-		return
+		return nil
+	}
+
+	renderer, err := NewRenderer(templateFormat, db)
+	if err != nil {
+		return err
+	}
+
+	return renderer.RenderDocuments(w, docs)
+}
+
+func FormatSearchResults(w io.Writer, docs []SearchResult, templateFormat string, db *ParaphraseDb) error {
+	renderer, err := NewRenderer(templateFormat, db)
+	if err != nil {
+		return err
+	}
+
+	return renderer.RenderSearchResults(w, docs)
+}
+
+// RenderDocument renders a single document with the template.
+func RenderDocument(w io.Writer, templateFormat string, doc *Document, db *ParaphraseDb) error {
+	renderer, err := NewRenderer(templateFormat, db)
+	if err != nil {
+		return err
 	}
 
-	for _, doc := range docs {
-		err := RenderDocument(templateFormat, &doc, db, nil)
+	return renderer.RenderDocuments(w, []Document{*doc})
+}
+
+// Renderer renders documents with a template that's parsed once. Templates
+// may define "header", "item" and "footer" sections, see FormattingOptions in
+// the find command for the functions available.
+//
+// A Renderer must not be used by more than one goroutine at a time because
+// the document functions are rebound for every item.
+type Renderer struct {
+	// Markup is how {{snippet}} marks matches: plain, ansi or html.
+	Markup string
+	// SnippetContext is the number of lines {{snippet}} shows around matches.
+	SnippetContext int
+	// SnippetLimit is the most windows {{snippet}} shows for a document.
+	SnippetLimit int
+
+	tmpl *template.Template
+	db   *ParaphraseDb
+}
+
+// NewRenderer parses the template.
+func NewRenderer(templateFormat string, db *ParaphraseDb) (*Renderer, error) {
+	r := &Renderer{
+		Markup:         MarkupPlain,
+		SnippetContext: DefaultSnippetContext,
+		SnippetLimit:   DefaultSnippetLimit,
+		db:             db,
+	}
 
+	tmpl, err := template.New("DocumentTemplate").Funcs(r.documentFuncs(SearchResult{Doc: &Document{}})).Funcs(templateFuncs).Parse(templateFormat)
+	if err != nil {
+		return nil, err
+	}
+	r.tmpl = tmpl
+
+	return r, nil
+}
+
+// RenderDocuments writes every document to w. A document that fails to
+// render is logged and skipped, the error returned says how many failed.
+func (r *Renderer) RenderDocuments(w io.Writer, docs []Document) error {
+	return r.render(w, len(docs), func(i int) SearchResult {
+		return SearchResult{Doc: &docs[i]}
+	})
+}
+
+// RenderSearchResults writes every result to w like RenderDocuments.
+func (r *Renderer) RenderSearchResults(w io.Writer, results []SearchResult) error {
+	return r.render(w, len(results), func(i int) SearchResult {
+		return results[i]
+	})
+}
+
+func (r *Renderer) render(w io.Writer, count int, item func(i int) SearchResult) error {
+	summary := Summary{Count: count, Generated: time.Now()}
+
+	// header and footer see an empty document
+	r.tmpl.Funcs(r.documentFuncs(SearchResult{Doc: &Document{}}))
+
+	if header := r.tmpl.Lookup(headerTemplate); header != nil {
+		err := header.Execute(w, summary)
 		if err != nil {
-			log.Println(err)
-			break
+			return err
 		}
+	}
 
+	body := r.tmpl
+	if named := r.tmpl.Lookup(itemTemplate); named != nil {
+		body = named
 	}
-}
 
-func FormatSearchResults(w io.Writer, docs []SearchResult, templateFormat string, db *ParaphraseDb) {
-	for _, doc := range docs {
-		extraFuncs := template.FuncMap{
-			"similarity": func() float64 { return doc.Similarity() },
+	failures := 0
+	for i := 0; i < count; i++ {
+		result := item(i)
+		r.tmpl.Funcs(r.documentFuncs(result))
+
+		err := body.Execute(w, result.Doc)
+		if err != nil {
+			log.Printf("Error rendering %v: %s\n", result.Doc.Id, err)
+			failures++
 		}
+	}
 
-		err := RenderDocument(templateFormat, doc.Doc, db, extraFuncs)
+	r.tmpl.Funcs(r.documentFuncs(SearchResult{Doc: &Document{}}))
 
+	if footer := r.tmpl.Lookup(footerTemplate); footer != nil {
+		err := footer.Execute(w, summary)
 		if err != nil {
-			log.Println(err)
-			break
+			return err
 		}
+	}
 
+	if failures > 0 {
+		return fmt.Errorf("%d of %d documents could not be rendered", failures, count)
 	}
+
+	return nil
 }
 
-func RenderDocument(templateFormat string, doc *Document, db *ParaphraseDb, extraFuncs template.FuncMap) error {
+// documentFuncs are the functions describing the document being rendered.
+// Documents that aren't search results have no query.
+func (r *Renderer) documentFuncs(result SearchResult) template.FuncMap {
+	doc := result.Doc
+	var body []byte
+
+	loadBody := func() ([]byte, error) {
+		if body == nil {
+			data, err := r.db.FindDocumentDataById(doc.Id)
+			if err != nil {
+				return nil, err
+			}
+			body = data.Body
+		}
+		return body, nil
+	}
+
+	return template.FuncMap{
+		"body": func() (string, error) {
+			text, err := loadBody()
+			return string(text), err
+		},
+		"snippet": func(markup ...string) (string, error) {
+			if result.Query == nil {
+				return "", nil
+			}
 
-	funcMap := template.FuncMap{
-		// The name "title" is what the function will be called in the template text.
-		"body":      func() string { doc, _ := db.FindDocumentDataById(doc.Id); return string(doc.Body) },
+			text, err := loadBody()
+			if err != nil {
+				return "", err
+			}
+
+			snippets := r.db.Snippets(text, *result.Query, r.SnippetContext, r.SnippetLimit)
+
+			if len(markup) > 0 {
+				return MarkSnippets(snippets, markup[0])
+			}
+			return MarkSnippets(snippets, r.Markup)
+		},
 		"path":      func() string { return doc.Path },
 		"namespace": func() string { return doc.Namespace },
 		"id":        func() int64 { return doc.Id },
 		"sha1":      func() string { return doc.Sha1 },
 		"date":      func() time.Time { return doc.IndexDate },
 		"hashes":    func() map[uint64]int16 { return doc.Hashes },
-
-		"crlf": func() string { return "\r\n" },
-		"tab":  func() string { return "\t" },
-
-		"head":   headFunc,
-		"prefix": prefixLines,
-		"first":  firstFunc,
-		"repeat": repeatText,
+		"size":      func() int { return doc.Size },
+		"meta":      func(key string) string { return doc.Metadata[key] },
+		"tags":      func() []string { return doc.Tags },
+		"author":    func() string { return doc.Author() },
+		"similarity": func() float64 {
+			if result.Query == nil {
+				return 0
+			}
+			return result.Similarity()
+		},
+		"cursor": func() string {
+			if result.Query == nil {
+				return ""
+			}
+			return result.Cursor()
+		},
+		"verdict": func() string {
+			if result.Verdict == nil {
+				return ""
+			}
+			return string(result.Verdict.Verdict)
+		},
+		"reviewer": func() string {
+			if result.Verdict == nil {
+				return ""
+			}
+			return result.Verdict.Reviewer
+		},
+		"notes": func() string {
+			if result.Verdict == nil {
+				return ""
+			}
+			return result.Verdict.Notes
+		},
 	}
+}
 
-	if extraFuncs != nil {
-		for k, v := range extraFuncs {
-			funcMap[k] = v
-		}
-	}
+// templateFuncs are the functions that don't depend on the document.
+var templateFuncs = template.FuncMap{
+	"crlf": func() string { return "\r\n" },
+	"tab":  func() string { return "\t" },
 
-	tmpl, err := template.New("DocumentTemplate").Funcs(funcMap).Parse(templateFormat)
-	if err != nil {
-		return err
-	}
-
-	// Run the template to verify the output.
-	return tmpl.Execute(os.Stdout, doc)
+	"head":        headFunc,
+	"tail":        tailFunc,
+	"prefix":      prefixLines,
+	"first":       firstFunc,
+	"repeat":      repeatText,
+	"join":        joinFunc,
+	"words":       wordsFunc,
+	"truncate":    truncateFunc,
+	"numberLines": numberLines,
+	"highlight":   highlightFunc,
+	"formatDate":  formatDate,
+	"json":        jsonFunc,
+	"trim":        strings.TrimSpace,
+	"upper":       strings.ToUpper,
+	"lower":       strings.ToLower,
 }
 
 // prefix all lines with the given prefix.
@@ -95,6 +278,82 @@ func headFunc(lineCount int, text string) string {
 	return strings.Join(lines[:min(lineCount, len(lines))], "\n")
 }
 
+// gets the last lineCount number of lines of the given text.
+func tailFunc(lineCount int, text string) string {
+	if lineCount <= 0 {
+		return ""
+	}
+
+	lines := strings.Split(text, "\n")
+
+	return strings.Join(lines[len(lines)-min(lineCount, len(lines)):], "\n")
+}
+
+var wordRegex = regexp.MustCompile(`\S+`)
+
+// gets the text up to the end of the nth word.
+func wordsFunc(n int, text string) string {
+	if n <= 0 {
+		return ""
+	}
+
+	words := wordRegex.FindAllStringIndex(text, n)
+	if len(words) < n {
+		return text
+	}
+
+	return text[:words[n-1][1]]
+}
+
+// cuts the text to n characters, ending with an ellipsis if anything was cut.
+func truncateFunc(n int, text string) string {
+	if utf8.RuneCountInString(text) <= n {
+		return text
+	}
+
+	if n <= 0 {
+		return ""
+	}
+
+	runes := []rune(text)
+	return string(runes[:n-1]) + "…"
+}
+
+// prefixes each line with its line number.
+func numberLines(text string) string {
+	lines := strings.Split(text, "\n")
+	width := len(fmt.Sprint(len(lines)))
+
+	for i, line := range lines {
+		lines[i] = fmt.Sprintf("%*d  %s", width, i+1, line)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// surrounds every match of the regular expression with before and after.
+func highlightFunc(pattern, before, after, text string) (string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", err
+	}
+
+	return re.ReplaceAllStringFunc(text, func(match string) string {
+		return before + match + after
+	}), nil
+}
+
+// formats the time with a Go layout like "2006-01-02".
+func formatDate(layout string, t time.Time) string {
+	return t.Format(layout)
+}
+
+// encodes the value as JSON.
+func jsonFunc(v interface{}) (string, error) {
+	out, err := json.Marshal(v)
+	return string(out), err
+}
+
 // gets the first N bytes of the given text
 func firstFunc(n int, text string) string {
 	if n <= 0 {
@@ -104,6 +363,11 @@ func firstFunc(n int, text string) string {
 	return text[:min(n, len(text))]
 }
 
+// joins the items with the separator
+func joinFunc(sep string, items []string) string {
+	return strings.Join(items, sep)
+}
+
 // repeats the text n times
 func repeatText(n int, text string) string {
 	out := ""
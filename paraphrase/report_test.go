@@ -0,0 +1,112 @@
+package paraphrase
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTemplateTextFuncs(t *testing.T) {
+	cases := []struct {
+		name     string
+		fn       func(int, string) string
+		n        int
+		text     string
+		expected string
+	}{
+		{"tail", tailFunc, 2, "a\nb\nc", "b\nc"},
+		{"tail more than there are", tailFunc, 5, "a\nb", "a\nb"},
+		{"tail zero", tailFunc, 0, "a\nb", ""},
+		{"tail negative", tailFunc, -1, "a\nb", ""},
+		{"tail multi-byte", tailFunc, 1, "héllo\nwörld", "wörld"},
+
+		{"truncate", truncateFunc, 5, "Hello, world!", "Hell…"},
+		{"truncate fits", truncateFunc, 13, "Hello, world!", "Hello, world!"},
+		{"truncate one", truncateFunc, 1, "Hello", "…"},
+		{"truncate zero", truncateFunc, 0, "Hello", ""},
+		{"truncate negative", truncateFunc, -3, "Hello", ""},
+		{"truncate empty", truncateFunc, 0, "", ""},
+		{"truncate counts runes", truncateFunc, 4, "héllö wörld", "hél…"},
+		{"truncate multi-byte fits", truncateFunc, 5, "héllö", "héllö"},
+
+		{"words", wordsFunc, 2, "the quick  brown fox", "the quick"},
+		{"words keeps leading space", wordsFunc, 1, "  the quick", "  the"},
+		{"words more than there are", wordsFunc, 9, "the quick", "the quick"},
+		{"words zero", wordsFunc, 0, "the quick", ""},
+		{"words negative", wordsFunc, -2, "the quick", ""},
+		{"words multi-byte", wordsFunc, 2, "größe über alles", "größe über"},
+	}
+
+	for _, tc := range cases {
+		if actual := tc.fn(tc.n, tc.text); actual != tc.expected {
+			t.Errorf("%s: expected %q got %q", tc.name, tc.expected, actual)
+		}
+	}
+}
+
+func TestHighlightFunc(t *testing.T) {
+	cases := []struct {
+		pattern  string
+		text     string
+		expected string
+	}{
+		{"world", "Hello, world!", "Hello, [world]!"},
+		{"o", "foo", "f[o][o]"},
+		{"x", "foo", "foo"},
+		{"ü+", "grüße üü", "gr[ü]ße [üü]"},
+		{"(?i)HELLO", "hello", "[hello]"},
+	}
+
+	for _, tc := range cases {
+		actual, err := highlightFunc(tc.pattern, "[", "]", tc.text)
+		if err != nil || actual != tc.expected {
+			t.Errorf("highlight %q in %q: expected %q got %q %v", tc.pattern, tc.text, tc.expected, actual, err)
+		}
+	}
+
+	if _, err := highlightFunc("(", "[", "]", "text"); err == nil {
+		t.Error("expected an invalid pattern to fail")
+	}
+}
+
+func TestRendererWritesSectionsToTheWriter(t *testing.T) {
+	db, err := NewMemoryDb(NewDefaultSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	a, _ := db.CreateDocument("A.java", "hw", []byte(testBodyA))
+	b, _ := db.CreateDocument("B.java", "hw", []byte(testBodyB))
+
+	format := `{{define "header"}}{{.Count}} documents
+{{end}}{{define "item"}}{{path}} {{body | words 2}}
+{{end}}{{define "footer"}}done{{end}}`
+
+	renderer, err := NewRenderer(format, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err = renderer.RenderDocuments(&out, []Document{*a, *b})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "2 documents\nA.java public static\nB.java public static\ndone"
+	if out.String() != expected {
+		t.Errorf("expected %q got %q", expected, out.String())
+	}
+
+	// without an item section the whole template is the item
+	renderer, err = NewRenderer("{{id}}", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out.Reset()
+	renderer.RenderDocuments(&out, []Document{{Id: 7}, {Id: 8}})
+	if out.String() != "78" {
+		t.Errorf("expected each id got %q", out.String())
+	}
+}
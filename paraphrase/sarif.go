@@ -0,0 +1,106 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+package paraphrase
+
+import "fmt"
+
+// Types for the subset of SARIF 2.1.0 needed to report findings.
+
+const (
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	sarifVersion = "2.1.0"
+	sarifRuleId  = "protected-content"
+)
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name    string      `json:"name"`
+	Version string      `json:"version,omitempty"`
+	Rules   []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	Id               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleId    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	Uri string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+	EndLine   int `json:"endLine"`
+}
+
+func newSarifLog(findings []Finding, toolVersion string) *sarifLog {
+	run := sarifRun{
+		Tool: sarifTool{sarifDriver{
+			Name:    "paraphrase",
+			Version: toolVersion,
+			Rules: []sarifRule{{
+				Id:               sarifRuleId,
+				ShortDescription: sarifMessage{"File contains content from a protected document"},
+			}},
+		}},
+		Results: []sarifResult{},
+	}
+
+	for _, f := range findings {
+		result := sarifResult{
+			RuleId: sarifRuleId,
+			Level:  "error",
+			Message: sarifMessage{fmt.Sprintf("%.0f%% of this file matches %v in namespace %v (document %v)",
+				f.Containment*100, f.Match.Path, f.Match.Namespace, f.Match.Id)},
+		}
+
+		for _, region := range f.Regions {
+			result.Locations = append(result.Locations, sarifLocation{sarifPhysicalLocation{
+				ArtifactLocation: sarifArtifactLocation{f.Path},
+				Region:           &sarifRegion{region.StartLine, region.EndLine},
+			}})
+		}
+
+		if len(result.Locations) == 0 {
+			result.Locations = []sarifLocation{{sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{f.Path}}}}
+		}
+
+		run.Results = append(run.Results, result)
+	}
+
+	return &sarifLog{sarifSchema, sarifVersion, []sarifRun{run}}
+}
@@ -0,0 +1,231 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+package paraphrase
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/template"
+	"time"
+
+	"github.com/bradfitz/slice"
+)
+
+const (
+	MarkupPlain = "plain"
+	MarkupAnsi  = "ansi"
+	MarkupHtml  = "html"
+
+	// DefaultSnippetContext is the number of lines shown around a match.
+	DefaultSnippetContext = 2
+	// DefaultSnippetLimit is the number of snippets shown for a document.
+	DefaultSnippetLimit = 3
+
+	snippetSeparator = "\n...\n"
+)
+
+// Snippet is a window of a document's body around text matching a query.
+type Snippet struct {
+	// StartLine and EndLine are the 1-indexed lines of the body in the window.
+	StartLine int    `json:"startLine"`
+	EndLine   int    `json:"endLine"`
+	Text      string `json:"text"`
+	// Matches are the matching parts of Text, relative to its start.
+	Matches []Span `json:"matches"`
+}
+
+// Snippets finds up to limit windows of body containing the largest regions
+// matching hashes, with context lines around each. Windows that overlap are
+// joined and the snippets are returned in the order they appear in body.
+func (p *ParaphraseDb) Snippets(body []byte, hashes TermCountVector, context, limit int) []Snippet {
+	spans := p.MatchingSpans(body, hashes)
+	regions := Regions(body, spans)
+
+	slice.Sort(regions, func(i, j int) bool {
+		return regions[i].End-regions[i].Start > regions[j].End-regions[j].Start
+	})
+
+	if limit >= 0 && len(regions) > limit {
+		regions = regions[:limit]
+	}
+
+	// lineStarts[i] is the offset of line i+1
+	lineStarts := []int{0}
+	for i, x := range body {
+		if x == '\n' {
+			lineStarts = append(lineStarts, i+1)
+		}
+	}
+
+	var windows [][2]int
+	for _, region := range regions {
+		start := region.StartLine - context
+		if start < 1 {
+			start = 1
+		}
+
+		end := region.EndLine + context
+		if end > len(lineStarts) {
+			end = len(lineStarts)
+		}
+
+		windows = append(windows, [2]int{start, end})
+	}
+
+	slice.Sort(windows, func(i, j int) bool {
+		return windows[i][0] < windows[j][0]
+	})
+
+	var snippets []Snippet
+	for _, window := range windows {
+		if n := len(snippets); n > 0 && window[0] <= snippets[n-1].EndLine+1 {
+			if window[1] > snippets[n-1].EndLine {
+				snippets[n-1].EndLine = window[1]
+			}
+			continue
+		}
+
+		snippets = append(snippets, Snippet{StartLine: window[0], EndLine: window[1]})
+	}
+
+	for i := range snippets {
+		snippet := &snippets[i]
+
+		start := lineStarts[snippet.StartLine-1]
+		end := len(body)
+		if snippet.EndLine < len(lineStarts) {
+			// leave off the newline ending the window
+			end = lineStarts[snippet.EndLine] - 1
+		}
+
+		snippet.Text = string(body[start:end])
+
+		for _, span := range spans {
+			if span.End <= start || span.Start >= end {
+				continue
+			}
+
+			clipped := Span{span.Start - start, span.End - start}
+			if clipped.Start < 0 {
+				clipped.Start = 0
+			}
+			if clipped.End > end-start {
+				clipped.End = end - start
+			}
+
+			snippet.Matches = append(snippet.Matches, clipped)
+		}
+	}
+
+	return snippets
+}
+
+// Marked gets the text of the snippet with the matches marked up for a
+// terminal (ansi), a web page (html) or anything else (plain). HTML markup
+// also escapes the text.
+func (s *Snippet) Marked(markup string) (string, error) {
+	before, after, escape, err := markupTags(markup)
+	if err != nil {
+		return "", err
+	}
+
+	var out bytes.Buffer
+
+	last := 0
+	for _, match := range s.Matches {
+		out.WriteString(escape(s.Text[last:match.Start]))
+		out.WriteString(before)
+		out.WriteString(escape(s.Text[match.Start:match.End]))
+		out.WriteString(after)
+		last = match.End
+	}
+	out.WriteString(escape(s.Text[last:]))
+
+	return out.String(), nil
+}
+
+// MarkSnippets marks up every snippet and joins them with a line of "...".
+func MarkSnippets(snippets []Snippet, markup string) (string, error) {
+	var out bytes.Buffer
+
+	for i := range snippets {
+		if i > 0 {
+			out.WriteString(snippetSeparator)
+		}
+
+		text, err := snippets[i].Marked(markup)
+		if err != nil {
+			return "", err
+		}
+
+		out.WriteString(text)
+	}
+
+	return out.String(), nil
+}
+
+type searchResultJson struct {
+	Id         int64        `json:"id"`
+	Namespace  string       `json:"namespace"`
+	Path       string       `json:"path"`
+	Sha1       string       `json:"sha1"`
+	Similarity float64      `json:"similarity"`
+	Cursor     string       `json:"cursor"`
+	Verdict    *verdictJson `json:"verdict,omitempty"`
+	Snippets   []Snippet    `json:"snippets"`
+}
+
+type verdictJson struct {
+	Verdict  Verdict   `json:"verdict"`
+	Reviewer string    `json:"reviewer"`
+	Date     time.Time `json:"date"`
+	Notes    string    `json:"notes"`
+}
+
+// WriteSearchResultsJson writes the results as a JSON array, each with the
+// snippets of its body matching the query.
+func (p *ParaphraseDb) WriteSearchResultsJson(w io.Writer, results []SearchResult, context, limit int) error {
+	out := make([]searchResultJson, len(results))
+
+	for i, result := range results {
+		data, err := p.FindDocumentDataById(result.Doc.Id)
+		if err != nil {
+			return err
+		}
+
+		out[i] = searchResultJson{
+			Id:         result.Doc.Id,
+			Namespace:  result.Doc.Namespace,
+			Path:       result.Doc.Path,
+			Sha1:       result.Doc.Sha1,
+			Similarity: result.Similarity(),
+			Cursor:     result.Cursor(),
+			Snippets:   p.Snippets(data.Body, *result.Query, context, limit),
+		}
+
+		if v := result.Verdict; v != nil {
+			out[i].Verdict = &verdictJson{v.Verdict, v.Reviewer, v.Date, v.Notes}
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+func markupTags(markup string) (before, after string, escape func(string) string, err error) {
+	unchanged := func(text string) string { return text }
+
+	switch markup {
+	case MarkupPlain, "":
+		return "[[", "]]", unchanged, nil
+	case MarkupAnsi:
+		return "\x1b[1;31m", "\x1b[0m", unchanged, nil
+	case MarkupHtml:
+		return "<mark>", "</mark>", template.HTMLEscapeString, nil
+	default:
+		return "", "", nil, fmt.Errorf("Unknown markup %q, expected plain, ansi or html", markup)
+	}
+}
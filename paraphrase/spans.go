@@ -0,0 +1,113 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+package paraphrase
+
+import (
+	"bytes"
+	"hash/fnv"
+
+	"github.com/bradfitz/slice"
+)
+
+// Span is a half-open range [Start, End) of bytes in a document's original
+// body.
+type Span struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// Region is a span along with the 1-indexed lines it starts and ends on.
+type Region struct {
+	Span
+	StartLine int `json:"startLine"`
+	EndLine   int `json:"endLine"`
+}
+
+// MatchingSpans finds the parts of body whose fingerprints are in hashes.
+// Overlapping and adjacent spans are merged and returned in order.
+func (p *ParaphraseDb) MatchingSpans(body []byte, hashes TermCountVector) []Span {
+	var spans []Span
+
+	// offsets[i] is the position in body of the ith non-whitespace byte
+	norm := make([]byte, 0, len(body))
+	offsets := make([]int, 0, len(body))
+	for i, x := range body {
+		if !isWhitespace(x) {
+			norm = append(norm, x)
+			offsets = append(offsets, i)
+		}
+	}
+
+	size := p.settings.FingerprintSize
+	for i := 0; i+size <= len(norm); i++ {
+		hash := fnv.New64()
+		hash.Write(norm[i : i+size])
+
+		if _, ok := hashes[hash.Sum64()]; ok {
+			spans = append(spans, Span{offsets[i], offsets[i+size-1] + 1})
+		}
+	}
+
+	return mergeSpans(spans)
+}
+
+// mergeSpans combines overlapping and touching spans.
+func mergeSpans(spans []Span) []Span {
+	if len(spans) == 0 {
+		return spans
+	}
+
+	slice.Sort(spans, func(i, j int) bool {
+		return spans[i].Start < spans[j].Start
+	})
+
+	merged := []Span{spans[0]}
+	for _, span := range spans[1:] {
+		last := &merged[len(merged)-1]
+
+		if span.Start <= last.End {
+			if span.End > last.End {
+				last.End = span.End
+			}
+			continue
+		}
+
+		merged = append(merged, span)
+	}
+
+	return merged
+}
+
+// Regions converts spans of body into regions with line numbers. Regions on
+// the same or adjacent lines are joined because fingerprints only sample the
+// text, leaving small gaps between spans of a copied block.
+func Regions(body []byte, spans []Span) []Region {
+	var regions []Region
+
+	for _, span := range spans {
+		region := Region{
+			Span:      span,
+			StartLine: lineOf(body, span.Start),
+			EndLine:   lineOf(body, span.End-1),
+		}
+
+		if n := len(regions); n > 0 && region.StartLine <= regions[n-1].EndLine+1 {
+			regions[n-1].End = region.End
+			regions[n-1].EndLine = region.EndLine
+			continue
+		}
+
+		regions = append(regions, region)
+	}
+
+	return regions
+}
+
+// lineOf gets the 1-indexed line the byte at offset is on.
+func lineOf(body []byte, offset int) int {
+	if offset > len(body) {
+		offset = len(body)
+	}
+
+	return bytes.Count(body[:offset], []byte("\n")) + 1
+}
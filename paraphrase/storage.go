@@ -0,0 +1,77 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+package paraphrase
+
+import (
+	"errors"
+	"io"
+)
+
+var (
+	NotFoundErr    = errors.New("not found")
+	UnsupportedErr = errors.New("This operation isn't supported by the database's storage")
+)
+
+// Storage is where a ParaphraseDb keeps its documents, bodies, postings,
+// settings, changelog, verdicts and hooks. Implementations must be safe to
+// use from multiple goroutines. Lookups of single records return NotFoundErr if the record
+// doesn't exist.
+type Storage interface {
+	// SaveDocument atomically saves (or replaces) a document, its body and a
+	// posting for each of its hashes.
+	SaveDocument(doc *Document, data *DocumentData) error
+	// DeleteDocument atomically removes a document, its body and its postings.
+	DeleteDocument(id int64) error
+	Document(id int64) (*Document, error)
+	DocumentData(id int64) (*DocumentData, error)
+	// DocumentHeader gets a document's header without loading the rest.
+	DocumentHeader(id int64) (*DocumentHeader, error)
+	DocumentsBySha1(sha1 string) ([]Document, error)
+	CountDocuments() (int, error)
+	EachDocument(fn func(doc *Document) error) error
+	EachDocumentData(fn func(data *DocumentData) error) error
+
+	// Postings gets every entry for the hash, it's not an error if there are
+	// none.
+	Postings(hash uint64) ([]IndexEntry, error)
+	DeletePosting(entry IndexEntry) error
+	CountHashes() (int, error)
+	EachPosting(fn func(entry *IndexEntry) error) error
+
+	// Settings returns SettingsNotDefinedErr if none have been saved.
+	Settings() (Settings, error)
+	SaveSettings(settings Settings) error
+	SchemaVersion() (int, error)
+
+	// AppendChange numbers the change and chains it to the last one with
+	// chainChange before saving it.
+	AppendChange(change *ChangeLogEntry) error
+	EachChange(fn func(change *ChangeLogEntry) error) error
+
+	// SaveVerdict saves (or replaces) the verdict on a pair of documents.
+	SaveVerdict(verdict *PairVerdict) error
+	// Verdict gets the verdict on a pair by PairKey, NotFoundErr if there
+	// isn't one.
+	Verdict(id string) (*PairVerdict, error)
+	EachVerdict(fn func(verdict *PairVerdict) error) error
+
+	// SaveHook saves a hook, giving it the next id if it has none.
+	SaveHook(hook *Hook) error
+	// DeleteHook returns NotFoundErr if there isn't a hook with the id.
+	DeleteHook(id int) error
+	EachHook(fn func(hook *Hook) error) error
+
+	Close() error
+}
+
+// snapshotter is implemented by storage that can write a consistent copy of
+// itself while it's in use.
+type snapshotter interface {
+	WriteSnapshot(w io.Writer) error
+}
+
+// migrator is implemented by storage with a versioned on-disk schema.
+type migrator interface {
+	migrate() error
+	initSchema() error
+}
@@ -0,0 +1,283 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+package paraphrase
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/bradhe/stopwatch"
+	"github.com/josephlewis42/paraphrase/paraphrase/provider"
+)
+
+const (
+	// RemovedTag marks documents whose files were gone the last time their
+	// namespace was synced, they're kept as a record of what was there.
+	RemovedTag = "removed"
+	// RemovedAtKey is the metadata key holding when a document was removed.
+	RemovedAtKey = "removed_at"
+)
+
+// SyncOptions controls how a namespace is synced.
+type SyncOptions struct {
+	// Match is a glob limiting the paths synced, documents with other paths
+	// are left alone.
+	Match string
+	// Delete deletes documents whose files are gone or have changed rather
+	// than tagging them with RemovedTag.
+	Delete bool
+	// DryRun works out what would change without changing anything.
+	DryRun bool
+}
+
+// SyncResult lists the paths a sync changed.
+type SyncResult struct {
+	Added   []string
+	Updated []string
+	// Restored are removed documents whose files came back unchanged.
+	Restored  []string
+	Removed   []string
+	Unchanged int
+	// Failed are files that couldn't be read or indexed, their documents are
+	// left as they were.
+	Failed []string
+}
+
+// Sync makes the documents in the namespace match the files from the
+// producer by path and SHA1. New files are added, changed ones are indexed
+// again and documents whose files are gone are removed, see SyncOptions.
+// Unchanged files are only read to check their SHA1 so syncing a large tree
+// that has barely changed is quick.
+//
+// Documents are added and removed as the files are read, each with its own
+// changelog entry, and the sync's entry lists every document it changed. If
+// the sync stops part way its entry is still written, marked as failed.
+func (p *ParaphraseDb) Sync(namespace string, producer provider.DocumentProducer, options SyncOptions) (*SyncResult, error) {
+	start := stopwatch.Start()
+
+	var match *regexp.Regexp
+	if options.Match != "" {
+		var err error
+		match, err = provider.GlobToRegex(options.Match)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	inScope := func(path string) bool {
+		return match == nil || match.MatchString(path)
+	}
+
+	stored, err := p.documentsInNamespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	// every version of each path, removed or not
+	versions := make(map[string][]Document)
+	for _, doc := range stored {
+		if inScope(doc.Path) {
+			versions[doc.Path] = append(versions[doc.Path], doc)
+		}
+	}
+
+	var result SyncResult
+	var changed []int64
+	seen := make(map[string]bool)
+
+	logSync := func(failed bool, format string, vargs ...interface{}) {
+		details := ChangeLogEntry{
+			Operation:  OpSync,
+			Documents:  changed,
+			Namespaces: []string{namespace},
+			Duration:   elapsed(stopwatch.Stop(start)),
+			Failed:     failed,
+		}
+		p.logChange(details, format, vargs...)
+	}
+
+	// documents changed before a sync stops still have their own entries,
+	// the sync's is written too so they aren't left without one
+	abort := func(err error) (*SyncResult, error) {
+		if !options.DryRun {
+			logSync(true, "Sync of %v stopped after %d changes: %v", namespace, len(changed), err)
+		}
+
+		return nil, err
+	}
+
+	remove := func(doc Document) error {
+		if !options.DryRun {
+			err := p.removeSyncedDocument(doc, options.Delete)
+			if err != nil {
+				return err
+			}
+		}
+
+		changed = append(changed, doc.Id)
+		return nil
+	}
+
+	for key := range producer {
+		path := key.Path()
+		if !inScope(path) || seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		body, err := key.Body()
+		if err != nil {
+			log.Printf("Error getting body of %s: %s", path, err)
+			result.Failed = append(result.Failed, path)
+			continue
+		}
+
+		sum := sha1.Sum(body)
+		sha := hex.EncodeToString(sum[:])
+
+		var current *Document
+		var live []Document
+		for i, doc := range versions[path] {
+			if doc.Sha1 == sha && current == nil {
+				current = &versions[path][i]
+				continue
+			}
+
+			if !isRemoved(&doc) {
+				live = append(live, doc)
+			}
+		}
+
+		switch {
+		case current != nil && isRemoved(current):
+			result.Restored = append(result.Restored, path)
+			changed = append(changed, current.Id)
+
+			if !options.DryRun {
+				err = p.restoreSyncedDocument(*current)
+			}
+		case current != nil:
+			result.Unchanged++
+		default:
+			if len(live) == 0 {
+				result.Added = append(result.Added, path)
+			} else {
+				result.Updated = append(result.Updated, path)
+			}
+
+			if !options.DryRun {
+				var doc *Document
+				doc, err = p.CreateTaggedDocument(path, namespace, body, key.Metadata(), key.Tags())
+				if err == nil {
+					changed = append(changed, doc.Id)
+				}
+			}
+		}
+
+		if err != nil {
+			log.Printf("Error syncing %s: %s", path, err)
+			result.Failed = append(result.Failed, path)
+			continue
+		}
+
+		// older versions of the file are replaced by the current one
+		for _, doc := range live {
+			err := remove(doc)
+			if err != nil {
+				return abort(err)
+			}
+		}
+	}
+
+	for path, docs := range versions {
+		if seen[path] {
+			continue
+		}
+
+		gone := false
+		for _, doc := range docs {
+			if isRemoved(&doc) {
+				continue
+			}
+
+			gone = true
+			err := remove(doc)
+			if err != nil {
+				return abort(err)
+			}
+		}
+
+		if gone {
+			result.Removed = append(result.Removed, path)
+		}
+	}
+
+	if options.DryRun {
+		return &result, nil
+	}
+
+	logSync(len(result.Failed) > 0, "Synced %v: %d added, %d updated, %d restored, %d removed, %d unchanged, %d failed",
+		namespace, len(result.Added), len(result.Updated), len(result.Restored), len(result.Removed), result.Unchanged, len(result.Failed))
+
+	return &result, nil
+}
+
+// isRemoved checks if a sync found the document's file gone.
+func isRemoved(doc *Document) bool {
+	for _, tag := range doc.Tags {
+		if tag == RemovedTag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// removeSyncedDocument deletes the document or tags it as removed.
+func (p *ParaphraseDb) removeSyncedDocument(doc Document, delete bool) error {
+	if delete {
+		err := p.store.DeleteDocument(doc.Id)
+		if err != nil {
+			return err
+		}
+
+		p.logChange(ChangeLogEntry{Operation: OpDeleteDocument, Documents: []int64{doc.Id}, Namespaces: []string{doc.Namespace}}, "Deleted document %v", doc.Id)
+		return nil
+	}
+
+	metadata := make(map[string]string)
+	for k, v := range doc.Metadata {
+		metadata[k] = v
+	}
+	metadata[RemovedAtKey] = time.Now().UTC().Format(time.RFC3339)
+
+	doc.Metadata = metadata
+	doc.Tags = append(append([]string{}, doc.Tags...), RemovedTag)
+
+	return p.UpdateMetadata(&doc)
+}
+
+// restoreSyncedDocument undoes removeSyncedDocument tagging the document.
+func (p *ParaphraseDb) restoreSyncedDocument(doc Document) error {
+	metadata := make(map[string]string)
+	for k, v := range doc.Metadata {
+		if k != RemovedAtKey {
+			metadata[k] = v
+		}
+	}
+
+	var tags []string
+	for _, tag := range doc.Tags {
+		if tag != RemovedTag {
+			tags = append(tags, tag)
+		}
+	}
+
+	doc.Metadata = metadata
+	doc.Tags = tags
+
+	return p.UpdateMetadata(&doc)
+}
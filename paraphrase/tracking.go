@@ -3,54 +3,468 @@
 package paraphrase
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"os/user"
 	"text/tabwriter"
 	"time"
+
+	"github.com/bradhe/stopwatch"
+)
+
+// Version is the version of paraphrase recorded with each change, the
+// command line sets it from the build.
+var Version string
+
+// Operation is the kind of change a changelog entry records.
+type Operation string
+
+const (
+	OpCreateDatabase Operation = "create-database"
+	OpSaveSettings   Operation = "save-settings"
+	OpMigrate        Operation = "migrate"
+	OpAddDocuments   Operation = "add-documents"
+	OpCreateDocument Operation = "create-document"
+	OpUpdateMetadata Operation = "update-metadata"
+	OpDeleteDocument Operation = "delete-document"
+	OpImport         Operation = "import"
+	OpReport         Operation = "report"
+	OpBackup         Operation = "backup"
+	OpRestore        Operation = "restore"
+	OpCompact        Operation = "compact"
+	OpFsck           Operation = "fsck"
+	OpVerdict        Operation = "verdict"
+	OpSync           Operation = "sync"
+	OpHook           Operation = "hook"
 )
 
+// ChangeLogEntry records a change to the database. Entries written before
+// operations were recorded only have a User, Date and Change.
+//
+// Entries are chained: each holds the hash of the one before it and a hash
+// of its own content including that, so editing, deleting or reordering
+// entries breaks the chain. See VerifyChanges.
 type ChangeLogEntry struct {
-	Id     int `storm:"id,increment"`
-	User   string
-	Date   time.Time
-	Change string
+	Id     int       `storm:"id,increment" json:"id"`
+	User   string    `json:"user"`
+	Date   time.Time `json:"date"`
+	Change string    `json:"change"`
+
+	Operation Operation `json:"operation"`
+	// Documents and Namespaces are the ids and namespaces of the documents
+	// the change affected.
+	Documents  []int64       `json:"documents,omitempty"`
+	Namespaces []string      `json:"namespaces,omitempty"`
+	Duration   time.Duration `json:"duration_ns"`
+	Failed     bool          `json:"failed"`
+	// Version is the version of paraphrase that made the change.
+	Version string `json:"version"`
+	// Settings are the database's settings at the time, nil for changes
+	// made without them e.g. migrations.
+	Settings *Settings `json:"settings,omitempty"`
+	// Sha1 is the SHA1 of the body of the document a create-document entry
+	// added.
+	Sha1 string `json:"sha1,omitempty"`
+
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
 }
 
-func (p *ParaphraseDb) logChange(format string, vargs ...interface{}) {
-	usr, err := user.Current()
+// chainChange numbers the change after prev and links it to prev, which is
+// nil for the first change.
+func chainChange(prev, change *ChangeLogEntry) {
+	change.Id = 1
+	if prev != nil {
+		change.Id = prev.Id + 1
+	}
 
-	username := "USER NOT FOUND"
-	if err == nil {
-		username = usr.Name
-	} else {
-		log.Printf("Error getting username %v\n", err)
+	linkChange(prev, change)
+}
+
+// linkChange sets the hashes of the change following prev.
+func linkChange(prev, change *ChangeLogEntry) {
+	change.PrevHash = ""
+	if prev != nil {
+		change.PrevHash = prev.Hash
 	}
 
-	change := fmt.Sprintf(format, vargs...)
+	change.Hash = change.ContentHash()
+}
 
-	cle := ChangeLogEntry{0, username, time.Now(), change}
+// ContentHash hashes everything in the entry except Hash. Fields are written
+// in a fixed order with their lengths so adding fields to the struct later
+// doesn't change the hashes of old entries.
+func (c *ChangeLogEntry) ContentHash() string {
+	hash := sha256.New()
 
-	err = p.db.Save(&cle)
+	field := func(value string) {
+		fmt.Fprintf(hash, "%d:%s\n", len(value), value)
+	}
+
+	field(fmt.Sprint(c.Id))
+	field(c.PrevHash)
+	field(c.User)
+	field(c.Date.UTC().Format(time.RFC3339Nano))
+	field(c.Change)
+	field(string(c.Operation))
+	field(fmt.Sprint(c.Documents))
+	field(fmt.Sprintf("%q", c.Namespaces))
+	field(fmt.Sprint(int64(c.Duration)))
+	field(fmt.Sprint(c.Failed))
+	field(c.Version)
+	if s := c.Settings; s != nil {
+		field(fmt.Sprintf("%d %d %d %v %s", s.Version, s.WindowSize, s.FingerprintSize, s.RobustHash, s.CreatedAt.UTC().Format(time.RFC3339Nano)))
+	} else {
+		field("")
+	}
+	field(c.Sha1)
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// logChange records a change, details describes it and the message is
+// formatted from format and vargs. Errors are logged rather than returned.
+func (p *ParaphraseDb) logChange(details ChangeLogEntry, format string, vargs ...interface{}) {
+	_, err := p.appendChange(details, format, vargs...)
 	if err != nil {
 		log.Printf("Error writing changelog entry %v\n", err)
 	}
 }
 
-func (p *ParaphraseDb) WriteChanges(writer io.Writer) {
+// appendChange records a change like logChange, returning the entry as
+// saved.
+func (p *ParaphraseDb) appendChange(details ChangeLogEntry, format string, vargs ...interface{}) (*ChangeLogEntry, error) {
+	change := newChangeLogEntry(details, format, vargs...)
+
+	settings := p.settings
+	change.Settings = &settings
+
+	err := p.store.AppendChange(change)
+	if err != nil {
+		return nil, err
+	}
+
+	return change, nil
+}
+
+func newChangeLogEntry(details ChangeLogEntry, format string, vargs ...interface{}) *ChangeLogEntry {
+	change := details
+
+	change.Id = 0
+	change.PrevHash = ""
+	change.Hash = ""
+	change.User = currentUser()
+	change.Date = time.Now()
+	change.Change = fmt.Sprintf(format, vargs...)
+	change.Version = Version
+
+	return &change
+}
+
+// currentUser gets the name of the user running paraphrase for the record.
+func currentUser() string {
+	usr, err := user.Current()
+	if err != nil {
+		log.Printf("Error getting username %v\n", err)
+		return "USER NOT FOUND"
+	}
+
+	return usr.Name
+}
+
+// elapsed converts a stopped watch to a duration.
+func elapsed(watch stopwatch.Watch) time.Duration {
+	return time.Duration(watch.Milliseconds()) * time.Millisecond
+}
+
+// documentIds gets the ids and distinct namespaces of documents for the
+// changelog.
+func documentIds(docs []Document) (ids []int64, namespaces []string) {
+	seen := make(map[string]bool)
+
+	for _, doc := range docs {
+		ids = append(ids, doc.Id)
+
+		if !seen[doc.Namespace] {
+			seen[doc.Namespace] = true
+			namespaces = append(namespaces, doc.Namespace)
+		}
+	}
+
+	return ids, namespaces
+}
+
+// ChangeFilter picks changelog entries, blank fields match everything.
+type ChangeFilter struct {
+	Since time.Time
+	Until time.Time
+	// Users and Operations match entries with any of the values.
+	Users      []string
+	Operations []Operation
+	// Document matches entries affecting the document with this id.
+	Document int64
+	// Namespace matches entries affecting documents in the namespace.
+	Namespace string
+}
+
+// Match checks if the filter picks the entry.
+func (f *ChangeFilter) Match(change *ChangeLogEntry) bool {
+	if !f.Since.IsZero() && change.Date.Before(f.Since) {
+		return false
+	}
+
+	if !f.Until.IsZero() && !change.Date.Before(f.Until) {
+		return false
+	}
+
+	if len(f.Users) > 0 && !containsString(f.Users, change.User) {
+		return false
+	}
+
+	if len(f.Operations) > 0 {
+		found := false
+		for _, op := range f.Operations {
+			found = found || op == change.Operation
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if f.Document != 0 {
+		found := false
+		for _, id := range change.Documents {
+			found = found || id == f.Document
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if f.Namespace != "" && !containsString(change.Namespaces, f.Namespace) {
+		return false
+	}
+
+	return true
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Changes gets the changelog entries the filter picks, oldest first.
+func (p *ParaphraseDb) Changes(filter ChangeFilter) ([]ChangeLogEntry, error) {
 	var changes []ChangeLogEntry
 
-	p.db.Select().Find(&changes)
+	err := p.store.EachChange(func(change *ChangeLogEntry) error {
+		if filter.Match(change) {
+			changes = append(changes, *change)
+		}
+		return nil
+	})
 
+	return changes, err
+}
+
+// WriteChanges writes the changes in fashion suitable for displaying
+// on-screen.
+func WriteChanges(writer io.Writer, changes []ChangeLogEntry) {
 	w := new(tabwriter.Writer)
 	w.Init(writer, 0, 8, 2, '\t', 0)
 
-	fmt.Fprintln(w, "Id\tUsername\tDate\tLog")
+	fmt.Fprintln(w, "Id\tUsername\tDate\tOperation\tLog")
 
 	for _, change := range changes {
-		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", change.Id, change.User, change.Date, change.Change)
+		op := change.Operation
+		if op == "" {
+			op = "-"
+		}
+
+		text := change.Change
+		if change.Failed {
+			text += " (failed)"
+		}
+
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", change.Id, change.User, change.Date, op, text)
 	}
 
 	w.Flush()
 }
+
+// WriteChangesJson writes the changes as a JSON array.
+func WriteChangesJson(w io.Writer, changes []ChangeLogEntry) error {
+	if changes == nil {
+		changes = []ChangeLogEntry{}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(changes)
+}
+
+// ParseOperation reads the name of an operation.
+func ParseOperation(name string) (Operation, error) {
+	switch op := Operation(name); op {
+	case OpCreateDatabase, OpSaveSettings, OpMigrate, OpAddDocuments, OpCreateDocument,
+		OpUpdateMetadata, OpDeleteDocument, OpImport, OpReport, OpBackup, OpRestore, OpCompact, OpFsck,
+		OpVerdict, OpSync, OpHook:
+		return op, nil
+	default:
+		return "", fmt.Errorf("Unknown operation %q", name)
+	}
+}
+
+// ChangeProblem is a sign the changelog or the documents it records were
+// tampered with.
+type ChangeProblem struct {
+	// Change is the entry with the problem, 0 if it's about a document.
+	Change int
+	// Document is the document with the problem, 0 if it's about an entry.
+	Document int64
+	Detail   string
+}
+
+// ChangeVerification is the outcome of VerifyChanges.
+type ChangeVerification struct {
+	Entries   int
+	Documents int
+	// Unrecorded is the number of documents added before their creation was
+	// recorded in the changelog, they can't be verified.
+	Unrecorded int
+	// Head is the hash of the last entry. Keeping a copy somewhere else lets
+	// later verifications detect entries removed from the end.
+	Head     string
+	Problems []ChangeProblem
+}
+
+// creationEntry finds the entry recording the creation of the document with
+// the given id, nil if there isn't one.
+func (p *ParaphraseDb) creationEntry(id int64) (creation *ChangeLogEntry, err error) {
+	err = p.store.EachChange(func(change *ChangeLogEntry) error {
+		if change.Operation == OpCreateDocument && len(change.Documents) == 1 && change.Documents[0] == id {
+			entry := *change
+			creation = &entry
+		}
+
+		return nil
+	})
+
+	return creation, err
+}
+
+// VerifyChanges checks every changelog entry's hash and its link to the entry
+// before it, and that each document matches the entry recording its
+// creation. If head isn't blank it must be the hash of one of the entries.
+func (p *ParaphraseDb) VerifyChanges(head string) (*ChangeVerification, error) {
+	var result ChangeVerification
+	entries := make(map[int]*ChangeLogEntry)
+	created := make(map[int64]int)
+	foundHead := false
+
+	problem := func(change int, doc int64, format string, vargs ...interface{}) {
+		result.Problems = append(result.Problems, ChangeProblem{change, doc, fmt.Sprintf(format, vargs...)})
+	}
+
+	var prev *ChangeLogEntry
+	err := p.store.EachChange(func(change *ChangeLogEntry) error {
+		entry := *change
+		entries[entry.Id] = &entry
+		result.Entries++
+
+		switch {
+		case entry.Hash == "":
+			problem(entry.Id, 0, "entry isn't hashed")
+		case entry.ContentHash() != entry.Hash:
+			problem(entry.Id, 0, "entry was edited since it was written")
+		}
+
+		if prev != nil && entry.Id <= prev.Id {
+			problem(entry.Id, 0, "entry is out of order after %d", prev.Id)
+		}
+
+		expected := ""
+		if prev != nil {
+			expected = prev.Hash
+		}
+
+		if entry.PrevHash != expected {
+			problem(entry.Id, 0, "entry doesn't follow the one before it, entries were deleted, added or reordered")
+		}
+
+		if entry.Operation == OpCreateDocument && len(entry.Documents) == 1 {
+			created[entry.Documents[0]] = entry.Id
+		}
+
+		foundHead = foundHead || (head != "" && entry.Hash == head)
+		result.Head = entry.Hash
+		prev = &entry
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if head != "" && !foundHead {
+		problem(0, 0, "no entry has the hash %s, entries were removed from the end or replaced", head)
+	}
+
+	err = p.store.EachDocument(func(doc *Document) error {
+		result.Documents++
+
+		creation, recorded := created[doc.Id]
+		if doc.ChangeId == 0 && !recorded {
+			result.Unrecorded++
+			return nil
+		}
+
+		entry, ok := entries[doc.ChangeId]
+		switch {
+		case recorded && doc.ChangeId != creation:
+			problem(0, doc.Id, "entry %d records the document's creation but the document doesn't link to it", creation)
+		case !ok:
+			problem(0, doc.Id, "the entry recording the document's creation (%d) is missing", doc.ChangeId)
+		case entry.Operation != OpCreateDocument || len(entry.Documents) != 1 || entry.Documents[0] != doc.Id:
+			problem(0, doc.Id, "entry %d doesn't record the document's creation", doc.ChangeId)
+		case entry.Sha1 != doc.Sha1:
+			problem(0, doc.Id, "the document's SHA1 doesn't match entry %d", doc.ChangeId)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.store.EachDocumentData(func(data *DocumentData) error {
+		doc, err := p.store.DocumentHeader(data.Id)
+		if err == NotFoundErr {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if doc.Sha1 != data.BodySha1() {
+			problem(0, data.Id, "the document's body was changed since it was added")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
@@ -0,0 +1,262 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+package paraphrase
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bradfitz/slice"
+)
+
+// Verdict is the outcome of reviewing a pair of similar documents.
+type Verdict string
+
+const (
+	auditHeader = "Date\tReviewer\tVerdict\tA\tPath A\tB\tPath B\tNotes"
+	auditFormat = "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n"
+)
+
+const (
+	Confirmed   Verdict = "confirmed"
+	Dismissed   Verdict = "dismissed"
+	NeedsReview Verdict = "needs review"
+)
+
+// ParseVerdict reads a verdict, "needs-review" is accepted so it can be
+// given without quotes.
+func ParseVerdict(text string) (Verdict, error) {
+	switch verdict := Verdict(strings.Replace(strings.ToLower(text), "-", " ", -1)); verdict {
+	case Confirmed, Dismissed, NeedsReview:
+		return verdict, nil
+	default:
+		return "", fmt.Errorf("Unknown verdict %q, expected confirmed, dismissed or needs-review", text)
+	}
+}
+
+// PairVerdict records who decided what about a pair of documents.
+type PairVerdict struct {
+	// Id is the pair's key, see PairKey.
+	Id       string `storm:"id"`
+	A        int64
+	B        int64
+	Verdict  Verdict
+	Reviewer string
+	Date     time.Time
+	// Notes are free text about the case e.g. what the students said.
+	Notes string
+	// History holds earlier verdicts on the pair, oldest first.
+	History []VerdictChange
+}
+
+// VerdictChange is a verdict that has since been replaced.
+type VerdictChange struct {
+	Verdict  Verdict
+	Reviewer string
+	Date     time.Time
+	Notes    string
+}
+
+// String describes the verdict e.g. "dismissed by alice on 2017-06-01".
+func (v *PairVerdict) String() string {
+	return fmt.Sprintf("%s by %s on %s", v.Verdict, v.Reviewer, v.Date.Format("2006-01-02"))
+}
+
+// PairKey identifies a pair of documents in either order.
+func PairKey(a, b int64) string {
+	if a > b {
+		a, b = b, a
+	}
+
+	return fmt.Sprintf("%d-%d", a, b)
+}
+
+// SetVerdict records the verdict on a pair of documents for the current
+// user. Any earlier verdict is moved to the history, its notes are kept if
+// notes is blank.
+func (p *ParaphraseDb) SetVerdict(a, b int64, verdict Verdict, notes string) (*PairVerdict, error) {
+	if a > b {
+		a, b = b, a
+	}
+
+	record := &PairVerdict{
+		Id:       PairKey(a, b),
+		A:        a,
+		B:        b,
+		Verdict:  verdict,
+		Reviewer: currentUser(),
+		Date:     time.Now(),
+		Notes:    notes,
+	}
+
+	old, err := p.store.Verdict(record.Id)
+	switch {
+	case err == nil:
+		record.History = append(old.History, VerdictChange{old.Verdict, old.Reviewer, old.Date, old.Notes})
+		if notes == "" {
+			record.Notes = old.Notes
+		}
+	case err != NotFoundErr:
+		return nil, err
+	}
+
+	err = p.store.SaveVerdict(record)
+	if err != nil {
+		return nil, err
+	}
+
+	// the history can be rewritten, the chained changelog can't without it
+	// showing
+	p.logChange(ChangeLogEntry{Operation: OpVerdict, Documents: []int64{a, b}}, "Marked documents %d and %d as %s by %s, notes: %q",
+		a, b, verdict, record.Reviewer, record.Notes)
+	return record, nil
+}
+
+// FindVerdict gets the verdict on a pair of documents, nil if there isn't
+// one.
+func (p *ParaphraseDb) FindVerdict(a, b int64) (*PairVerdict, error) {
+	verdict, err := p.store.Verdict(PairKey(a, b))
+	if err == NotFoundErr {
+		return nil, nil
+	}
+
+	return verdict, err
+}
+
+// Verdicts gets every verdict keyed by PairKey.
+func (p *ParaphraseDb) Verdicts() (map[string]*PairVerdict, error) {
+	verdicts := make(map[string]*PairVerdict)
+
+	err := p.store.EachVerdict(func(verdict *PairVerdict) error {
+		record := *verdict
+		verdicts[verdict.Id] = &record
+		return nil
+	})
+
+	return verdicts, err
+}
+
+// AuditEntry is one verdict ever made on a pair of documents.
+type AuditEntry struct {
+	Date     time.Time `json:"date"`
+	Reviewer string    `json:"reviewer"`
+	Verdict  Verdict   `json:"verdict"`
+	Notes    string    `json:"notes"`
+	A        int64     `json:"a"`
+	PathA    string    `json:"path_a"`
+	B        int64     `json:"b"`
+	PathB    string    `json:"path_b"`
+	// Current is set on the verdict still in effect for the pair.
+	Current bool `json:"current"`
+}
+
+// AuditTrail gets every verdict including the ones replaced since, oldest
+// first. Paths of documents deleted since are blank.
+func (p *ParaphraseDb) AuditTrail() ([]AuditEntry, error) {
+	var entries []AuditEntry
+	paths := make(map[int64]string)
+
+	path := func(id int64) (string, error) {
+		if path, ok := paths[id]; ok {
+			return path, nil
+		}
+
+		header, err := p.store.DocumentHeader(id)
+		if err != nil && err != NotFoundErr {
+			return "", err
+		}
+
+		paths[id] = header.Path
+		return header.Path, nil
+	}
+
+	err := p.store.EachVerdict(func(verdict *PairVerdict) error {
+		pathA, err := path(verdict.A)
+		if err != nil {
+			return err
+		}
+
+		pathB, err := path(verdict.B)
+		if err != nil {
+			return err
+		}
+
+		changes := append(verdict.History, VerdictChange{verdict.Verdict, verdict.Reviewer, verdict.Date, verdict.Notes})
+		for i, change := range changes {
+			entries = append(entries, AuditEntry{
+				Date:     change.Date,
+				Reviewer: change.Reviewer,
+				Verdict:  change.Verdict,
+				Notes:    change.Notes,
+				A:        verdict.A,
+				PathA:    pathA,
+				B:        verdict.B,
+				PathB:    pathB,
+				Current:  i == len(changes)-1,
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slice.Sort(entries, func(i, j int) bool {
+		return entries[i].Date.Before(entries[j].Date)
+	})
+
+	return entries, nil
+}
+
+// WriteAuditTrail writes the verdicts in fashion suitable for displaying
+// on-screen.
+func WriteAuditTrail(w io.Writer, entries []AuditEntry) {
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+
+	fmt.Fprintln(tw, auditHeader)
+	for _, e := range entries {
+		fmt.Fprintf(tw, auditFormat, e.Date.Format("2006-01-02 15:04"), e.Reviewer, e.Verdict, e.A, e.PathA, e.B, e.PathB, e.Notes)
+	}
+
+	tw.Flush()
+}
+
+// WriteAuditTrailCsv writes one row per verdict.
+func WriteAuditTrailCsv(w io.Writer, entries []AuditEntry) error {
+	out := csv.NewWriter(w)
+
+	out.Write([]string{"date", "reviewer", "verdict", "notes", "a", "path_a", "b", "path_b", "current"})
+	for _, entry := range entries {
+		out.Write([]string{
+			entry.Date.Format(time.RFC3339),
+			entry.Reviewer,
+			string(entry.Verdict),
+			entry.Notes,
+			fmt.Sprint(entry.A),
+			entry.PathA,
+			fmt.Sprint(entry.B),
+			entry.PathB,
+			fmt.Sprint(entry.Current),
+		})
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+// WriteAuditTrailJson writes the verdicts as a JSON array.
+func WriteAuditTrailJson(w io.Writer, entries []AuditEntry) error {
+	if entries == nil {
+		entries = []AuditEntry{}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(entries)
+}
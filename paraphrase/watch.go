@@ -0,0 +1,179 @@
+// Copyright 2017 Joseph Lewis III <joseph@josephlewis.net>
+// Licensed under the MIT License. See LICENSE file for full details.
+package paraphrase
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"github.com/josephlewis42/paraphrase/paraphrase/provider"
+)
+
+// DefaultWatchBatch is how long Watch waits for more files before adding the
+// ones it has.
+const DefaultWatchBatch = 500 * time.Millisecond
+
+// WatchOptions controls how Watch adds and checks documents.
+type WatchOptions struct {
+	// Batch is how long to wait for more files before adding the ones
+	// waiting, DefaultWatchBatch if it's not positive.
+	Batch time.Duration
+	// Check searches for documents like each one added.
+	Check bool
+	// Search limits the documents checked against, Related is set to each
+	// document added. Earlier versions of a file are never reported.
+	Search SearchOptions
+}
+
+// WatchAlert is a document added by Watch that matched existing documents.
+type WatchAlert struct {
+	Doc     Document
+	Matches []SearchResult
+}
+
+// Watch adds the documents from the producer in batches until it's closed,
+// producers like provider.NewWatchProducer run until they're stopped. The
+// database is opened with open for each batch and closed again so other
+// processes can use it between batches, a batch finding it locked is tried
+// again later. Files already stored in their namespace with the same path and
+// SHA1 are skipped so producers may produce the same file more than once. If
+// options.Check is set alert is called with every added document matching
+// others.
+func Watch(open func() (*ParaphraseDb, error), producer provider.DocumentProducer, options WatchOptions, alert func(WatchAlert)) error {
+	if options.Batch <= 0 {
+		options.Batch = DefaultWatchBatch
+	}
+
+	var batch []provider.Document
+	positions := make(map[string]int)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		p, err := open()
+		if err != nil {
+			return err
+		}
+		defer p.Close()
+
+		pending := make(provider.DocumentProducer, len(batch))
+		for _, doc := range batch {
+			body, _ := doc.Body()
+
+			stored, err := p.isStored(doc.Namespace(), doc.Path(), body)
+			if err != nil {
+				return err
+			}
+			if !stored {
+				pending <- doc
+			}
+		}
+		close(pending)
+
+		batch = nil
+		positions = make(map[string]int)
+
+		added, _ := p.AddDocuments(pending)
+		if !options.Check {
+			return nil
+		}
+
+		for _, doc := range added {
+			matches, err := p.watchMatches(doc, options.Search)
+			if err != nil {
+				return err
+			}
+
+			if len(matches) > 0 {
+				alert(WatchAlert{doc, matches})
+			}
+		}
+
+		return nil
+	}
+
+	for {
+		var timeout <-chan time.Time
+		if len(batch) > 0 {
+			timeout = time.After(options.Batch)
+		}
+
+		select {
+		case key, ok := <-producer:
+			if !ok {
+				return flush()
+			}
+
+			body, err := key.Body()
+			if err != nil {
+				// the file may have been moved away already
+				log.Printf("Error getting body of %s: %s", key.Path(), err)
+				continue
+			}
+
+			// only the latest version of a file in a batch is added
+			id := key.Namespace() + "\x00" + key.Path()
+			if i, ok := positions[id]; ok {
+				batch[i] = key.WithBody(body)
+				continue
+			}
+
+			positions[id] = len(batch)
+			batch = append(batch, key.WithBody(body))
+
+		case <-timeout:
+			err := flush()
+			if isLocked(err) {
+				log.Printf("Keeping %d file(s) for the next batch: %s\n", len(batch), err)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// isStored checks if a document with the namespace, path and body exists.
+func (p *ParaphraseDb) isStored(namespace, path string, body []byte) (bool, error) {
+	sum := sha1.Sum(body)
+
+	docs, err := p.FindDocumentsBySha1(hex.EncodeToString(sum[:]))
+	if err != nil {
+		return false, err
+	}
+
+	for _, doc := range docs {
+		if doc.Namespace == namespace && doc.Path == path {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// watchMatches finds the documents like doc except earlier versions of it.
+func (p *ParaphraseDb) watchMatches(doc Document, options SearchOptions) ([]SearchResult, error) {
+	options.Related = nil
+	options.ExcludeSelf = true
+
+	results, err := p.QueryById(doc.Id, options)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []SearchResult
+	for _, result := range results {
+		if result.Doc.Namespace == doc.Namespace && result.Doc.Path == doc.Path {
+			continue
+		}
+
+		matches = append(matches, result)
+	}
+
+	return matches, nil
+}